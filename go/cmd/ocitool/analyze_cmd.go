@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/DataDog/rules_oci/go/pkg/layer"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	analyzeFormatText = "text"
+	analyzeFormatJSON = "json"
+)
+
+// AnalyzeCmd reports the space wasted by the layers of an image.
+func AnalyzeCmd(c *cli.Context) error {
+	minEfficiency := c.Float64("min-efficiency")
+	if minEfficiency < 0 || minEfficiency > 1 {
+		return fmt.Errorf("--min-efficiency must be between 0 and 1, got %v", minEfficiency)
+	}
+
+	// Checked before the layers are read, which can take a while.
+	format := c.String("format")
+	if format != analyzeFormatText && format != analyzeFormatJSON {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	ref := c.Args().First()
+	if ref == "" {
+		ref = c.String("desc")
+	}
+
+	provider, desc, err := LoadImageManifest(c, ref)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := ociutil.ImageManifestFromProvider(c.Context, provider, desc)
+	if err != nil {
+		return err
+	}
+
+	analysis, err := layer.Analyze(c.Context, provider, manifest.Layers)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case analyzeFormatText:
+		printAnalysis(os.Stdout, analysis, c.Int("top"))
+	case analyzeFormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(analysis)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	if analysis.Efficiency < minEfficiency {
+		return fmt.Errorf("efficiency %.2f%% is below the minimum of %.2f%%", analysis.Efficiency*100, minEfficiency*100)
+	}
+
+	return nil
+}
+
+func printAnalysis(w io.Writer, analysis *layer.Analysis, top int) {
+	fmt.Fprintln(w, "layers:")
+	for i, stats := range analysis.Layers {
+		fmt.Fprintf(w, "  %d %s size %s, %d files, content %s, wasted %s\n",
+			i, stats.Digest, humanSize(stats.Size), stats.Files, humanSize(stats.ContentSize), humanSize(stats.WastedSize))
+	}
+
+	waste := analysis.Waste
+	if top >= 0 && len(waste) > top {
+		waste = waste[:top]
+	}
+	if len(waste) > 0 {
+		fmt.Fprintf(w, "top wasted files:\n")
+	}
+	for _, file := range waste {
+		fmt.Fprintf(w, "  %10s %-11s layer %d, by layer %d: %s\n", humanSize(file.Size), file.Kind, file.Layer, file.By, file.Path)
+	}
+
+	fmt.Fprintf(w, "wasted %s of %s, efficiency %.2f%%\n", humanSize(analysis.WastedSize), humanSize(analysis.ContentSize), analysis.Efficiency*100)
+}
@@ -68,11 +68,11 @@ func AppendLayersCmd(c *cli.Context) error {
 		createdTimestamp = time.Unix(timeInt, 0)
 	}
 
-	allLocalProviders := ociutil.MultiProvider(localProviders...)
+	allLocalProviders := localProvider(c, localProviders...)
 
 	// Read the base descriptor, at this point we don't know if it's a image
 	// manifest or index, so it's an unknown media type.
-	baseUnknownDesc, err := ociutil.ReadDescriptorFromFile(c.String("base"))
+	baseUnknownDesc, err := ReadDescriptor(c.String("base"), c.StringSlice("layout"))
 	if err != nil {
 		return err
 	}
@@ -196,14 +196,14 @@ func AppendLayersCmd(c *cli.Context) error {
 			return err
 		}
 
-		layerProvider.Blobs[layerDesc.Digest] = layerAndDescriptorPath.Key
+		layerProvider.Add(layerDesc, layerAndDescriptorPath.Key)
 		layerDescs = append(layerDescs, layerDesc)
 	}
 
 	// Treat raw tar files as if they were any other layer
 	for i, tarPath := range tarPaths {
 		tarDesc := tarDescriptors[i]
-		layerProvider.Blobs[tarDesc.Digest] = tarPath
+		layerProvider.Add(tarDesc, tarPath)
 		layerDescs = append(layerDescs, tarDesc)
 	}
 
@@ -250,8 +250,9 @@ func AppendLayersCmd(c *cli.Context) error {
 		return err
 	}
 
-	layerProvider.Blobs[newManifest.Digest] = c.String("out-manifest")
-	layerProvider.Blobs[newConfig.Digest] = c.String("out-config")
+	layerProvider.Add(newManifest, c.String("out-manifest"))
+	layerProvider.Add(newConfig, c.String("out-config"))
+	layerProvider.SetRoot(defaultRootName, newManifest)
 
 	err = layerProvider.WriteToFile(c.String("out-layout"))
 	if err != nil {
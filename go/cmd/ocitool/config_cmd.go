@@ -18,10 +18,10 @@ func ConfigCmd(c *cli.Context) error {
 		return err
 	}
 
-	allLocalProviders := ociutil.MultiProvider(localProviders...)
+	allLocalProviders := localProvider(c, localProviders...)
 
 	// Read the base descriptor. Its unknown since we don't know if it's an image or index.
-	baseUnknownDesc, err := ociutil.ReadDescriptorFromFile(c.String("base"))
+	baseUnknownDesc, err := ReadDescriptor(c.String("base"), c.StringSlice("layout"))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	storageFormatBlobIndex = "blob-index"
+	storageFormatOCILayout = "oci-layout"
+)
+
+var storageFormats = []string{
+	storageFormatBlobIndex,
+	storageFormatOCILayout,
+	exportFormatOCIArchive,
+	exportFormatDockerArchive,
+}
+
+// storageLocation is an image storage, of the form <format>:<path>.
+type storageLocation struct {
+	Format string
+	Path   string
+}
+
+func parseStorageLocation(value string) (storageLocation, error) {
+	format, path, ok := strings.Cut(value, ":")
+	if !ok || path == "" {
+		return storageLocation{}, fmt.Errorf("invalid storage %q, expected <format>:<path>", value)
+	}
+
+	for _, f := range storageFormats {
+		if format == f {
+			return storageLocation{Format: format, Path: path}, nil
+		}
+	}
+
+	return storageLocation{}, fmt.Errorf("unknown storage format %q, expected one of %v", format, storageFormats)
+}
+
+// ConvertCmd converts an image between the storage formats: blob index files,
+// OCI image layout directories, OCI image layout tarballs and `docker save`
+// tarballs.
+func ConvertCmd(c *cli.Context) error {
+	from, err := parseStorageLocation(c.String("from"))
+	if err != nil {
+		return err
+	}
+
+	to, err := parseStorageLocation(c.String("to"))
+	if err != nil {
+		return err
+	}
+
+	// Checked before the image is read, which can take a while.
+	linkMode, err := ociutil.ParseLinkMode(c.String("link"))
+	if err != nil {
+		return err
+	}
+
+	// Archives are imported into a temporary store to be read.
+	tmpDir, err := os.MkdirTemp("", "ocitool-convert-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	provider, desc, err := readStorage(c, from, tmpDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %v: %w", from.Path, err)
+	}
+
+	log.WithField("desc", desc).Debugf("converting %v to %v", from.Format, to.Format)
+
+	err = writeStorage(c, to, linkMode, provider, desc)
+	if err != nil {
+		return fmt.Errorf("failed to write %v: %w", to.Path, err)
+	}
+
+	return nil
+}
+
+// readStorage returns a provider of the blobs of the storage and the root
+// descriptor selected by --desc.
+func readStorage(c *cli.Context, from storageLocation, tmpDir string) (content.Provider, ocispec.Descriptor, error) {
+	ref := c.String("desc")
+
+	switch from.Format {
+	case storageFormatBlobIndex, storageFormatOCILayout:
+		if ociutil.IsOciLayoutDir(from.Path) != (from.Format == storageFormatOCILayout) {
+			return nil, ocispec.Descriptor{}, fmt.Errorf("%v isn't a %v", from.Path, from.Format)
+		}
+
+		providers, err := LoadLocalProviders([]string{from.Path}, "")
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+
+		desc, err := storageRoot(from.Path, ref)
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+
+		return localProvider(c, providers...), desc, nil
+	default:
+		store, err := local.NewStore(tmpDir)
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+
+		f, err := os.Open(from.Path)
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+		defer f.Close()
+
+		// Both kinds of archives are detected by the import.
+		desc, err := ociutil.ImportArchive(c.Context, store, f, ref)
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+
+		return store, desc, nil
+	}
+}
+
+// storageRoot returns the root descriptor of a blob index or layout directory
+// selected by ref, a descriptor file or ref name. If ref is empty, the
+// storage must have exactly one root.
+func storageRoot(path, ref string) (ocispec.Descriptor, error) {
+	if ref != "" {
+		return ReadDescriptor(ref, []string{path})
+	}
+
+	roots, err := LayoutRoots([]string{path})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	if len(roots) != 1 {
+		return ocispec.Descriptor{}, fmt.Errorf("%v has %d roots, select one with --desc", path, len(roots))
+	}
+
+	return roots[0], nil
+}
+
+func writeStorage(c *cli.Context, to storageLocation, linkMode ociutil.LinkMode, provider content.Provider, desc ocispec.Descriptor) error {
+	refName := c.String("ref-name")
+
+	switch to.Format {
+	case storageFormatBlobIndex:
+		// The blobs are written next to the blob index, like import does.
+		root := filepath.Dir(to.Path)
+
+		ing, err := copyToLayout(c, provider, root, linkMode, desc)
+		if err != nil {
+			return err
+		}
+
+		ing.RemoveIngestDir()
+
+		bi, err := layoutBlobIndex(c.Context, provider, root, desc)
+		if err != nil {
+			return err
+		}
+
+		if refName == "" {
+			refName = desc.Annotations[images.AnnotationImageName]
+		}
+		if refName == "" {
+			refName = defaultRootName
+		}
+		bi.SetRoot(refName, desc)
+
+		return bi.WriteToFile(to.Path)
+	case storageFormatOCILayout:
+		ing, err := copyToLayout(c, provider, to.Path, linkMode, desc)
+		if err != nil {
+			return err
+		}
+
+		ing.AddReference(desc, refName)
+
+		return ing.SaveIndex()
+	default:
+		out, err := os.Create(to.Path)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		err = writeArchive(c, provider, out, to.Format, desc)
+		if err != nil {
+			return err
+		}
+
+		return out.Close()
+	}
+}
+
+// copyToLayout copies desc and all of its children to the blobs directory of
+// the layout at root, and returns the ingester of the layout.
+func copyToLayout(c *cli.Context, provider content.Provider, root string, linkMode ociutil.LinkMode, desc ocispec.Descriptor) (*ociutil.OciImageLayoutIngester, error) {
+	ing, err := ociutil.NewOciImageLayoutIngester(root)
+	if err != nil {
+		return nil, err
+	}
+	ing.LinkMode = linkMode
+
+	err = ociutil.CopyChildrenFromHandler(c.Context, images.ChildrenHandler(provider), provider, ing, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ociutil.CopyContent(c.Context, provider, ing, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	return ing, nil
+}
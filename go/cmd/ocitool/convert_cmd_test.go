@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/opencontainers/go-digest"
+	ocispecv "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestParseStorageLocation(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected storageLocation
+		err      bool
+	}{
+		{input: "blob-index:out/index.json", expected: storageLocation{Format: storageFormatBlobIndex, Path: "out/index.json"}},
+		{input: "oci-layout:/tmp/layout", expected: storageLocation{Format: storageFormatOCILayout, Path: "/tmp/layout"}},
+		{input: "docker-archive:c:/image.tar", expected: storageLocation{Format: exportFormatDockerArchive, Path: "c:/image.tar"}},
+		{input: "oci-archive:", err: true},
+		{input: "image.tar", err: true},
+		{input: "registry:ghcr.io/foo/bar", err: true},
+	} {
+		actual, err := parseStorageLocation(tc.input)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseStorageLocation(%q) = %+v, but expected an error", tc.input, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseStorageLocation(%q) unexpectedly returned an error. Error: %v", tc.input, err)
+		}
+		if actual != tc.expected {
+			t.Errorf("parseStorageLocation(%q) = %+v, but expected %+v", tc.input, actual, tc.expected)
+		}
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	src := &blob.Index{}
+	writeBlob := func(name, mediaType string, data []byte) ocispec.Descriptor {
+		err := os.WriteFile(path(name), data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		desc := ocispec.Descriptor{
+			MediaType: mediaType,
+			Digest:    digest.FromBytes(data),
+			Size:      int64(len(data)),
+		}
+		src.Add(desc, path(name))
+
+		return desc
+	}
+	writeJSON := func(name, mediaType string, v interface{}) ocispec.Descriptor {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return writeBlob(name, mediaType, data)
+	}
+
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	err := tw.WriteHeader(&tar.Header{Name: "hello.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("hello"))
+	tw.Close()
+
+	layerDesc := writeBlob("layer.tar", ocispec.MediaTypeImageLayer, layer.Bytes())
+	config := writeJSON("config.json", ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{layerDesc.Digest}},
+	})
+	manifest := writeJSON("manifest.json", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: ocispecv.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	})
+	src.AddRoot("app", manifest)
+
+	err = os.Mkdir(path("src"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = src.WriteToFile(path("src/image.blob-index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sortedDigests := func(dgsts map[digest.Digest]string) []digest.Digest {
+		var sorted []digest.Digest
+		for dgst := range dgsts {
+			sorted = append(sorted, dgst)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		return sorted
+	}
+	expectedBlobs := sortedDigests(src.Blobs)
+
+	// expectStorage checks that the blob index or layout at p has the same
+	// root and blobs as the source.
+	expectStorage := func(t *testing.T, p string) {
+		t.Helper()
+
+		root, err := storageRoot(p, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root.Digest != manifest.Digest {
+			t.Errorf("expected the root of %v to be %v, got %v", p, manifest.Digest, root.Digest)
+		}
+
+		providers, err := LoadLocalProviders([]string{p}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := sortedDigests(providers[0].(*blob.Index).Blobs)
+		if len(got) != len(expectedBlobs) {
+			t.Fatalf("expected the blobs of %v to be %v, got %v", p, expectedBlobs, got)
+		}
+		for i := range got {
+			if got[i] != expectedBlobs[i] {
+				t.Fatalf("expected the blobs of %v to be %v, got %v", p, expectedBlobs, got)
+			}
+		}
+
+		layoutDir := p
+		if !ociutil.IsOciLayout(os.DirFS(p)) {
+			layoutDir = filepath.Dir(p)
+		}
+		if _, err := os.Stat(filepath.Join(layoutDir, "ingest")); !os.IsNotExist(err) {
+			t.Errorf("expected no ingest directory in %v, got %v", layoutDir, err)
+		}
+	}
+
+	for _, step := range []struct {
+		from, to string
+	}{
+		{from: "blob-index:" + path("src/image.blob-index.json"), to: "oci-layout:" + path("layout")},
+		{from: "oci-layout:" + path("layout"), to: "oci-archive:" + path("image.tar")},
+		{from: "oci-archive:" + path("image.tar"), to: "blob-index:" + path("dst/image.blob-index.json")},
+	} {
+		err := app.Run([]string{"ocitool", "convert", "--from", step.from, "--to", step.to})
+		if err != nil {
+			t.Fatalf("failed to convert %v to %v: %v", step.from, step.to, err)
+		}
+	}
+
+	expectStorage(t, path("layout"))
+	expectStorage(t, path("dst/image.blob-index.json"))
+
+	// The blobs of the final blob index are copies, not the source files.
+	dst, err := LoadLocalProviders([]string{path("dst/image.blob-index.json")}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dgst, p := range dst[0].(*blob.Index).Blobs {
+		if filepath.Dir(filepath.Dir(filepath.Dir(p))) != path("dst") {
+			t.Errorf("expected blob %v to be in %v, got %v", dgst, path("dst"), p)
+		}
+	}
+
+	err = ociutil.WriteDescriptorToFile(path("manifest.desc.json"), manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.Run([]string{"ocitool", "--layout", path("dst/image.blob-index.json"), "fsck", "--desc", path("manifest.desc.json")})
+	if err != nil {
+		t.Fatalf("expected the converted image to be valid, got %v", err)
+	}
+}
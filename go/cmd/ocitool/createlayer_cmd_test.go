@@ -17,7 +17,7 @@ func TestParseIntWorksAsExpected(t *testing.T) {
 	} {
 		actual, err := strconv.ParseInt(tc.input, 0, 64)
 		if err != nil {
-			t.Errorf("ParseInt(%q, 0, 64) unexpectedly returned an error. Error: %w", tc.input, err)
+			t.Errorf("ParseInt(%q, 0, 64) unexpectedly returned an error. Error: %v", tc.input, err)
 		}
 		if actual != tc.expected {
 			t.Errorf(
@@ -2,24 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/DataDog/rules_oci/go/pkg/blob"
 	"github.com/DataDog/rules_oci/go/pkg/ociutil"
 
 	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v2"
 )
 
 var (
 	ErrNoResolvePlatform = fmt.Errorf("failed to resolve platform")
 )
 
-func FilePathsToDescriptors(ctx context.Context, descriptorPaths []string, resolvePlatforms bool, provider content.Provider) ([]ocispec.Descriptor, error) {
+// defaultRootName is the name of the root of the blob indexes written by the
+// commands, when the image has no name.
+const defaultRootName = "latest"
+
+// refNamePrefix is the prefix of the descriptor arguments that are ref names
+// in layouts rather than descriptor files, e.g. "ref:latest".
+const refNamePrefix = "ref:"
+
+func FilePathsToDescriptors(ctx context.Context, descriptorPaths []string, layoutPaths []string, resolvePlatforms bool, provider content.Provider) ([]ocispec.Descriptor, error) {
 	descriptors := make([]ocispec.Descriptor, 0, len(descriptorPaths))
 
 	for _, descPath := range descriptorPaths {
-		desc, err := ociutil.ReadDescriptorFromFile(descPath)
+		desc, err := ReadDescriptor(descPath, layoutPaths)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load descriptors for index: %w", err)
 		}
@@ -40,25 +57,264 @@ func FilePathsToDescriptors(ctx context.Context, descriptorPaths []string, resol
 	return descriptors, nil
 }
 
+// LoadLocalProviders loads a provider for each of layoutPaths, which are
+// either blob index files or OCI Image Layout directories. The blob paths of
+// the blob index files are made relative to relPath, if given, the ones of
+// layouts are already relative to the working directory.
 func LoadLocalProviders(layoutPaths []string, relPath string) ([]content.Provider, error) {
 	providers := make([]content.Provider, 0, len(layoutPaths))
 	for _, path := range layoutPaths {
-		provider, err := blob.LoadIndexFromFile(path)
+		blobIdx, err := ociutil.LoadLayout(path)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load layout (%v): %w", path, err)
 		}
 
-		if relPath != "" {
-			blobIdx := provider.(*blob.Index)
-
-			provider, err = blobIdx.Rel(relPath)
+		if relPath != "" && !ociutil.IsOciLayoutDir(path) {
+			blobIdx, err = blobIdx.Rel(relPath)
 			if err != nil {
 				return nil, err
 			}
 		}
 
-		providers = append(providers, provider)
+		providers = append(providers, blobIdx)
 	}
 
 	return providers, nil
 }
+
+// ReadDescriptor reads the descriptor file at path. A path of the form
+// "ref:<name>" is instead looked up as a ref name in the index.json of the OCI
+// Image Layout directories in layoutPaths, or as a root name of the blob index
+// files. An empty path selects the only image of the layouts. It's an error
+// wrapping ociutil.ErrAmbiguousLayoutRef if several layouts match.
+func ReadDescriptor(path string, layoutPaths []string) (ocispec.Descriptor, error) {
+	name, isRefName := strings.CutPrefix(path, refNamePrefix)
+	if path != "" && !isRefName {
+		return ociutil.ReadDescriptorFromFile(path)
+	}
+
+	if len(layoutPaths) == 0 {
+		return ocispec.Descriptor{}, fmt.Errorf("couldn't find descriptor %q: no layouts given", path)
+	}
+
+	var found *ocispec.Descriptor
+	for _, layoutPath := range layoutPaths {
+		desc, err := ociutil.ResolveLayoutRoot(layoutPath, name)
+		if errors.Is(err, ociutil.ErrNoLayoutRef) {
+			continue
+		} else if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("failed to resolve %q in layout (%v): %w", path, layoutPath, err)
+		}
+
+		// The same image can be in several layouts, e.g. a base image.
+		if found != nil && found.Digest != desc.Digest {
+			return ocispec.Descriptor{}, fmt.Errorf("couldn't resolve descriptor %q, found %v and %v in the layouts: %w", path, found.Digest, desc.Digest, ociutil.ErrAmbiguousLayoutRef)
+		}
+		found = &desc
+	}
+
+	if found == nil {
+		return ocispec.Descriptor{}, fmt.Errorf("couldn't find descriptor %q in the layouts: %w", path, ociutil.ErrNoLayoutRef)
+	}
+
+	return *found, nil
+}
+
+// LayoutRoots returns the descriptors in the index.json of the OCI Image
+// Layout directories in layoutPaths, and the roots of the blob index files
+// sorted by name.
+func LayoutRoots(layoutPaths []string) ([]ocispec.Descriptor, error) {
+	var roots []ocispec.Descriptor
+	for _, layoutPath := range layoutPaths {
+		if !ociutil.IsOciLayoutDir(layoutPath) {
+			provider, err := blob.LoadIndexFromFile(layoutPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load layout (%v): %w", layoutPath, err)
+			}
+			bi := provider.(*blob.Index)
+
+			names := make([]string, 0, len(bi.Roots))
+			for name := range bi.Roots {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				roots = append(roots, bi.Roots[name])
+			}
+
+			continue
+		}
+
+		index, err := ociutil.OciLayoutIndex(os.DirFS(layoutPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load layout (%v): %w", layoutPath, err)
+		}
+
+		roots = append(roots, index.Manifests...)
+	}
+
+	return roots, nil
+}
+
+// localProvider returns a provider of the blobs of the local layouts. With
+// --fetch-remote-blobs, the remote blobs of the blob indexes, e.g. the layers
+// of a shallow pull, are fetched from their registry when they're first read,
+// otherwise reading them fails. With --verify-blobs, the blobs are checked
+// against their descriptors as they're read.
+func localProvider(c *cli.Context, providers ...content.Provider) content.Provider {
+	if c.Bool("fetch-remote-blobs") {
+		for _, p := range providers {
+			if bi, ok := p.(*blob.Index); ok {
+				bi.Fetcher = ociutil.DefaultResolver().FetchBlob
+			}
+		}
+	}
+
+	provider := ociutil.MultiProvider(providers...)
+	if c.Bool("verify-blobs") {
+		return ociutil.VerifyingProvider(provider)
+	}
+
+	return provider
+}
+
+// LoadImage returns a provider and the descriptor of an image. When layouts
+// are given, ref is first looked up as a descriptor file or a "ref:<name>" in
+// the layouts (see ReadDescriptor). Otherwise, or if there is no such
+// descriptor file, it's a remote reference that is resolved with the
+// registry.
+func LoadImage(c *cli.Context, ref string) (content.Provider, ocispec.Descriptor, error) {
+	layoutPaths := c.StringSlice("layout")
+	if len(layoutPaths) > 0 {
+		localProviders, err := LoadLocalProviders(layoutPaths, c.String("layout-relative"))
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+
+		desc, err := ReadDescriptor(ref, layoutPaths)
+		if err == nil {
+			return localProvider(c, localProviders...), desc, nil
+		} else if ref == "" || strings.HasPrefix(ref, refNamePrefix) || !errors.Is(err, fs.ErrNotExist) {
+			return nil, ocispec.Descriptor{}, err
+		}
+	}
+
+	if ref == "" {
+		return nil, ocispec.Descriptor{}, fmt.Errorf("either a remote reference or layouts are required")
+	}
+
+	resolver := ociutil.DefaultResolver()
+
+	name, desc, err := resolver.Resolve(c.Context, ref)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, fmt.Errorf("failed to resolve %v: %w", ref, err)
+	}
+
+	fetcher, err := resolver.Fetcher(c.Context, name)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+
+	return ociutil.FetchertoProvider(fetcher), desc, nil
+}
+
+// LoadImageManifest loads an image like LoadImage, resolving indexes to the
+// manifest for the platform given by the os and arch flags, which defaults to
+// the host platform.
+func LoadImageManifest(c *cli.Context, ref string) (content.Provider, ocispec.Descriptor, error) {
+	provider, desc, err := LoadImage(c, ref)
+	if err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+
+	targetPlatform := platforms.DefaultSpec()
+	if osName := c.String("os"); osName != "" {
+		targetPlatform.OS = osName
+	}
+	if arch := c.String("arch"); arch != "" {
+		targetPlatform.Architecture = arch
+	}
+
+	desc, err = ociutil.ResolveManifest(c.Context, provider, desc, platforms.Only(targetPlatform))
+	if err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+
+	return provider, desc, nil
+}
+
+// addLocalBlobs adds the descriptors found in the layouts to idx.
+func addLocalBlobs(c *cli.Context, idx *blob.Index, descs []ocispec.Descriptor) error {
+	localProviders, err := LoadLocalProviders(c.StringSlice("layout"), c.String("layout-relative"))
+	if err != nil {
+		return err
+	}
+
+	localIndex, err := blob.MergeIndex(localProviders...)
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range descs {
+		if path, ok := localIndex.Blobs[desc.Digest]; ok {
+			idx.Add(desc, path)
+		}
+	}
+
+	return nil
+}
+
+// openBlob opens the blob dgst of the layouts at layoutPaths, or of the
+// remote repository repo if no layouts are given. The blob is verified once
+// it's read to the end: the last read fails with an error wrapping
+// ociutil.ErrBlobMismatch if the content doesn't match dgst.
+func openBlob(c *cli.Context, layoutPaths []string, repo string, dgst digest.Digest) (io.ReadCloser, error) {
+	desc := ocispec.Descriptor{Digest: dgst}
+
+	var rc io.ReadCloser
+	if len(layoutPaths) > 0 {
+		localProviders, err := LoadLocalProviders(layoutPaths, c.String("layout-relative"))
+		if err != nil {
+			return nil, err
+		}
+
+		ra, err := localProvider(c, localProviders...).ReaderAt(c.Context, desc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open blob %v: %w", dgst, err)
+		}
+
+		rc = struct {
+			io.Reader
+			io.Closer
+		}{content.NewReader(ra), ra}
+	} else {
+		// The size is unknown, so the blob is fetched as a stream rather
+		// than through a provider.
+		var err error
+		rc, err = ociutil.DefaultResolver().FetchBlob(c.Context, repo, desc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch blob %v from %v: %w", dgst, repo, err)
+		}
+	}
+
+	return &verifyingReadCloser{ReadCloser: rc, dgst: dgst, verifier: dgst.Verifier()}, nil
+}
+
+// verifyingReadCloser checks the digest of a blob when it's read to the end.
+type verifyingReadCloser struct {
+	io.ReadCloser
+	dgst     digest.Digest
+	verifier digest.Verifier
+}
+
+func (r *verifyingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.verifier.Write(p[:n])
+
+	if err == io.EOF && !r.verifier.Verified() {
+		return n, fmt.Errorf("blob %v: %w", r.dgst, ociutil.ErrBlobMismatch)
+	}
+
+	return n, err
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v2"
+)
+
+func TestReadDescriptor(t *testing.T) {
+	dir := t.TempDir()
+
+	app := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("app"),
+		Size:      3,
+	}
+	base := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("base"),
+		Size:      4,
+	}
+
+	indexPath := filepath.Join(dir, "image.blob-index.json")
+	bi := &blob.Index{}
+	bi.AddRoot("app", app)
+	if err := bi.WriteToFile(indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	baseIndexPath := filepath.Join(dir, "base.blob-index.json")
+	bi = &blob.Index{}
+	bi.AddRoot("base", base)
+	if err := bi.WriteToFile(baseIndexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	descPath := filepath.Join(dir, "base.json")
+	if err := ociutil.WriteDescriptorToFile(descPath, base); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path     string
+		layouts  []string
+		expected digest.Digest
+		err      error
+	}{
+		{path: descPath, expected: base.Digest},
+		{path: "ref:app", expected: app.Digest},
+		{path: "", expected: app.Digest},
+		{path: "ref:ap", err: ociutil.ErrNoLayoutRef},
+		// Paths are never looked up as ref names.
+		{path: "app", err: fs.ErrNotExist},
+		{path: filepath.Join(dir, "bsae.json"), err: fs.ErrNotExist},
+		{path: "ref:base", layouts: []string{indexPath, baseIndexPath}, expected: base.Digest},
+		// Every layout is looked at, not only the first one with an image.
+		{path: "", layouts: []string{indexPath, baseIndexPath}, err: ociutil.ErrAmbiguousLayoutRef},
+		{path: "", layouts: []string{indexPath, indexPath}, expected: app.Digest},
+	} {
+		layouts := tc.layouts
+		if layouts == nil {
+			layouts = []string{indexPath}
+		}
+
+		desc, err := ReadDescriptor(tc.path, layouts)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("ReadDescriptor(%q) returned error %v, but expected %v", tc.path, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ReadDescriptor(%q) unexpectedly returned an error. Error: %v", tc.path, err)
+			continue
+		}
+		if desc.Digest != tc.expected {
+			t.Errorf("ReadDescriptor(%q) = %v, but expected %v", tc.path, desc.Digest, tc.expected)
+		}
+	}
+}
+
+func TestLoadLocalProvidersRel(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("layer")
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+
+	layoutPath := filepath.Join(dir, "layout")
+	ing, err := ociutil.NewOciImageLayoutIngester(layoutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = content.WriteBlob(context.Background(), ing, desc.Digest.String(), bytes.NewReader(data), desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ing.SaveIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	indexPath := filepath.Join(dir, "image.blob-index.json")
+	bi := &blob.Index{}
+	bi.Add(desc, filepath.Join(dir, "blobs", "layer.tar"))
+	if err := bi.WriteToFile(indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	providers, err := LoadLocalProviders([]string{layoutPath, indexPath}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the paths of the blob index are made relative.
+	for i, expected := range []string{
+		filepath.Join(layoutPath, "blobs", "sha256", desc.Digest.Encoded()),
+		filepath.Join("blobs", "layer.tar"),
+	} {
+		if got := providers[i].(*blob.Index).Blobs[desc.Digest]; got != expected {
+			t.Errorf("expected the blob of provider %d to be at %v, got %v", i, expected, got)
+		}
+	}
+}
+
+func TestLocalProviderRemoteBlobs(t *testing.T) {
+	remote := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromString("layer"),
+		Size:      5,
+	}
+
+	indexPath := filepath.Join(t.TempDir(), "image.blob-index.json")
+	bi := &blob.Index{}
+	bi.AddRemote(remote, "registry.example.com/app")
+	if err := bi.WriteToFile(indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fetch := range []bool{false, true} {
+		providers, err := LoadLocalProviders([]string{indexPath}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		set.Bool("fetch-remote-blobs", fetch, "")
+		localProvider(cli.NewContext(app, set, nil), providers...)
+
+		if hasFetcher := providers[0].(*blob.Index).Fetcher != nil; hasFetcher != fetch {
+			t.Errorf("expected remote blobs to be fetched: %v, got %v", fetch, hasFetcher)
+		}
+	}
+}
+
+func TestOpenBlob(t *testing.T) {
+	dir := t.TempDir()
+
+	data := []byte("layer")
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+
+	// The blob file was changed after the blob index was written.
+	layerPath := filepath.Join(dir, "layer.tar")
+	if err := os.WriteFile(layerPath, []byte("LAYER"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	indexPath := filepath.Join(dir, "image.blob-index.json")
+	bi := &blob.Index{}
+	bi.Add(desc, layerPath)
+	if err := bi.WriteToFile(indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out.tar")
+	err := app.Run([]string{"ocitool", "get-blob", "--out", out, indexPath, desc.Digest.String()})
+	if !errors.Is(err, ociutil.ErrBlobMismatch) {
+		t.Fatalf("expected a blob mismatch, got %v", err)
+	}
+
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("expected no output, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/rules_oci/go/pkg/layer"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	diffFormatText = "text"
+	diffFormatJSON = "json"
+)
+
+// configChange is a difference in a field of the image configs.
+type configChange struct {
+	Field  string `json:"field"`
+	Key    string `json:"key,omitempty"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+func (c configChange) String() string {
+	name := c.Field
+	if c.Key != "" {
+		name += " " + c.Key
+	}
+
+	switch {
+	case c.Before == "":
+		return fmt.Sprintf("%s: added %q", name, c.After)
+	case c.After == "":
+		return fmt.Sprintf("%s: removed %q", name, c.Before)
+	default:
+		return fmt.Sprintf("%s: %q -> %q", name, c.Before, c.After)
+	}
+}
+
+type layerChanges struct {
+	Removed []ocispec.Descriptor `json:"removed,omitempty"`
+	Added   []ocispec.Descriptor `json:"added,omitempty"`
+}
+
+type imageDiff struct {
+	Config []configChange     `json:"config,omitempty"`
+	Layers layerChanges       `json:"layers"`
+	Files  []layer.FileChange `json:"files,omitempty"`
+}
+
+// diffImage is a manifest and its config and merged filesystem.
+type diffImage struct {
+	manifest ocispec.Manifest
+	config   ocispec.Image
+	fs       *layer.FS
+}
+
+// DiffCmd compares two images.
+func DiffCmd(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("expected two images to compare, got %d arguments", c.NArg())
+	}
+
+	// Checked before the images are loaded, which can take a while.
+	format := c.String("format")
+	if format != diffFormatText && format != diffFormatJSON {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	a, err := loadDiffImage(c, c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	b, err := loadDiffImage(c, c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	diff := imageDiff{
+		Config: diffConfigs(a.config, b.config),
+		Layers: diffLayers(a.manifest.Layers, b.manifest.Layers),
+		Files:  layer.DiffFS(a.fs, b.fs),
+	}
+
+	switch format {
+	case diffFormatText:
+		printImageDiff(os.Stdout, diff)
+	case diffFormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(diff)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	if c.Bool("exit-code") && (len(diff.Config) > 0 || len(diff.Layers.Added) > 0 || len(diff.Layers.Removed) > 0 || len(diff.Files) > 0) {
+		return cli.Exit("", 1)
+	}
+
+	return nil
+}
+
+func loadDiffImage(c *cli.Context, ref string) (diffImage, error) {
+	provider, desc, err := LoadImageManifest(c, ref)
+	if err != nil {
+		return diffImage{}, err
+	}
+
+	return loadManifestFS(c.Context, provider, desc)
+}
+
+func loadManifestFS(ctx context.Context, provider content.Provider, desc ocispec.Descriptor) (diffImage, error) {
+	manifest, err := ociutil.ImageManifestFromProvider(ctx, provider, desc)
+	if err != nil {
+		return diffImage{}, err
+	}
+
+	config, err := ociutil.ImageConfigFromProvider(ctx, provider, manifest.Config)
+	if err != nil {
+		return diffImage{}, err
+	}
+
+	fsys, err := layer.MergeLayers(ctx, provider, manifest.Layers)
+	if err != nil {
+		return diffImage{}, err
+	}
+
+	return diffImage{
+		manifest: manifest,
+		config:   config,
+		fs:       fsys,
+	}, nil
+}
+
+func diffConfigs(a, b ocispec.Image) []configChange {
+	var changes []configChange
+
+	diffField := func(field, before, after string) {
+		if before != after {
+			changes = append(changes, configChange{Field: field, Before: before, After: after})
+		}
+	}
+
+	diffField("user", a.Config.User, b.Config.User)
+	diffField("workingDir", a.Config.WorkingDir, b.Config.WorkingDir)
+	diffField("entrypoint", formatArgs(a.Config.Entrypoint), formatArgs(b.Config.Entrypoint))
+	diffField("cmd", formatArgs(a.Config.Cmd), formatArgs(b.Config.Cmd))
+
+	changes = append(changes, diffMaps("env", envToMap(a.Config.Env), envToMap(b.Config.Env))...)
+	changes = append(changes, diffMaps("label", a.Config.Labels, b.Config.Labels)...)
+
+	return changes
+}
+
+func diffMaps(field string, a, b map[string]string) []configChange {
+	keys := make(map[string]bool)
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []configChange
+	for _, k := range sorted {
+		if a[k] != b[k] {
+			changes = append(changes, configChange{Field: field, Key: k, Before: a[k], After: b[k]})
+		}
+	}
+
+	return changes
+}
+
+func envToMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = v
+	}
+
+	return m
+}
+
+func formatArgs(args []string) string {
+	if args == nil {
+		return ""
+	}
+
+	data, _ := json.Marshal(args)
+	return string(data)
+}
+
+func diffLayers(a, b []ocispec.Descriptor) layerChanges {
+	inA := make(map[digest.Digest]bool, len(a))
+	for _, desc := range a {
+		inA[desc.Digest] = true
+	}
+
+	inB := make(map[digest.Digest]bool, len(b))
+	for _, desc := range b {
+		inB[desc.Digest] = true
+	}
+
+	var changes layerChanges
+	for _, desc := range a {
+		if !inB[desc.Digest] {
+			changes.Removed = append(changes.Removed, desc)
+		}
+	}
+	for _, desc := range b {
+		if !inA[desc.Digest] {
+			changes.Added = append(changes.Added, desc)
+		}
+	}
+
+	return changes
+}
+
+func printImageDiff(w io.Writer, diff imageDiff) {
+	if len(diff.Config) > 0 {
+		fmt.Fprintln(w, "config:")
+		for _, change := range diff.Config {
+			fmt.Fprintf(w, "  %v\n", change)
+		}
+	}
+
+	if len(diff.Layers.Removed) > 0 || len(diff.Layers.Added) > 0 {
+		fmt.Fprintln(w, "layers:")
+		for _, desc := range diff.Layers.Removed {
+			fmt.Fprintf(w, "  - %v\n", describeLayer(desc))
+		}
+		for _, desc := range diff.Layers.Added {
+			fmt.Fprintf(w, "  + %v\n", describeLayer(desc))
+		}
+	}
+
+	if len(diff.Files) > 0 {
+		fmt.Fprintln(w, "files:")
+		for _, change := range diff.Files {
+			fmt.Fprintf(w, "  %v\n", change)
+		}
+	}
+}
+
+func describeLayer(desc ocispec.Descriptor) string {
+	s := fmt.Sprintf("%v %s", desc.Digest, humanSize(desc.Size))
+	if label, ok := desc.Annotations[ociutil.AnnotationArtifactDescription]; ok {
+		s += " " + label
+	}
+
+	return s
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	exportFormatDockerArchive = "docker-archive"
+	exportFormatOCIArchive    = "oci-archive"
+)
+
+// ExportCmd writes an image as a tarball that can be loaded by `docker load`
+// and `podman load`, or as an OCI image layout tarball.
+func ExportCmd(c *cli.Context) error {
+	// Checked before the output is created.
+	format := c.String("format")
+	if format != exportFormatDockerArchive && format != exportFormatOCIArchive {
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	localProviders, err := LoadLocalProviders(c.StringSlice("layout"), c.String("layout-relative"))
+	if err != nil {
+		return err
+	}
+
+	allLocalProviders := localProvider(c, localProviders...)
+
+	desc, err := ReadDescriptor(c.String("desc"), c.StringSlice("layout"))
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(c.String("out"))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	err = writeArchive(c, allLocalProviders, out, format, desc)
+	if err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+// writeArchive writes desc as a docker-archive or oci-archive, for the
+// platform of the --os and --arch flags.
+func writeArchive(c *cli.Context, provider content.Provider, out io.Writer, format string, desc ocispec.Descriptor) error {
+	// Default to the host platform, like `docker pull` would.
+	targetPlatform := platforms.DefaultSpec()
+	if osName := c.String("os"); osName != "" {
+		targetPlatform.OS = osName
+	}
+	if arch := c.String("arch"); arch != "" {
+		targetPlatform = ocispec.Platform{
+			OS:           targetPlatform.OS,
+			Architecture: arch,
+		}
+	}
+
+	repoTags := c.StringSlice("repo-tag")
+
+	switch format {
+	case exportFormatDockerArchive:
+		manifestDesc, err := ociutil.ResolveManifest(c.Context, provider, desc, platforms.Only(targetPlatform))
+		if err != nil {
+			return fmt.Errorf("failed to resolve manifest for %v: %w", platforms.Format(targetPlatform), err)
+		}
+
+		log.WithField("manifest", manifestDesc).Debug("exporting docker archive")
+
+		err = ociutil.WriteDockerArchive(c.Context, provider, out, manifestDesc, repoTags)
+		if err != nil {
+			return fmt.Errorf("failed to write docker archive: %w", err)
+		}
+	case exportFormatOCIArchive:
+		// Only narrow down an index when a platform is explicitly requested.
+		if c.String("os") != "" || c.String("arch") != "" {
+			var err error
+			desc, err = ociutil.ResolveManifest(c.Context, provider, desc, platforms.Only(targetPlatform))
+			if err != nil {
+				return fmt.Errorf("failed to resolve manifest for %v: %w", platforms.Format(targetPlatform), err)
+			}
+		}
+
+		err := ociutil.WriteOCIArchive(c.Context, provider, out, desc, repoTags)
+		if err != nil {
+			return fmt.Errorf("failed to write OCI archive: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	return nil
+}
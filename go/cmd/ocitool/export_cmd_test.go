@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestExportCmdUnknownFormat(t *testing.T) {
+	dir := t.TempDir()
+
+	indexPath := filepath.Join(dir, "image.blob-index.json")
+	bi := &blob.Index{}
+	bi.AddRoot("app", ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("app"),
+		Size:      3,
+	})
+	if err := bi.WriteToFile(indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "image.tar")
+	err := app.Run([]string{"ocitool", "--layout", indexPath, "export", "--desc", "ref:app", "--format", "docker", "--out", out})
+	if err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+
+	// Nothing is written for an invalid command.
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("expected no output, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/DataDog/rules_oci/go/pkg/layer"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// ExtractFileCmd writes a single file of the merged filesystem of an image,
+// only reading the layers down to the topmost one containing it.
+func ExtractFileCmd(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("expected an image and the path of a file")
+	}
+
+	provider, desc, err := LoadImageManifest(c, c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	manifest, err := ociutil.ImageManifestFromProvider(c.Context, provider, desc)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(c.String("out"), func(w io.Writer) error {
+		entry, err := layer.ExtractFile(c.Context, provider, manifest.Layers, c.Args().Get(1), w)
+		if err != nil {
+			return err
+		}
+
+		log.WithField("layer", manifest.Layers[entry.Layer].Digest).Debugf("extracted %v", entry.Path)
+
+		return nil
+	})
+}
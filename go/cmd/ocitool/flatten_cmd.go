@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/DataDog/rules_oci/go/pkg/layer"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/urfave/cli/v2"
+)
+
+// FlattenCmd writes the merged filesystem of an image as a single tar or as a
+// directory.
+func FlattenCmd(c *cli.Context) error {
+	out, outDir := c.String("out"), c.String("out-dir")
+	if (out == "") == (outDir == "") {
+		return fmt.Errorf("exactly one of --out and --out-dir is required")
+	}
+
+	ref := c.Args().First()
+	if ref == "" {
+		ref = c.String("desc")
+	}
+
+	provider, desc, err := LoadImageManifest(c, ref)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := ociutil.ImageManifestFromProvider(c.Context, provider, desc)
+	if err != nil {
+		return err
+	}
+
+	if outDir != "" {
+		return layer.FlattenToDir(c.Context, provider, manifest.Layers, outDir)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = layer.Flatten(c.Context, provider, manifest.Layers, f)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
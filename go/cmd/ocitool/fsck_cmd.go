@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// fsckMaxManifestSize is the largest blob that is sniffed for a manifest or
+// index when looking for roots in a blob index.
+const fsckMaxManifestSize = 4 << 20
+
+// FsckCmd validates the content of the given layouts, starting from the given
+// descriptors or, if there are none, from the roots of the layouts.
+func FsckCmd(c *cli.Context) error {
+	localProviders, err := LoadLocalProviders(c.StringSlice("layout"), c.String("layout-relative"))
+	if err != nil {
+		return err
+	}
+
+	allLocalProviders := ociutil.MultiProvider(localProviders...)
+
+	var roots []ocispec.Descriptor
+	for _, descPath := range c.StringSlice("desc") {
+		desc, err := ReadDescriptor(descPath, c.StringSlice("layout"))
+		if err != nil {
+			return err
+		}
+
+		roots = append(roots, desc)
+	}
+
+	if len(roots) == 0 {
+		roots, err = LayoutRoots(c.StringSlice("layout"))
+		if err != nil {
+			return err
+		}
+
+		// Blob indexes don't necessarily record their roots, so check the
+		// manifests and indexes they contain first, and then any remaining
+		// blob.
+		for _, provider := range localProviders {
+			if bi, ok := provider.(*blob.Index); ok {
+				blobRoots, err := blobIndexRoots(c, bi)
+				if err != nil {
+					return err
+				}
+
+				roots = append(roots, blobRoots...)
+			}
+		}
+	}
+
+	problems, err := ociutil.Fsck(c.Context, allLocalProviders, roots...)
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("fsck found %d problems", len(problems))
+	}
+
+	log.Infof("fsck found no problems in %d roots", len(roots))
+
+	return nil
+}
+
+// blobIndexRoots returns a descriptor for every blob in the index, with the
+// manifests and indexes first.
+func blobIndexRoots(c *cli.Context, bi *blob.Index) ([]ocispec.Descriptor, error) {
+	dgsts := make([]digest.Digest, 0, len(bi.Blobs))
+	for dgst := range bi.Blobs {
+		dgsts = append(dgsts, dgst)
+	}
+	sort.Slice(dgsts, func(i, j int) bool { return dgsts[i] < dgsts[j] })
+
+	var manifests, others []ocispec.Descriptor
+	for _, dgst := range dgsts {
+		// Check the recorded descriptor, if any.
+		if desc, ok := bi.Descriptors[dgst]; ok && desc.MediaType != "" {
+			if images.IsManifestType(desc.MediaType) || images.IsIndexType(desc.MediaType) {
+				manifests = append(manifests, desc)
+			} else {
+				others = append(others, desc)
+			}
+			continue
+		}
+
+		desc := ocispec.Descriptor{
+			Digest: dgst,
+		}
+
+		ra, err := bi.ReaderAt(c.Context, desc)
+		if err != nil {
+			// Reported as a missing blob.
+			others = append(others, desc)
+			continue
+		}
+		desc.Size = ra.Size()
+
+		if desc.Size <= fsckMaxManifestSize {
+			var versioned struct {
+				MediaType string `json:"mediaType"`
+			}
+
+			data, err := content.ReadBlob(c.Context, bi, desc)
+			if err == nil && json.Unmarshal(data, &versioned) == nil && (images.IsManifestType(versioned.MediaType) || images.IsIndexType(versioned.MediaType)) {
+				desc.MediaType = versioned.MediaType
+			}
+		}
+		ra.Close()
+
+		if desc.MediaType != "" {
+			manifests = append(manifests, desc)
+		} else {
+			others = append(others, desc)
+		}
+	}
+
+	return append(manifests, others...), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// GCCmd deletes the blobs of OCI Image Layout directories that aren't
+// reachable from the roots in their index.json or from the given descriptors.
+// The descriptors add roots, they don't replace the index.json ones, so that
+// the layouts stay valid.
+func GCCmd(c *cli.Context) error {
+	layoutPaths := c.StringSlice("layout")
+	for _, layoutPath := range layoutPaths {
+		if !ociutil.IsOciLayoutDir(layoutPath) {
+			return fmt.Errorf("gc only supports OCI Image Layout directories, got %v", layoutPath)
+		}
+	}
+
+	localProviders, err := LoadLocalProviders(layoutPaths, "")
+	if err != nil {
+		return err
+	}
+
+	allLocalProviders := ociutil.MultiProvider(localProviders...)
+
+	roots, err := LayoutRoots(layoutPaths)
+	if err != nil {
+		return err
+	}
+
+	for _, descPath := range c.StringSlice("desc") {
+		desc, err := ReadDescriptor(descPath, layoutPaths)
+		if err != nil {
+			return err
+		}
+
+		roots = append(roots, desc)
+	}
+
+	reachable, err := ociutil.Reachable(c.Context, allLocalProviders, roots...)
+	if err != nil {
+		return fmt.Errorf("failed to mark reachable blobs: %w", err)
+	}
+
+	dryRun := c.Bool("dry-run")
+
+	var count, reclaimable int64
+	for _, provider := range localProviders {
+		bi := provider.(*blob.Index)
+
+		dgsts := make([]digest.Digest, 0, len(bi.Blobs))
+		for dgst := range bi.Blobs {
+			if !reachable[dgst] {
+				dgsts = append(dgsts, dgst)
+			}
+		}
+		sort.Slice(dgsts, func(i, j int) bool { return dgsts[i] < dgsts[j] })
+
+		for _, dgst := range dgsts {
+			path := bi.Blobs[dgst]
+
+			fi, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+
+			log.WithField("digest", dgst).WithField("size", fi.Size()).Debug("unreachable blob")
+
+			if !dryRun {
+				err = os.Remove(path)
+				if err != nil {
+					return fmt.Errorf("failed to delete blob %v: %w", dgst, err)
+				}
+			}
+
+			count++
+			reclaimable += fi.Size()
+		}
+	}
+
+	if dryRun {
+		fmt.Printf("%d unreachable blobs, %d bytes reclaimable\n", count, reclaimable)
+	} else {
+		fmt.Printf("deleted %d unreachable blobs, %d bytes reclaimed\n", count, reclaimable)
+	}
+
+	return nil
+}
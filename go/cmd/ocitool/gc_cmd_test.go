@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispecv "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestGCCmd(t *testing.T) {
+	ctx := context.Background()
+
+	// newLayout writes a layout with the images app and base, sharing a
+	// layer, an image that isn't in index.json, with its descriptor file,
+	// and a blob that isn't referenced.
+	newLayout := func(t *testing.T) (string, string, map[string]ocispec.Descriptor) {
+		dir := t.TempDir()
+		layoutPath := filepath.Join(dir, "layout")
+
+		ing, err := ociutil.NewOciImageLayoutIngester(layoutPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writeBlob := func(mediaType string, data []byte) ocispec.Descriptor {
+			desc := ocispec.Descriptor{
+				MediaType: mediaType,
+				Digest:    digest.FromBytes(data),
+				Size:      int64(len(data)),
+			}
+
+			err := content.WriteBlob(ctx, ing, desc.Digest.String(), bytes.NewReader(data), desc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return desc
+		}
+
+		blobs := make(map[string]ocispec.Descriptor)
+		image := func(name string, layers ...string) ocispec.Descriptor {
+			var layerDescs []ocispec.Descriptor
+			var diffIDs []digest.Digest
+			for _, layer := range layers {
+				desc := writeBlob(ocispec.MediaTypeImageLayer, []byte(layer))
+				blobs["layer "+layer] = desc
+				layerDescs = append(layerDescs, desc)
+				diffIDs = append(diffIDs, desc.Digest)
+			}
+
+			config, err := json.Marshal(ocispec.Image{
+				Platform: ocispec.Platform{OS: "linux", Architecture: name},
+				RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: diffIDs},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			blobs["config "+name] = writeBlob(ocispec.MediaTypeImageConfig, config)
+
+			manifest, err := json.Marshal(ocispec.Manifest{
+				Versioned: ocispecv.Versioned{SchemaVersion: 2},
+				MediaType: ocispec.MediaTypeImageManifest,
+				Config:    blobs["config "+name],
+				Layers:    layerDescs,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			blobs["manifest "+name] = writeBlob(ocispec.MediaTypeImageManifest, manifest)
+
+			return blobs["manifest "+name]
+		}
+
+		ing.AddReference(image("app", "shared", "app"), "app")
+		ing.AddReference(image("base", "shared"), "base")
+
+		extraPath := filepath.Join(dir, "extra.json")
+		err = ociutil.WriteDescriptorToFile(extraPath, image("extra", "extra"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blobs["stray"] = writeBlob("application/octet-stream", []byte("stray"))
+
+		err = ing.SaveIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return layoutPath, extraPath, blobs
+	}
+
+	expectBlobs := func(t *testing.T, layoutPath string, blobs map[string]ocispec.Descriptor, kept ...string) {
+		t.Helper()
+
+		isKept := make(map[string]bool)
+		for _, name := range kept {
+			isKept[name] = true
+		}
+
+		for name, desc := range blobs {
+			_, err := os.Stat(filepath.Join(layoutPath, "blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+			if isKept[name] && err != nil {
+				t.Errorf("expected %v to be kept: %v", name, err)
+			} else if !isKept[name] && !os.IsNotExist(err) {
+				t.Errorf("expected %v to be deleted, got %v", name, err)
+			}
+		}
+	}
+
+	indexBlobs := []string{
+		"manifest app", "config app", "layer app", "layer shared",
+		"manifest base", "config base",
+	}
+
+	t.Run("layout roots", func(t *testing.T) {
+		layoutPath, _, blobs := newLayout(t)
+
+		err := app.Run([]string{"ocitool", "--layout", layoutPath, "gc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectBlobs(t, layoutPath, blobs, indexBlobs...)
+
+		err = app.Run([]string{"ocitool", "--layout", layoutPath, "fsck"})
+		if err != nil {
+			t.Fatalf("expected the layout to be valid after gc, got %v", err)
+		}
+	})
+
+	t.Run("desc", func(t *testing.T) {
+		layoutPath, extraPath, blobs := newLayout(t)
+
+		err := app.Run([]string{"ocitool", "--layout", layoutPath, "gc", "--desc", extraPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectBlobs(t, layoutPath, blobs, append(indexBlobs, "manifest extra", "config extra", "layer extra")...)
+
+		err = app.Run([]string{"ocitool", "--layout", layoutPath, "fsck"})
+		if err != nil {
+			t.Fatalf("expected the layout to be valid after gc, got %v", err)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		layoutPath, _, blobs := newLayout(t)
+
+		err := app.Run([]string{"ocitool", "--layout", layoutPath, "gc", "--dry-run"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var all []string
+		for name := range blobs {
+			all = append(all, name)
+		}
+		expectBlobs(t, layoutPath, blobs, all...)
+	})
+}
@@ -35,15 +35,29 @@ func GenerateBuildFilesCmd(c *cli.Context) error {
 	refs := layout.ListReferences()
 	refDescs := make([]ocispec.Descriptor, 0, len(refs))
 
-	for _, r := range refs {
+	// The blobs skipped by shallow pulls are fetched from the repository of
+	// the ref they were pulled from.
+	remotes := make(map[digest.Digest]string)
+
+	for name, r := range refs {
 		refDescs = append(refDescs, r)
+
+		bi, err := pulledBlobIndex(c.Context, layout, layoutRootPath, name, r)
+		if err != nil {
+			log.WithError(err).Debugf("no remote blobs for %v", name)
+			continue
+		}
+
+		for dgst, repo := range bi.Remotes {
+			remotes[dgst] = repo
+		}
 	}
 
 	log.Debugf("layout root: %#v", refs)
 
 	err = images.Walk(
 		context.Background(),
-		ociutil.GenerateBuildFilesHandler(images.ChildrenHandler(layout), layoutRootPath, layout),
+		ociutil.GenerateBuildFilesHandlerWithRemotes(images.ChildrenHandler(layout), layoutRootPath, layout, remotes),
 		refDescs...,
 	)
 	if err != nil {
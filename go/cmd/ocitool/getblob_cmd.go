@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/urfave/cli/v2"
+)
+
+// GetBlobCmd writes a blob from a layout or a registry to a file.
+func GetBlobCmd(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("expected a layout or a remote reference, and a blob digest")
+	}
+
+	source := c.Args().Get(0)
+
+	dgst, err := digest.Parse(c.Args().Get(1))
+	if err != nil {
+		return fmt.Errorf("invalid blob digest %q: %w", c.Args().Get(1), err)
+	}
+
+	// A layout, or a remote reference.
+	var layoutPaths []string
+	var repo string
+	if _, err := os.Stat(source); err == nil {
+		layoutPaths = []string{source}
+	} else {
+		repo, err = ociutil.RefToRepository(source)
+		if err != nil {
+			return err
+		}
+	}
+
+	rc, err := openBlob(c, layoutPaths, repo, dgst)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return writeOutput(c.String("out"), func(w io.Writer) error {
+		_, err := io.Copy(w, rc)
+		return err
+	})
+}
+
+// writeOutput calls fn with the file out, or with stdout if out is empty or
+// "-". The file is removed if fn fails.
+func writeOutput(out string, fn func(w io.Writer) error) error {
+	if out == "" || out == "-" {
+		return fn(os.Stdout)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	err = fn(f)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+
+	if err != nil {
+		os.Remove(out)
+		return err
+	}
+
+	return nil
+}
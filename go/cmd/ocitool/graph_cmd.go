@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	graphFormatDOT     = "dot"
+	graphFormatMermaid = "mermaid"
+
+	// Fill color of the blobs shared by several roots.
+	graphSharedColor = "#f4cccc"
+)
+
+// GraphCmd writes the graph of the blobs reachable from descriptors as
+// Graphviz DOT or Mermaid.
+func GraphCmd(c *cli.Context) error {
+	// Checked before the output is created.
+	format := c.String("format")
+	if format != graphFormatDOT && format != graphFormatMermaid {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	layoutPaths := c.StringSlice("layout")
+
+	localProviders, err := LoadLocalProviders(layoutPaths, c.String("layout-relative"))
+	if err != nil {
+		return err
+	}
+
+	descPaths := append(c.Args().Slice(), c.StringSlice("desc")...)
+
+	var roots []ocispec.Descriptor
+	for _, descPath := range descPaths {
+		desc, err := ReadDescriptor(descPath, layoutPaths)
+		if err != nil {
+			return err
+		}
+
+		roots = append(roots, desc)
+	}
+
+	if len(roots) == 0 {
+		roots, err = LayoutRoots(layoutPaths)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(roots) == 0 {
+		return fmt.Errorf("no descriptors given and no roots in the layouts")
+	}
+
+	graph, err := ociutil.DescriptorGraph(c.Context, localProvider(c, localProviders...), roots...)
+	if err != nil {
+		return err
+	}
+
+	out := c.String("out")
+	if out == "" {
+		return writeGraph(os.Stdout, format, graph)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = writeGraph(f, format, graph)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func writeGraph(w io.Writer, format string, graph *ociutil.Graph) error {
+	switch format {
+	case graphFormatDOT:
+		writeGraphDOT(w, graph)
+	case graphFormatMermaid:
+		writeGraphMermaid(w, graph)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	return nil
+}
+
+func writeGraphDOT(w io.Writer, graph *ociutil.Graph) {
+	fmt.Fprintln(w, "digraph images {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box, style=rounded];")
+
+	for _, node := range graph.Nodes {
+		label := strings.ReplaceAll(strings.Join(graphNodeLabel(node), "\n"), `"`, `\"`)
+		label = strings.ReplaceAll(label, "\n", `\n`)
+
+		attrs := fmt.Sprintf("label=\"%s\"", label)
+		if node.Shared() {
+			attrs += fmt.Sprintf(", style=\"rounded,filled,bold\", fillcolor=\"%s\"", graphSharedColor)
+		}
+
+		fmt.Fprintf(w, "  \"%s\" [%s];\n", node.Descriptor.Digest, attrs)
+	}
+
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(w, "  \"%s\" -> \"%s\";\n", edge.From, edge.To)
+	}
+
+	fmt.Fprintln(w, "}")
+}
+
+func writeGraphMermaid(w io.Writer, graph *ociutil.Graph) {
+	// Mermaid node IDs can't contain a colon.
+	ids := make(map[digest.Digest]string, len(graph.Nodes))
+	for i, node := range graph.Nodes {
+		ids[node.Descriptor.Digest] = fmt.Sprintf("n%d", i)
+	}
+
+	fmt.Fprintln(w, "graph LR")
+
+	var shared []string
+	for _, node := range graph.Nodes {
+		label := strings.ReplaceAll(strings.Join(graphNodeLabel(node), "<br/>"), `"`, "#quot;")
+		fmt.Fprintf(w, "  %s[\"%s\"]\n", ids[node.Descriptor.Digest], label)
+
+		if node.Shared() {
+			shared = append(shared, ids[node.Descriptor.Digest])
+		}
+	}
+
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(w, "  %s --> %s\n", ids[edge.From], ids[edge.To])
+	}
+
+	if len(shared) > 0 {
+		fmt.Fprintf(w, "  classDef shared fill:%s,stroke-width:3px\n", graphSharedColor)
+		fmt.Fprintf(w, "  class %s shared\n", strings.Join(shared, ","))
+	}
+}
+
+// graphNodeLabel returns the lines of the label of a node: its kind, short
+// digest, size, names and Bazel label.
+func graphNodeLabel(node *ociutil.GraphNode) []string {
+	desc := node.Descriptor
+
+	kind := "blob"
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		kind = "index"
+	case images.IsManifestType(desc.MediaType):
+		kind = "manifest"
+	case images.IsConfigType(desc.MediaType):
+		kind = "config"
+	case images.IsLayerType(desc.MediaType):
+		kind = "layer"
+	}
+
+	lines := []string{
+		kind,
+		shortDigest(desc.Digest),
+		humanSize(desc.Size),
+	}
+	if name := desc.Annotations[ocispec.AnnotationRefName]; name != "" {
+		lines = append(lines, name)
+	}
+	if name := desc.Annotations[images.AnnotationImageName]; name != "" {
+		lines = append(lines, name)
+	}
+	if label := desc.Annotations[ociutil.AnnotationArtifactDescription]; label != "" {
+		lines = append(lines, label)
+	}
+	if node.Shared() {
+		lines = append(lines, fmt.Sprintf("shared by %d", len(node.Roots)))
+	}
+
+	return lines
+}
+
+func shortDigest(dgst digest.Digest) string {
+	encoded := dgst.Encoded()
+	if len(encoded) > 12 {
+		encoded = encoded[:12]
+	}
+
+	return dgst.Algorithm().String() + ":" + encoded
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestGraphCmdUnknownFormat(t *testing.T) {
+	dir := t.TempDir()
+
+	data := []byte("layer")
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+
+	layerPath := filepath.Join(dir, "layer.tar")
+	if err := os.WriteFile(layerPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	indexPath := filepath.Join(dir, "image.blob-index.json")
+	bi := &blob.Index{}
+	bi.Add(desc, layerPath)
+	bi.AddRoot("layer", desc)
+	if err := bi.WriteToFile(indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "graph.dot")
+	err := app.Run([]string{"ocitool", "--layout", indexPath, "graph", "--format", "svg", "--out", out})
+	if err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+
+	// Nothing is written for an invalid command.
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("expected no output, got %v", err)
+	}
+}
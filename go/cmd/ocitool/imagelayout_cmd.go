@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"regexp"
 	"strings"
 
 	"github.com/DataDog/rules_oci/go/pkg/blob"
@@ -56,6 +57,8 @@ func getLayoutFilesBlobIndex(layoutFilePaths []string, relPath string) (blob.Ind
 // This command creates an OCI Image Layout directory based on the layout parameter.
 // The layout-files parameter contains a list of files that are used as blobs
 // when referenced by desriptors in the layout parameter.
+// Each desc parameter adds an entry to the index.json of the layout, if the
+// layout already exists its entries are kept.
 // See https://github.com/opencontainers/image-spec/blob/main/image-layout.md
 // for the structure of OCI Image Layout directories.
 func CreateOciImageLayoutCmd(c *cli.Context) error {
@@ -76,12 +79,11 @@ func CreateOciImageLayoutCmd(c *cli.Context) error {
 		providers = append(providers, &layoutFilesBlobIdx)
 	}
 
-	multiProvider := ociutil.MultiProvider(providers...)
+	multiProvider := localProvider(c, providers...)
 
-	descriptorFile := c.String("desc")
-	baseDesc, err := ociutil.ReadDescriptorFromFile(descriptorFile)
+	linkMode, err := ociutil.ParseLinkMode(c.String("link"))
 	if err != nil {
-		return fmt.Errorf("failed to read base descriptor: %w", err)
+		return err
 	}
 
 	outDir := c.String("out-dir")
@@ -89,19 +91,50 @@ func CreateOciImageLayoutCmd(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	ociIngester.LinkMode = linkMode
 
-	// Copy the children first; leave the parent (index) to last.
-	imagesHandler := images.ChildrenHandler(multiProvider)
-	err = ociutil.CopyChildrenFromHandler(c.Context, imagesHandler, multiProvider, &ociIngester, baseDesc)
-	if err != nil {
-		return fmt.Errorf("failed to copy child content to OCI Image Layout: %w", err)
+	for _, descArg := range c.StringSlice("desc") {
+		refName, descriptorFile := parseRefNameAndPath(descArg)
+
+		baseDesc, err := ReadDescriptor(descriptorFile, c.StringSlice("layout"))
+		if err != nil {
+			return fmt.Errorf("failed to read base descriptor: %w", err)
+		}
+
+		// Copy the children first; leave the parent (index) to last.
+		imagesHandler := images.ChildrenHandler(multiProvider)
+		err = ociutil.CopyChildrenFromHandler(c.Context, imagesHandler, multiProvider, ociIngester, baseDesc)
+		if err != nil {
+			return fmt.Errorf("failed to copy child content to OCI Image Layout: %w", err)
+		}
+
+		// copy the parent last (in case of image index)
+		err = ociutil.CopyContent(c.Context, multiProvider, ociIngester, baseDesc)
+		if err != nil {
+			return fmt.Errorf("failed to copy parent content to OCI Image Layout: %w", err)
+		}
+
+		ociIngester.AddReference(baseDesc, refName)
 	}
 
-	// copy the parent last (in case of image index)
-	err = ociutil.CopyContent(c.Context, multiProvider, &ociIngester, baseDesc)
-	if err != nil {
-		return fmt.Errorf("failed to copy parent content to OCI Image Layout: %w", err)
+	return ociIngester.SaveIndex()
+}
+
+// refNameRegexp matches the values of org.opencontainers.image.ref.name, see
+// https://github.com/opencontainers/image-spec/blob/main/annotations.md.
+var refNameRegexp = regexp.MustCompile(`^[A-Za-z0-9]+(?:(?:[-._:@+]|--)[A-Za-z0-9]+)*(?:/[A-Za-z0-9]+(?:(?:[-._:@+]|--)[A-Za-z0-9]+)*)*$`)
+
+// parseRefNameAndPath splits a value of the form "[ref-name=]path". Paths can
+// contain "=", so the value is only split when it isn't an existing file and
+// the part before the first "=" is a valid ref name.
+func parseRefNameAndPath(value string) (string, string) {
+	if _, err := os.Stat(value); err == nil {
+		return "", value
+	}
+
+	if refName, path, ok := strings.Cut(value, "="); ok && refNameRegexp.MatchString(refName) {
+		return refName, path
 	}
 
-	return nil
+	return "", value
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRefNameAndPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	// Bazel output paths can contain "=", "bin/app" is also a valid ref name.
+	existing := filepath.Join("bin", "app=v1", "image.json")
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(existing, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		input   string
+		refName string
+		path    string
+	}{
+		{input: "image.json", path: "image.json"},
+		{input: "latest=image.json", refName: "latest", path: "image.json"},
+		{input: "app:v1.2=out/image.json", refName: "app:v1.2", path: "out/image.json"},
+		{input: "ghcr.io/datadog/app=a=b.json", refName: "ghcr.io/datadog/app", path: "a=b.json"},
+		{input: "bad ref=image.json", path: "bad ref=image.json"},
+		{input: "=image.json", path: "=image.json"},
+		{input: existing, path: existing},
+	} {
+		refName, path := parseRefNameAndPath(tc.input)
+		if refName != tc.refName || path != tc.path {
+			t.Errorf("parseRefNameAndPath(%q) = %q, %q, but expected %q, %q", tc.input, refName, path, tc.refName, tc.path)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// ImportCmd imports an image from a `docker save` tarball or an OCI image
+// layout tarball. The blobs are extracted into out-dir and a blob index and
+// descriptor are written that can be used as the base of append-layers.
+func ImportCmd(c *cli.Context) error {
+	outDir := c.String("out-dir")
+
+	store, err := local.NewStore(outDir)
+	if err != nil {
+		return fmt.Errorf("failed to create blob store in %v: %w", outDir, err)
+	}
+
+	archivePath := c.String("archive")
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	desc, err := ociutil.ImportArchive(c.Context, store, f, c.String("repo-tag"))
+	if err != nil {
+		return fmt.Errorf("failed to import %v: %w", archivePath, err)
+	}
+
+	log.WithField("desc", desc).Debug("imported image")
+
+	bi, err := pruneToReachable(c.Context, store, outDir, desc)
+	if err != nil {
+		return err
+	}
+
+	name := desc.Annotations[images.AnnotationImageName]
+	if name == "" {
+		name = defaultRootName
+	}
+	bi.SetRoot(name, desc)
+
+	// The store leaves its (empty) ingest directory behind.
+	err = os.RemoveAll(filepath.Join(outDir, "ingest"))
+	if err != nil {
+		return err
+	}
+
+	err = bi.WriteToFile(c.String("out-layout"))
+	if err != nil {
+		return err
+	}
+
+	err = ociutil.WriteDescriptorToFile(c.String("outd"), desc)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// pruneToReachable deletes every blob from the store that isn't reachable
+// from root and returns a blob index of the remaining blobs.
+func pruneToReachable(ctx context.Context, store content.Store, root string, desc ocispec.Descriptor) (*blob.Index, error) {
+	bi, err := layoutBlobIndex(ctx, store, root, desc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk imported image: %w", err)
+	}
+
+	var unreachable []digest.Digest
+	err = store.Walk(ctx, func(info content.Info) error {
+		if _, ok := bi.Blobs[info.Digest]; !ok {
+			unreachable = append(unreachable, info.Digest)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dgst := range unreachable {
+		err = store.Delete(ctx, dgst)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return bi, nil
+}
+
+// layoutBlobIndex returns a blob index of desc and all of its children, whose
+// blobs are in the blobs directory of root.
+func layoutBlobIndex(ctx context.Context, provider content.Provider, root string, desc ocispec.Descriptor) (*blob.Index, error) {
+	bi := &blob.Index{
+		Blobs: make(map[digest.Digest]string),
+	}
+
+	err := images.Walk(ctx, images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		bi.Add(desc, filepath.Join(root, ociutil.BlobsFolderName, desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+
+		return images.ChildrenHandler(provider)(ctx, desc)
+	}), desc)
+	if err != nil {
+		return nil, err
+	}
+
+	return bi, nil
+}
@@ -26,7 +26,7 @@ func CreateIndexCmd(c *cli.Context) error {
 
 	descriptorPaths := c.StringSlice("desc")
 
-	descriptors, err := FilePathsToDescriptors(c.Context, descriptorPaths, true, bi)
+	descriptors, err := FilePathsToDescriptors(c.Context, descriptorPaths, c.StringSlice("layout"), true, bi)
 	if err != nil {
 		return err
 	}
@@ -54,7 +54,8 @@ func CreateIndexCmd(c *cli.Context) error {
 	}
 
 	// Append image index to blob index
-	bi.Blobs[desc.Digest] = c.String("out-index")
+	bi.Add(desc, c.String("out-index"))
+	bi.SetRoot(defaultRootName, desc)
 
 	err = bi.WriteToFile(c.String("out-layout"))
 	if err != nil {
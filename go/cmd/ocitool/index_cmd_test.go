@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/opencontainers/go-digest"
+	ocispecv "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCreateIndexRoot(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	bi := &blob.Index{}
+	writeJSON := func(name, mediaType string, v interface{}) ocispec.Descriptor {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(path(name), data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		desc := ocispec.Descriptor{
+			MediaType: mediaType,
+			Digest:    digest.FromBytes(data),
+			Size:      int64(len(data)),
+		}
+		bi.Add(desc, path(name))
+
+		return desc
+	}
+
+	config := writeJSON("config.json", ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "arm64"},
+		RootFS:   ocispec.RootFS{Type: "layers"},
+	})
+	manifest := writeJSON("manifest.json", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: ocispecv.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+	})
+
+	err := ociutil.WriteDescriptorToFile(path("manifest.desc.json"), manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The root of the input blob index isn't a root of the output.
+	bi.AddRoot("base", manifest)
+	err = bi.WriteToFile(path("manifest.layout.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Run([]string{
+		"ocitool", "--layout", path("manifest.layout.json"),
+		"create-index",
+		"--desc", path("manifest.desc.json"),
+		"--out-index", path("index.json"),
+		"--out-layout", path("index.layout.json"),
+		"--outd", path("index.desc.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := ociutil.ReadDescriptorFromFile(path("index.desc.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := ReadDescriptor("", []string{path("index.layout.json")})
+	if err != nil {
+		t.Fatalf("expected the blob index to have a single root: %v", err)
+	}
+	if root.Digest != expected.Digest {
+		t.Fatalf("expected the root of the blob index to be %v, got %v", expected.Digest, root.Digest)
+	}
+}
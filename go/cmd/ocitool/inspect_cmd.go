@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	inspectFormatTree = "tree"
+	inspectFormatJSON = "json"
+)
+
+// inspectNode is the content of a descriptor, and of its children for indexes.
+type inspectNode struct {
+	Descriptor ocispec.Descriptor `json:"descriptor"`
+	Index      *ocispec.Index     `json:"index,omitempty"`
+	Manifests  []inspectNode      `json:"manifests,omitempty"`
+	Manifest   *ocispec.Manifest  `json:"manifest,omitempty"`
+	Config     *ocispec.Image     `json:"config,omitempty"`
+}
+
+// InspectCmd prints the index, manifests and configs of an image.
+func InspectCmd(c *cli.Context) error {
+	// Checked before the image is loaded, which can take a while.
+	format := c.String("format")
+	if format != inspectFormatTree && format != inspectFormatJSON {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	ref := c.Args().First()
+	if ref == "" {
+		ref = c.String("desc")
+	}
+
+	provider, desc, err := LoadImage(c, ref)
+	if err != nil {
+		return err
+	}
+
+	if c.String("os") != "" || c.String("arch") != "" {
+		targetPlatform := platforms.DefaultSpec()
+		if osName := c.String("os"); osName != "" {
+			targetPlatform.OS = osName
+		}
+		if arch := c.String("arch"); arch != "" {
+			targetPlatform.Architecture = arch
+		}
+
+		desc, err = ociutil.ResolveManifest(c.Context, provider, desc, platforms.OnlyStrict(targetPlatform))
+		if err != nil {
+			return err
+		}
+	}
+
+	node, err := inspect(c.Context, provider, desc)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case inspectFormatTree:
+		printInspectTree(os.Stdout, node, "")
+		return nil
+	case inspectFormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(node)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func inspect(ctx context.Context, provider content.Provider, desc ocispec.Descriptor) (inspectNode, error) {
+	node := inspectNode{
+		Descriptor: desc,
+	}
+
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		index, err := ociutil.ImageIndexFromProvider(ctx, provider, desc)
+		if err != nil {
+			return inspectNode{}, err
+		}
+		node.Index = &index
+
+		for _, manifestDesc := range index.Manifests {
+			child, err := inspect(ctx, provider, manifestDesc)
+			if err != nil {
+				return inspectNode{}, err
+			}
+
+			node.Manifests = append(node.Manifests, child)
+		}
+	case images.IsManifestType(desc.MediaType):
+		manifest, err := ociutil.ImageManifestFromProvider(ctx, provider, desc)
+		if err != nil {
+			return inspectNode{}, err
+		}
+		node.Manifest = &manifest
+
+		if images.IsConfigType(manifest.Config.MediaType) {
+			config, err := ociutil.ImageConfigFromProvider(ctx, provider, manifest.Config)
+			if err != nil {
+				return inspectNode{}, err
+			}
+			node.Config = &config
+		}
+	}
+
+	return node, nil
+}
+
+func printInspectTree(w io.Writer, node inspectNode, indent string) {
+	desc := node.Descriptor
+
+	kind := "blob"
+	switch {
+	case node.Index != nil:
+		kind = "index"
+	case node.Manifest != nil:
+		kind = "manifest"
+	}
+
+	fmt.Fprintf(w, "%s%s %v\n", indent, kind, desc.Digest)
+	indent += "  "
+
+	fmt.Fprintf(w, "%smediaType: %s\n", indent, desc.MediaType)
+	fmt.Fprintf(w, "%ssize: %s\n", indent, humanSize(desc.Size))
+	if desc.Platform != nil {
+		fmt.Fprintf(w, "%splatform: %s\n", indent, platforms.Format(*desc.Platform))
+	}
+	printAnnotations(w, desc.Annotations, indent)
+
+	if node.Index != nil {
+		printAnnotations(w, node.Index.Annotations, indent)
+		for _, child := range node.Manifests {
+			printInspectTree(w, child, indent)
+		}
+	}
+
+	if node.Manifest != nil {
+		manifest := node.Manifest
+		printAnnotations(w, manifest.Annotations, indent)
+
+		fmt.Fprintf(w, "%sconfig %v\n", indent, manifest.Config.Digest)
+		printInspectConfig(w, node.Config, indent+"  ")
+
+		var total int64
+		for _, layer := range manifest.Layers {
+			total += layer.Size
+		}
+
+		fmt.Fprintf(w, "%slayers: %d (%s)\n", indent, len(manifest.Layers), humanSize(total))
+		for _, layer := range manifest.Layers {
+			fmt.Fprintf(w, "%s  %v %s %s", indent, layer.Digest, humanSize(layer.Size), layer.MediaType)
+			if label, ok := layer.Annotations[ociutil.AnnotationArtifactDescription]; ok {
+				fmt.Fprintf(w, " %s", label)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+func printInspectConfig(w io.Writer, config *ocispec.Image, indent string) {
+	if config == nil {
+		return
+	}
+
+	fmt.Fprintf(w, "%splatform: %s\n", indent, platforms.Format(ociutil.ImageConfigToPlatform(*config)))
+	if config.Created != nil {
+		fmt.Fprintf(w, "%screated: %v\n", indent, config.Created.UTC())
+	}
+	if config.Author != "" {
+		fmt.Fprintf(w, "%sauthor: %s\n", indent, config.Author)
+	}
+	if config.Config.User != "" {
+		fmt.Fprintf(w, "%suser: %s\n", indent, config.Config.User)
+	}
+	if config.Config.WorkingDir != "" {
+		fmt.Fprintf(w, "%sworkingDir: %s\n", indent, config.Config.WorkingDir)
+	}
+	if len(config.Config.Entrypoint) > 0 {
+		fmt.Fprintf(w, "%sentrypoint: %q\n", indent, config.Config.Entrypoint)
+	}
+	if len(config.Config.Cmd) > 0 {
+		fmt.Fprintf(w, "%scmd: %q\n", indent, config.Config.Cmd)
+	}
+	if len(config.Config.Env) > 0 {
+		fmt.Fprintf(w, "%senv:\n", indent)
+		for _, env := range config.Config.Env {
+			fmt.Fprintf(w, "%s  %s\n", indent, env)
+		}
+	}
+	if len(config.Config.Labels) > 0 {
+		fmt.Fprintf(w, "%slabels:\n", indent)
+		printSortedMap(w, config.Config.Labels, indent+"  ")
+	}
+	if len(config.History) > 0 {
+		fmt.Fprintf(w, "%shistory:\n", indent)
+		for _, history := range config.History {
+			var fields []string
+			if history.Created != nil {
+				fields = append(fields, history.Created.UTC().String())
+			}
+			if history.CreatedBy != "" {
+				fields = append(fields, history.CreatedBy)
+			}
+			if history.Comment != "" {
+				fields = append(fields, history.Comment)
+			}
+			if history.EmptyLayer {
+				fields = append(fields, "(empty layer)")
+			}
+			fmt.Fprintf(w, "%s  - %s\n", indent, strings.Join(fields, " "))
+		}
+	}
+}
+
+func printAnnotations(w io.Writer, annotations map[string]string, indent string) {
+	if len(annotations) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%sannotations:\n", indent)
+	printSortedMap(w, annotations, indent+"  ")
+}
+
+func printSortedMap(w io.Writer, m map[string]string, indent string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s=%s\n", indent, k, m[k])
+	}
+}
+
+// humanSize formats a size in bytes with a binary unit.
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
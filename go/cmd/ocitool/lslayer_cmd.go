@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/DataDog/rules_oci/go/pkg/layer"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	lsLayerFormatText = "text"
+	lsLayerFormatJSON = "json"
+)
+
+// LsLayerCmd lists the entries of a layer blob, from the given layouts or
+// from a registry.
+func LsLayerCmd(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected a layer digest, or a remote reference of the form name@digest")
+	}
+
+	// Checked before the layer is read, which can take a while.
+	format := c.String("format")
+	if format != lsLayerFormatText && format != lsLayerFormatJSON {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	// A digest of the layouts, or a remote reference.
+	arg := c.Args().First()
+	dgstStr := arg
+	layoutPaths := c.StringSlice("layout")
+	var repo string
+	if len(layoutPaths) == 0 {
+		var ok bool
+		_, dgstStr, ok = strings.Cut(arg, "@")
+		if !ok {
+			return fmt.Errorf("expected a remote reference of the form name@digest, got %q", arg)
+		}
+
+		var err error
+		repo, err = ociutil.RefToRepository(arg)
+		if err != nil {
+			return err
+		}
+	}
+
+	dgst, err := digest.Parse(dgstStr)
+	if err != nil {
+		return fmt.Errorf("invalid blob digest %q: %w", dgstStr, err)
+	}
+
+	rc, err := openBlob(c, layoutPaths, repo, dgst)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	br := bufio.NewReader(rc)
+	magic, _ := br.Peek(4)
+
+	compression, err := ociutil.DetectCompressionFromReader(bytes.NewReader(magic))
+	if err != nil {
+		return fmt.Errorf("failed to detect compression of layer %v: %w", dgst, err)
+	}
+
+	r, err := ociutil.Decompress(br, compression)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var entries []layer.Entry
+	err = layer.WalkTar(r, func(hdr *tar.Header, r io.Reader) error {
+		entry, err := layer.NewEntry(hdr, r, 0)
+		if err != nil {
+			return err
+		}
+
+		if format == lsLayerFormatText {
+			fmt.Println(formatEntry(entry))
+		} else {
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read layer %v: %w", dgst, err)
+	}
+
+	if format == lsLayerFormatText {
+		return nil
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// formatEntry formats an entry like `ls -l`.
+func formatEntry(e layer.Entry) string {
+	mode := fs.FileMode(e.Mode & 0o777)
+	switch e.Type {
+	case tar.TypeDir:
+		mode |= fs.ModeDir
+	case tar.TypeSymlink:
+		mode |= fs.ModeSymlink
+	case tar.TypeChar:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case tar.TypeBlock:
+		mode |= fs.ModeDevice
+	case tar.TypeFifo:
+		mode |= fs.ModeNamedPipe
+	}
+	if e.Mode&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if e.Mode&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if e.Mode&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+
+	s := fmt.Sprintf("%v %d/%d %10d %s %s", mode, e.UID, e.GID, e.Size, e.ModTime.UTC().Format("2006-01-02T15:04:05Z"), e.Path)
+	switch e.Type {
+	case tar.TypeSymlink:
+		s += " -> " + e.Linkname
+	case tar.TypeLink:
+		s += " link to " + e.Linkname
+	}
+	if e.Digest != "" {
+		s += " " + e.Digest.String()
+	}
+
+	return s
+}
@@ -28,6 +28,10 @@ var app = &cli.App{
 					Usage: "Pull only the top level manifests.",
 					Value: false,
 				},
+				&cli.StringFlag{
+					Name:  "out-layout",
+					Usage: "Write a blob index of the pulled blobs, with the blobs skipped by a shallow pull fetched from the registry when they're read.",
+				},
 			},
 		},
 		{
@@ -228,8 +232,9 @@ as described in https://github.com/opencontainers/image-spec/blob/main/image-lay
 				&cli.StringFlag{
 					Name: "layout-relative",
 				},
-				&cli.StringFlag{
-					Name: "desc",
+				&cli.StringSliceFlag{
+					Name:  "desc",
+					Usage: "Descriptors of the images to add to the layout, of the form [ref-name=]path. Can be repeated.",
 				},
 				&cli.StringSliceFlag{
 					Name:  "layout-files",
@@ -239,6 +244,11 @@ as described in https://github.com/opencontainers/image-spec/blob/main/image-lay
 					Name:  "out-dir",
 					Usage: "The directory that the OCI Image Layout will be written to.",
 				},
+				&cli.StringFlag{
+					Name:  "link",
+					Usage: "How the blob files are materialized in the layout: copy, hardlink, reflink, symlink or auto (reflink or hardlink). Blobs that can't be linked, e.g. across filesystems, are copied.",
+					Value: "copy",
+				},
 			},
 		},
 		{
@@ -262,6 +272,509 @@ as described in https://github.com/opencontainers/image-spec/blob/main/image-lay
 				},
 			},
 		},
+		{
+			Name: "import",
+			Description: `Imports an image from a "docker save" tarball or an OCI image layout tarball,
+writing a blob index and descriptor that can be used as the base of append-layers.`,
+			Action: ImportCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "archive",
+					Usage:    "Path to the docker or OCI archive to import.",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "repo-tag",
+					Usage: "Repo tag of the image to import, required when the archive contains several images.",
+				},
+				&cli.StringFlag{
+					Name:     "out-dir",
+					Usage:    "The directory that the blobs of the image will be written to.",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name: "out-layout",
+				},
+				&cli.StringFlag{
+					Name: "outd",
+				},
+			},
+		},
+		{
+			Name: "export",
+			Description: `Exports an image as a tarball that can be loaded with "docker load" or
+"podman load", or as an OCI image layout tarball.`,
+			Action: ExportCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringFlag{
+					Name:     "desc",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "The archive format, either docker-archive or oci-archive.",
+					Value: exportFormatDockerArchive,
+				},
+				&cli.StringFlag{
+					Name:  "os",
+					Usage: "The OS of the image to export, defaults to the host OS.",
+				},
+				&cli.StringFlag{
+					Name:  "arch",
+					Usage: "The architecture of the image to export, defaults to the host architecture.",
+				},
+				&cli.StringSliceFlag{
+					Name:  "repo-tag",
+					Usage: "Repo tags to name the image with when it is loaded.",
+				},
+				&cli.StringFlag{
+					Name:     "out",
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:  "convert",
+			Usage: "Convert an image between blob indexes, OCI image layouts and archives",
+			Description: `Converts an image between storage formats, given as <format>:<path> with
+the formats:
+
+  blob-index      a blob index file, its blobs are written in the blobs
+                  directory next to it
+  oci-layout      an OCI image layout directory
+  oci-archive     an OCI image layout tarball
+  docker-archive  a "docker save" tarball`,
+			Action: ConvertCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "from",
+					Usage:    "The storage to read the image from, as <format>:<path>.",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "to",
+					Usage:    "The storage to write the image to, as <format>:<path>.",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "desc",
+					Usage: "The image to convert: a descriptor file or ref:<name> of a blob index or layout, or a repo tag of an archive. Required when there are several images.",
+				},
+				&cli.StringFlag{
+					Name:  "ref-name",
+					Usage: "The name of the image in the blob index or layout written, defaults to the name of the image.",
+				},
+				&cli.StringSliceFlag{
+					Name:  "repo-tag",
+					Usage: "Repo tags to name the image with in the archive written.",
+				},
+				&cli.StringFlag{
+					Name:  "os",
+					Usage: "The OS of the image to write in a docker archive, defaults to the host OS.",
+				},
+				&cli.StringFlag{
+					Name:  "arch",
+					Usage: "The architecture of the image to write in a docker archive, defaults to the host architecture.",
+				},
+				&cli.StringFlag{
+					Name:  "link",
+					Usage: "How the blob files are materialized in a blob index or layout: copy, hardlink, reflink, symlink or auto (reflink or hardlink).",
+					Value: "copy",
+				},
+			},
+		},
+		{
+			Name: "fsck",
+			Description: `Checks the blobs reachable from the given descriptors, or from all of the
+images of the layouts: their sizes and digests, that manifests and configs are
+valid, that config diffIDs match the layers and that no blob is missing.`,
+			Action: FsckCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringSliceFlag{
+					Name:  "desc",
+					Usage: "Descriptors of the images to check, defaults to every image of the layouts. Can be repeated.",
+				},
+			},
+		},
+		{
+			Name: "gc",
+			Description: `Deletes the blobs of OCI Image Layout directories that aren't reachable from
+the images in their index.json or from the given descriptors.`,
+			Action: GCCmd,
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  "desc",
+					Usage: "Descriptors of images to keep in addition to the images of the layouts. Can be repeated.",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only report the unreachable blobs and the bytes that would be reclaimed.",
+				},
+			},
+		},
+		{
+			Name:      "graph",
+			Usage:     "Write the graph of the blobs of images as Graphviz DOT or Mermaid",
+			ArgsUsage: "[descriptor files or ref:<name> of the layouts...]",
+			Description: `Writes the DAG of the blobs reachable from the descriptors, from indexes to
+manifests to configs and layers, or from the roots of the layouts when no
+descriptors are given. Nodes are labeled with their size, names and Bazel
+labels, and the blobs shared by several descriptors are highlighted.`,
+			Action: GraphCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringSliceFlag{
+					Name:  "desc",
+					Usage: "Descriptors of the roots, in addition to the arguments.",
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Value: graphFormatDOT,
+					Usage: "Output format, dot or mermaid.",
+				},
+				&cli.StringFlag{
+					Name:  "out",
+					Usage: "The file to write, defaults to stdout.",
+				},
+			},
+		},
+		{
+			Name:      "inspect",
+			Usage:     "Print the index, manifests, configs and layers of an image",
+			ArgsUsage: "[descriptor file, ref:<name> of the layouts or remote reference]",
+			Description: `Prints an image built with the given layouts, or a remote image when no
+layouts are given, as a tree or as JSON.`,
+			Action: InspectCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringFlag{
+					Name:  "desc",
+					Usage: "Descriptor of the image, instead of the argument.",
+				},
+				&cli.StringFlag{
+					Name:  "os",
+					Usage: "Only inspect the manifest for this OS.",
+				},
+				&cli.StringFlag{
+					Name:  "arch",
+					Usage: "Only inspect the manifest for this architecture.",
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "The output format, either tree or json.",
+					Value: inspectFormatTree,
+				},
+			},
+		},
+		{
+			Name:      "diff",
+			Usage:     "Compare the configs, layers and files of two images",
+			ArgsUsage: "<image a> <image b>",
+			Description: `Compares two images, each a descriptor file or ref:<name> of the layouts
+when layouts are given, or a remote reference.`,
+			Action: DiffCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringFlag{
+					Name:  "os",
+					Usage: "The OS of the images to compare, defaults to the host OS.",
+				},
+				&cli.StringFlag{
+					Name:  "arch",
+					Usage: "The architecture of the images to compare, defaults to the host architecture.",
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "The output format, either text or json.",
+					Value: diffFormatText,
+				},
+				&cli.BoolFlag{
+					Name:  "exit-code",
+					Usage: "Exit with 1 if the images differ.",
+				},
+			},
+		},
+		{
+			Name:      "ls-layer",
+			Usage:     "List the entries of a layer",
+			ArgsUsage: "<digest | name@digest>",
+			Description: `Lists the entries of a layer blob from the given layouts, or from a registry
+when no layouts are given, without extracting it.`,
+			Action: LsLayerCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "The output format, either text or json.",
+					Value: lsLayerFormatText,
+				},
+			},
+		},
+		{
+			Name:      "get-blob",
+			Usage:     "Write a blob to a file",
+			ArgsUsage: "<layout | remote reference> <digest>",
+			Description: `Writes a blob from a blob index or OCI image layout, or from the repository of
+a remote reference, verifying its digest.`,
+			Action: GetBlobCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringFlag{
+					Name:  "out",
+					Usage: "The file to write, defaults to stdout.",
+				},
+			},
+		},
+		{
+			Name:      "extract-file",
+			Usage:     "Write a single file of an image",
+			ArgsUsage: "<descriptor file, ref:<name> of the layouts or remote reference> <path>",
+			Description: `Finds the topmost layer of the image containing the path, respecting whiteouts,
+and writes only that file. Hard links and symlinks are followed.`,
+			Action: ExtractFileCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringFlag{
+					Name:  "os",
+					Usage: "The OS of the image, defaults to the host OS.",
+				},
+				&cli.StringFlag{
+					Name:  "arch",
+					Usage: "The architecture of the image, defaults to the host architecture.",
+				},
+				&cli.StringFlag{
+					Name:  "out",
+					Usage: "The file to write, defaults to stdout.",
+				},
+			},
+		},
+		{
+			Name:      "serve",
+			Usage:     "Serve images with a read-only registry",
+			ArgsUsage: "<[name:tag=]descriptor file>...",
+			Description: `Serves the images of the descriptor files, and the blobs of the layouts, with
+the read API of the OCI distribution spec so they can be pulled with docker or
+podman. Each image is tagged with the name given before the descriptor file,
+or with the image name annotation of the descriptor. The registry of the name
+is ignored: "docker.io/team/app:v1" is pulled as "localhost:5000/team/app:v1".`,
+			Action: ServeCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringFlag{
+					Name:  "address",
+					Value: "localhost:5000",
+					Usage: "The address to listen on.",
+				},
+			},
+		},
+		{
+			Name:      "analyze",
+			Usage:     "Report the space wasted by the layers of an image",
+			ArgsUsage: "[descriptor file, ref:<name> of the layouts or remote reference]",
+			Description: `Reports the content of the layers that isn't in the merged filesystem: files
+overwritten by a higher layer, files deleted by a whiteout, and files with
+the same content as a file of a lower layer. The efficiency is the fraction
+of the content of the layers that isn't wasted.`,
+			Action: AnalyzeCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringFlag{
+					Name:  "desc",
+					Usage: "Descriptor of the image, instead of the argument.",
+				},
+				&cli.StringFlag{
+					Name:  "os",
+					Usage: "The OS of the image to analyze, defaults to the host OS.",
+				},
+				&cli.StringFlag{
+					Name:  "arch",
+					Usage: "The architecture of the image to analyze, defaults to the host architecture.",
+				},
+				&cli.IntFlag{
+					Name:  "top",
+					Value: 10,
+					Usage: "Number of wasted files to list, -1 for all.",
+				},
+				&cli.Float64Flag{
+					Name:  "min-efficiency",
+					Usage: "Fail when the efficiency is below this value, between 0 and 1.",
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Value: analyzeFormatText,
+					Usage: "Output format, text or json.",
+				},
+			},
+		},
+		{
+			Name:      "flatten",
+			Usage:     "Write the merged filesystem of an image",
+			ArgsUsage: "[descriptor file, ref:<name> of the layouts or remote reference]",
+			Description: `Applies the layers of an image in order, handling whiteouts, and writes the
+resulting filesystem as a single normalized tar or as a directory.`,
+			Action: FlattenCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringFlag{
+					Name:  "desc",
+					Usage: "Descriptor of the image, instead of the argument.",
+				},
+				&cli.StringFlag{
+					Name:  "os",
+					Usage: "The OS of the image to flatten, defaults to the host OS.",
+				},
+				&cli.StringFlag{
+					Name:  "arch",
+					Usage: "The architecture of the image to flatten, defaults to the host architecture.",
+				},
+				&cli.StringFlag{
+					Name:  "out",
+					Usage: "The tar file to write.",
+				},
+				&cli.StringFlag{
+					Name:  "out-dir",
+					Usage: "The directory to extract the filesystem to, it must be empty.",
+				},
+			},
+		},
+		{
+			Name:      "rebase",
+			Usage:     "Move an image from its old base image to a new one",
+			ArgsUsage: "[descriptor file, ref:<name> of the layouts or remote reference]",
+			Description: `Verifies that the lower layers of the image are the layers of --old-base, then
+replaces them with the layers of --new-base. The diffIDs and history of the
+base are swapped in the config, the rest of the config and the layers above
+the base are kept. The output blob index references the new blobs and the
+layers found in the layouts, so it can be given directly to push.`,
+			Action: RebaseCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringFlag{
+					Name:  "desc",
+					Usage: "Descriptor of the image, instead of the argument.",
+				},
+				&cli.StringFlag{
+					Name:     "old-base",
+					Usage:    "The base image the image was built on.",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "new-base",
+					Usage:    "The base image to move the image to.",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "os",
+					Usage: "The OS of the images, defaults to the host OS.",
+				},
+				&cli.StringFlag{
+					Name:  "arch",
+					Usage: "The architecture of the images, defaults to the host architecture.",
+				},
+				&cli.StringFlag{
+					Name:     "out-manifest",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "out-config",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "out-layout",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "outd",
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:      "squash",
+			Usage:     "Merge a range of layers of an image into a single layer",
+			ArgsUsage: "[descriptor file, ref:<name> of the layouts or remote reference]",
+			Description: `Replaces the layers in the range [--from, --to) with a single gzip compressed
+layer containing their merged content, keeping the whiteouts that apply to the
+layers below. The manifest, config diffIDs and history are rewritten to match.
+The output blob index references the new blobs and the remaining layers of
+the layouts, so it can be given directly to push.`,
+			Action: SquashCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name: "layout-relative",
+				},
+				&cli.StringFlag{
+					Name:  "desc",
+					Usage: "Descriptor of the image, instead of the argument.",
+				},
+				&cli.StringFlag{
+					Name:  "os",
+					Usage: "The OS of the image to squash, defaults to the host OS.",
+				},
+				&cli.StringFlag{
+					Name:  "arch",
+					Usage: "The architecture of the image to squash, defaults to the host architecture.",
+				},
+				&cli.IntFlag{
+					Name:  "from",
+					Usage: "Index of the first layer to squash.",
+				},
+				&cli.IntFlag{
+					Name:  "to",
+					Usage: "Index after the last layer to squash, defaults to the number of layers.",
+				},
+				&cli.StringFlag{
+					Name:  "base",
+					Usage: "Squash the layers above this base image, instead of the ones from --from. The lower layers and diffIDs of the image must match it.",
+				},
+				&cli.StringFlag{
+					Name:     "out-layer",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "out-manifest",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "out-config",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "out-layout",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "outd",
+					Required: true,
+				},
+			},
+		},
 		{
 			Name:   "push-blob",
 			Hidden: true,
@@ -296,7 +809,7 @@ as described in https://github.com/opencontainers/image-spec/blob/main/image-lay
 		},
 		&cli.StringSliceFlag{
 			Name:     "layout",
-			Usage:    "Filepath to a directory with the OCI Layout structure, if it doesn't exist it creates a new directory",
+			Usage:    "Filepath to a blob index file or to a directory with the OCI Layout structure, the format is detected automatically. Can be repeated.",
 			Required: false,
 		},
 		&cli.UintFlag{
@@ -304,6 +817,14 @@ as described in https://github.com/opencontainers/image-spec/blob/main/image-lay
 			Usage: "Parallelism of pushing/pulling operations",
 			Value: 1, // TODO raise, used by pull impl
 		},
+		&cli.BoolFlag{
+			Name:  "fetch-remote-blobs",
+			Usage: "Fetch the remote blobs of the blob indexes, e.g. the layers of a shallow pull, from their registry when they're read. Otherwise only local blobs can be read. Ignored by fsck and gc, which never fetch blobs.",
+		},
+		&cli.BoolFlag{
+			Name:  "verify-blobs",
+			Usage: "Check the size and digest of the blobs of the local layouts as they're read",
+		},
 	},
 }
 
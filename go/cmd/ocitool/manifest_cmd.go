@@ -29,7 +29,7 @@ func CreateImageManifestCmd(c *cli.Context) error {
 	}
 
 	descriptorPaths := c.StringSlice("layer-desc")
-	descriptors, err := FilePathsToDescriptors(c.Context, descriptorPaths, true, bi)
+	descriptors, err := FilePathsToDescriptors(c.Context, descriptorPaths, nil, true, bi)
 	if err != nil {
 		return err
 	}
@@ -54,8 +54,11 @@ func CreateImageManifestCmd(c *cli.Context) error {
 		return err
 	}
 
-	// Append image index to blob index
-	bi.Blobs[desc.Digest] = c.String("out-manifest")
+	// Append image manifest to blob index
+	manifestDesc := desc
+	manifestDesc.MediaType = ocispec.MediaTypeImageManifest
+	bi.Add(manifestDesc, c.String("out-manifest"))
+	bi.SetRoot(defaultRootName, manifestDesc)
 
 	err = bi.WriteToFile(c.String("out-layout"))
 	if err != nil {
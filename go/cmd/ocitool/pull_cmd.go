@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+
 	"golang.org/x/sync/semaphore"
 
+	"github.com/DataDog/rules_oci/go/pkg/blob"
 	"github.com/DataDog/rules_oci/go/pkg/ociutil"
 
+	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/images"
 	"github.com/containerd/log"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -74,5 +80,43 @@ func PullCmd(c *cli.Context) error {
 		return err
 	}
 
+	if outLayout := c.String("out-layout"); outLayout != "" {
+		bi, err := pulledBlobIndex(ctx, layout, layoutPath, name, desc)
+		if err != nil {
+			return err
+		}
+
+		return bi.WriteToFile(outLayout)
+	}
+
 	return nil
 }
+
+// pulledBlobIndex returns a blob index of the blobs of desc in the layout, and
+// of the blobs that weren't pulled as remote blobs of the repository of name.
+func pulledBlobIndex(ctx context.Context, layout content.Provider, layoutPath, name string, desc ocispec.Descriptor) (*blob.Index, error) {
+	repo, err := ociutil.RefToRepository(name)
+	if err != nil {
+		return nil, err
+	}
+
+	bi := &blob.Index{}
+	bi.AddRoot(name, desc)
+
+	children := images.ChildrenHandler(layout)
+	err = images.Walk(ctx, images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		path := filepath.Join(layoutPath, "blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+		if _, err := os.Stat(path); err != nil {
+			bi.AddRemote(desc, repo)
+			return nil, nil
+		}
+
+		bi.Add(desc, path)
+		return children(ctx, desc)
+	}), desc)
+	if err != nil {
+		return nil, err
+	}
+
+	return bi, nil
+}
@@ -17,9 +17,9 @@ func PushCmd(c *cli.Context) error {
 		return err
 	}
 
-	allProviders := ociutil.MultiProvider(localProviders...)
+	allProviders := localProvider(c, localProviders...)
 
-	baseDesc, err := ociutil.ReadDescriptorFromFile(c.String("desc"))
+	baseDesc, err := ReadDescriptor(c.String("desc"), c.StringSlice("layout"))
 	if err != nil {
 		return fmt.Errorf("failed to read base descriptor: %w", err)
 	}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+	"github.com/DataDog/rules_oci/go/pkg/layer"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/urfave/cli/v2"
+)
+
+// RebaseCmd moves an image from its old base image to a new one, and writes
+// the new manifest and config with a blob index that can be used to push the
+// image.
+func RebaseCmd(c *cli.Context) error {
+	ref := c.Args().First()
+	if ref == "" {
+		ref = c.String("desc")
+	}
+
+	provider, desc, err := LoadImageManifest(c, ref)
+	if err != nil {
+		return err
+	}
+
+	oldBaseProvider, oldBaseDesc, err := LoadImageManifest(c, c.String("old-base"))
+	if err != nil {
+		return fmt.Errorf("failed to load old base: %w", err)
+	}
+
+	newBaseProvider, newBaseDesc, err := LoadImageManifest(c, c.String("new-base"))
+	if err != nil {
+		return fmt.Errorf("failed to load new base: %w", err)
+	}
+
+	outIngestor := layer.NewAppendIngester(c.String("out-manifest"), c.String("out-config"))
+
+	newManifest, newConfig, err := layer.RebaseImage(
+		c.Context,
+		ociutil.SplitStore(outIngestor, ociutil.MultiProvider(provider, oldBaseProvider, newBaseProvider)),
+		desc,
+		oldBaseDesc,
+		newBaseDesc,
+	)
+	if err != nil {
+		return err
+	}
+
+	outIndex := &blob.Index{}
+	outIndex.Add(newManifest, c.String("out-manifest"))
+	outIndex.Add(newConfig, c.String("out-config"))
+	outIndex.SetRoot(defaultRootName, newManifest)
+
+	manifest, err := ociutil.ImageManifestFromProvider(c.Context, ociutil.MultiProvider(outIndex, provider, newBaseProvider), newManifest)
+	if err != nil {
+		return err
+	}
+
+	// Reference the layers from the local layouts, so that the image can be
+	// pushed with the new blob index alone.
+	err = addLocalBlobs(c, outIndex, manifest.Layers)
+	if err != nil {
+		return err
+	}
+
+	err = outIndex.WriteToFile(c.String("out-layout"))
+	if err != nil {
+		return err
+	}
+
+	return ociutil.WriteDescriptorToFile(c.String("outd"), newManifest)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/images"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// ServeCmd serves the images of the given descriptor files, and the blobs of
+// the layouts, with the read API of the OCI distribution spec.
+func ServeCmd(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("expected descriptor files of the form [name:tag=]path")
+	}
+
+	localProviders, err := LoadLocalProviders(c.StringSlice("layout"), c.String("layout-relative"))
+	if err != nil {
+		return err
+	}
+
+	handler := ociutil.NewRegistryHandler(localProvider(c, localProviders...))
+
+	for _, arg := range c.Args().Slice() {
+		ref, path := parseRefNameAndPath(arg)
+
+		desc, err := ociutil.ReadDescriptorFromFile(path)
+		if err != nil {
+			return err
+		}
+
+		if ref == "" {
+			ref = desc.Annotations[images.AnnotationImageName]
+			if ref == "" {
+				return fmt.Errorf("descriptor %v has no %v annotation, give its tag as name:tag=%v", path, images.AnnotationImageName, path)
+			}
+		}
+
+		err = handler.AddTag(ref, desc)
+		if err != nil {
+			return fmt.Errorf("failed to tag %v: %w", path, err)
+		}
+	}
+
+	lis, err := net.Listen("tcp", c.String("address"))
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: handler}
+	go func() {
+		<-c.Context.Done()
+		srv.Close()
+	}()
+
+	for _, repo := range handler.Repositories() {
+		log.Infof("serving %v/%v", lis.Addr(), repo)
+	}
+
+	err = srv.Serve(lis)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+	"github.com/DataDog/rules_oci/go/pkg/layer"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/urfave/cli/v2"
+)
+
+// SquashCmd merges a range of layers of an image into a single layer and
+// writes the new manifest, config and layer, with a blob index that can be
+// used to push the image.
+func SquashCmd(c *cli.Context) error {
+	if c.IsSet("from") && c.IsSet("base") {
+		return fmt.Errorf("--from and --base are exclusive, --base squashes the layers above the base")
+	}
+
+	ref := c.Args().First()
+	if ref == "" {
+		ref = c.String("desc")
+	}
+
+	provider, desc, err := LoadImageManifest(c, ref)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := ociutil.ImageManifestFromProvider(c.Context, provider, desc)
+	if err != nil {
+		return err
+	}
+
+	from, to := c.Int("from"), c.Int("to")
+	if to == 0 {
+		to = len(manifest.Layers)
+	}
+
+	if baseRef := c.String("base"); baseRef != "" {
+		baseProvider, baseDesc, err := LoadImageManifest(c, baseRef)
+		if err != nil {
+			return fmt.Errorf("failed to load base image: %w", err)
+		}
+
+		err = layer.CheckBase(c.Context, ociutil.MultiProvider(provider, baseProvider), desc, baseDesc)
+		if err != nil {
+			return err
+		}
+
+		baseManifest, err := ociutil.ImageManifestFromProvider(c.Context, baseProvider, baseDesc)
+		if err != nil {
+			return err
+		}
+
+		from = len(baseManifest.Layers)
+	}
+
+	layerFile, err := os.Create(c.String("out-layer"))
+	if err != nil {
+		return err
+	}
+	defer layerFile.Close()
+
+	outIngestor := layer.NewAppendIngester(c.String("out-manifest"), c.String("out-config"))
+
+	newManifest, newConfig, newLayer, err := layer.SquashImage(
+		c.Context,
+		ociutil.SplitStore(outIngestor, provider),
+		desc,
+		from,
+		to,
+		layerFile,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = layerFile.Close()
+	if err != nil {
+		return err
+	}
+
+	outIndex := &blob.Index{}
+	outIndex.Add(newManifest, c.String("out-manifest"))
+	outIndex.Add(newConfig, c.String("out-config"))
+	outIndex.Add(newLayer, c.String("out-layer"))
+	outIndex.SetRoot(defaultRootName, newManifest)
+
+	// Reference the remaining layers from the local layouts, so that the
+	// image can be pushed with the new blob index alone.
+	err = addLocalBlobs(c, outIndex, append(manifest.Layers[:from:from], manifest.Layers[to:]...))
+	if err != nil {
+		return err
+	}
+
+	err = outIndex.WriteToFile(c.String("out-layout"))
+	if err != nil {
+		return err
+	}
+
+	return ociutil.WriteDescriptorToFile(c.String("outd"), newManifest)
+}
@@ -3,22 +3,49 @@ package blob
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/filters"
 	"github.com/opencontainers/go-digest"
 
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// IndexVersion is the version of the format written by Index.WriteTo.
+//
+// Version 1 only maps digests to paths: {"Blobs": {"<digest>": "<path>"}}.
+// Version 2 also has the media type, size and annotations of the blobs, blobs
+// fetched from a remote repository instead of a local path, and named root
+// descriptors:
+//
+//	{
+//	  "version": 2,
+//	  "blobs": {
+//	    "<digest>": {"path": "<path>", "mediaType": "...", "size": 123, "annotations": {...}},
+//	    "<digest>": {"remote": "<registry>/<repository>", "mediaType": "...", "size": 123}
+//	  },
+//	  "roots": {"<name>": <descriptor>}
+//	}
+const IndexVersion = 2
+
+// CacheDirEnv is the environment variable of the directory remote blobs are
+// cached in.
+const CacheDirEnv = "OCI_CACHE_DIR"
+
 var (
 	ErrNotBlobIndex = fmt.Errorf("provider not a blob index")
 
 	_ content.Provider = &Index{}
+	_ content.Manager  = &Index{}
 	_ content.ReaderAt = &fileWithSize{}
 )
 
@@ -51,12 +78,56 @@ func LoadIndexFromFile(path string) (content.Provider, error) {
 
 	err = json.NewDecoder(f).Decode(&idx)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("couldn't parse index %v: %w", path, err)
 	}
 
 	return &idx, nil
 }
 
+// LoadIndexFromOciLayout creates an index of all of the blobs in an OCI Image
+// Layout directory (https://github.com/opencontainers/image-spec/blob/main/image-layout.md).
+func LoadIndexFromOciLayout(dir string) (*Index, error) {
+	idx := &Index{
+		Blobs: make(map[digest.Digest]string),
+	}
+
+	blobsDir := filepath.Join(dir, "blobs")
+	err := filepath.WalkDir(blobsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(blobsDir, path)
+		if err != nil {
+			return err
+		}
+
+		alg, encoded, ok := strings.Cut(filepath.ToSlash(rel), "/")
+		if !ok {
+			return nil
+		}
+
+		dgst := digest.NewDigestFromEncoded(digest.Algorithm(alg), encoded)
+		if dgst.Validate() != nil {
+			// Not a blob, e.g. a BUILD file.
+			return nil
+		}
+
+		idx.Blobs[dgst] = path
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read layout: %w", err)
+	}
+
+	return idx, nil
+}
+
 // Rel creates a new index with all paths relative to the provided path.
 //
 // This is used in Bazel when using path vs short_path.
@@ -84,23 +155,214 @@ func (bi *Index) Merge(bim *Index) {
 		bi.Blobs[k] = v
 	}
 
+	if len(bim.Descriptors) > 0 && bi.Descriptors == nil {
+		bi.Descriptors = make(map[digest.Digest]ocispec.Descriptor)
+	}
+	for k, v := range bim.Descriptors {
+		bi.Descriptors[k] = v
+	}
+
+	if len(bim.Roots) > 0 && bi.Roots == nil {
+		bi.Roots = make(map[string]ocispec.Descriptor)
+	}
+	for k, v := range bim.Roots {
+		bi.Roots[k] = v
+	}
+
+	if len(bim.Remotes) > 0 && bi.Remotes == nil {
+		bi.Remotes = make(map[digest.Digest]string)
+	}
+	for k, v := range bim.Remotes {
+		bi.Remotes[k] = v
+	}
+
+	if bi.Fetcher == nil {
+		bi.Fetcher = bim.Fetcher
+	}
+	if bi.CacheDir == "" {
+		bi.CacheDir = bim.CacheDir
+	}
 }
 
 func (bi *Index) Clone() *Index {
 	newbi := &Index{
 		Blobs: make(map[digest.Digest]string),
 	}
+	newbi.Merge(bi)
 
-	for dgst, path := range bi.Blobs {
-		newbi.Blobs[dgst] = path
+	return newbi
+}
+
+// Add adds the blob of desc at path, recording its size, media type and
+// annotations.
+func (bi *Index) Add(desc ocispec.Descriptor, path string) {
+	if bi.Blobs == nil {
+		bi.Blobs = make(map[digest.Digest]string)
+	}
+	if bi.Descriptors == nil {
+		bi.Descriptors = make(map[digest.Digest]ocispec.Descriptor)
 	}
 
-	return newbi
+	bi.Blobs[desc.Digest] = path
+	bi.Descriptors[desc.Digest] = ocispec.Descriptor{
+		MediaType:   desc.MediaType,
+		Digest:      desc.Digest,
+		Size:        desc.Size,
+		Annotations: desc.Annotations,
+	}
+}
+
+// AddRemote adds the blob of desc to be fetched from the repository repo, e.g.
+// "ghcr.io/datadog/rules_oci/ubuntu", the first time it's read.
+func (bi *Index) AddRemote(desc ocispec.Descriptor, repo string) {
+	if bi.Remotes == nil {
+		bi.Remotes = make(map[digest.Digest]string)
+	}
+	if bi.Descriptors == nil {
+		bi.Descriptors = make(map[digest.Digest]ocispec.Descriptor)
+	}
+
+	bi.Remotes[desc.Digest] = repo
+	bi.Descriptors[desc.Digest] = ocispec.Descriptor{
+		MediaType:   desc.MediaType,
+		Digest:      desc.Digest,
+		Size:        desc.Size,
+		Annotations: desc.Annotations,
+	}
+}
+
+// AddRoot names a root descriptor of the index, e.g. an image manifest.
+func (bi *Index) AddRoot(name string, desc ocispec.Descriptor) {
+	if bi.Roots == nil {
+		bi.Roots = make(map[string]ocispec.Descriptor)
+	}
+
+	bi.Roots[name] = desc
+}
+
+// SetRoot makes desc the only root of the index, e.g. for an index merged
+// from the indexes of the inputs of an image, whose roots are dropped.
+func (bi *Index) SetRoot(name string, desc ocispec.Descriptor) {
+	bi.Roots = map[string]ocispec.Descriptor{name: desc}
+}
+
+type indexV1 struct {
+	Blobs map[digest.Digest]string
+}
+
+type indexV2 struct {
+	Version int                           `json:"version"`
+	Blobs   map[digest.Digest]indexBlob   `json:"blobs"`
+	Roots   map[string]ocispec.Descriptor `json:"roots,omitempty"`
+}
+
+type indexBlob struct {
+	Path string `json:"path,omitempty"`
+	// Remote is the repository of a blob fetched when it's read.
+	Remote    string `json:"remote,omitempty"`
+	MediaType string `json:"mediaType,omitempty"`
+	// Size is only set when the descriptor of the blob is known.
+	Size        *int64            `json:"size,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// MarshalJSON encodes the index in the format of IndexVersion.
+func (bi Index) MarshalJSON() ([]byte, error) {
+	idx := indexV2{
+		Version: IndexVersion,
+		Blobs:   make(map[digest.Digest]indexBlob, len(bi.Blobs)),
+		Roots:   bi.Roots,
+	}
+
+	for _, dgst := range bi.digests() {
+		blob := indexBlob{
+			Path:   bi.Blobs[dgst],
+			Remote: bi.Remotes[dgst],
+		}
+		if desc, ok := bi.Descriptors[dgst]; ok {
+			size := desc.Size
+			blob.MediaType = desc.MediaType
+			blob.Size = &size
+			blob.Annotations = desc.Annotations
+		}
+
+		idx.Blobs[dgst] = blob
+	}
+
+	return json.Marshal(idx)
+}
+
+// UnmarshalJSON decodes an index of any version.
+func (bi *Index) UnmarshalJSON(data []byte) error {
+	var versioned struct {
+		Version int `json:"version"`
+	}
+	err := json.Unmarshal(data, &versioned)
+	if err != nil {
+		return err
+	}
+
+	switch versioned.Version {
+	case 0, 1:
+		var idx indexV1
+		err = json.Unmarshal(data, &idx)
+		if err != nil {
+			return err
+		}
+
+		*bi = Index{
+			Blobs: idx.Blobs,
+		}
+	case 2:
+		var idx indexV2
+		err = json.Unmarshal(data, &idx)
+		if err != nil {
+			return err
+		}
+
+		*bi = Index{
+			Blobs: make(map[digest.Digest]string, len(idx.Blobs)),
+			Roots: idx.Roots,
+		}
+		for dgst, blob := range idx.Blobs {
+			if blob.Path != "" {
+				bi.Blobs[dgst] = blob.Path
+			}
+			if blob.Remote != "" {
+				if bi.Remotes == nil {
+					bi.Remotes = make(map[digest.Digest]string)
+				}
+				bi.Remotes[dgst] = blob.Remote
+			}
+
+			if blob.Size != nil {
+				if bi.Descriptors == nil {
+					bi.Descriptors = make(map[digest.Digest]ocispec.Descriptor)
+				}
+				bi.Descriptors[dgst] = ocispec.Descriptor{
+					MediaType:   blob.MediaType,
+					Digest:      dgst,
+					Size:        *blob.Size,
+					Annotations: blob.Annotations,
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported blob index version %d", versioned.Version)
+	}
+
+	return nil
 }
 
 // WriteTo writes the index to a stream.
-func (bi *Index) WriteTo(writer io.Writer) error {
-	return json.NewEncoder(writer).Encode(bi)
+func (bi *Index) WriteTo(writer io.Writer) (int64, error) {
+	data, err := json.Marshal(bi)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := writer.Write(append(data, '\n'))
+	return int64(n), err
 }
 
 // WriteToFile writes the index to a file.
@@ -111,21 +373,169 @@ func (bi *Index) WriteToFile(path string) error {
 	}
 	defer f.Close()
 
-	return bi.WriteTo(f)
+	_, err = bi.WriteTo(f)
+	return err
 }
 
 // BlobIndex is a mapping from digest to a filepath
 type Index struct {
-	// TODO(griffin): add support for top level index
-	// Index digest.Digest
-
 	Blobs map[digest.Digest]string
+
+	// Descriptors are the descriptors of the blobs whose size, media type and
+	// annotations are known.
+	Descriptors map[digest.Digest]ocispec.Descriptor
+
+	// Roots are named descriptors of the index, e.g. image manifests.
+	Roots map[string]ocispec.Descriptor
+
+	// Remotes are the repositories of the blobs that are fetched from a
+	// registry the first time they're read, e.g. the layers of a shallow
+	// pull. Blobs with a local path are never fetched.
+	Remotes map[digest.Digest]string
+
+	// Fetcher fetches the remote blobs, they can't be read without it.
+	Fetcher RemoteFetcher
+
+	// CacheDir is the directory remote blobs are cached in, see DefaultCacheDir.
+	CacheDir string
+}
+
+// RemoteFetcher fetches the blob of desc from the repository repo.
+type RemoteFetcher func(ctx context.Context, repo string, desc ocispec.Descriptor) (io.ReadCloser, error)
+
+// DefaultCacheDir returns the directory of CacheDirEnv, or a rules_oci
+// directory of the user cache directory, or of the temporary directory if the
+// user has none, e.g. in a Bazel sandbox.
+func DefaultCacheDir() string {
+	if dir := os.Getenv(CacheDirEnv); dir != "" {
+		return dir
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "rules_oci", "blobs")
+}
+
+// digests returns the digests of the local and remote blobs, sorted.
+func (bi *Index) digests() []digest.Digest {
+	dgsts := make([]digest.Digest, 0, len(bi.Blobs)+len(bi.Remotes))
+	for dgst := range bi.Blobs {
+		dgsts = append(dgsts, dgst)
+	}
+	for dgst := range bi.Remotes {
+		if _, ok := bi.Blobs[dgst]; !ok {
+			dgsts = append(dgsts, dgst)
+		}
+	}
+	sort.Slice(dgsts, func(i, j int) bool { return dgsts[i] < dgsts[j] })
+
+	return dgsts
+}
+
+// Info returns the info of a blob, from its descriptor if it's known or from
+// its file otherwise. The annotations of the blob are returned as labels.
+//
+// The size of remote blobs without a descriptor is only known once they are
+// cached.
+func (bi *Index) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	path, ok := bi.Blobs[dgst]
+	if !ok {
+		if _, ok := bi.Remotes[dgst]; !ok {
+			return content.Info{}, fmt.Errorf("blob %v: %w", dgst, errdefs.ErrNotFound)
+		}
+		path = bi.cachePath(dgst)
+	}
+
+	if desc, ok := bi.Descriptors[dgst]; ok {
+		return content.Info{
+			Digest: dgst,
+			Size:   desc.Size,
+			Labels: desc.Annotations,
+		}, nil
+	}
+
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) && !ok {
+		return content.Info{Digest: dgst}, nil
+	} else if errors.Is(err, fs.ErrNotExist) {
+		return content.Info{}, fmt.Errorf("blob %v (%v): %w", dgst, path, errdefs.ErrNotFound)
+	} else if err != nil {
+		return content.Info{}, err
+	}
+
+	return content.Info{
+		Digest:    dgst,
+		Size:      fi.Size(),
+		CreatedAt: fi.ModTime(),
+		UpdatedAt: fi.ModTime(),
+	}, nil
+}
+
+// Update isn't supported, the index is read-only.
+func (bi *Index) Update(ctx context.Context, info content.Info, fieldpaths ...string) (content.Info, error) {
+	return content.Info{}, fmt.Errorf("blob index update: %w", errdefs.ErrNotImplemented)
+}
+
+// Walk calls fn with the info of the blobs matching filters, sorted by
+// digest.
+func (bi *Index) Walk(ctx context.Context, fn content.WalkFunc, fs ...string) error {
+	filter, err := filters.ParseAll(fs...)
+	if err != nil {
+		return err
+	}
+
+	for _, dgst := range bi.digests() {
+		info, err := bi.Info(ctx, dgst)
+		if err != nil {
+			return err
+		}
+
+		if !filter.Match(content.AdaptInfo(info)) {
+			continue
+		}
+
+		err = fn(info)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a blob from the index, its file is kept.
+func (bi *Index) Delete(ctx context.Context, dgst digest.Digest) error {
+	_, local := bi.Blobs[dgst]
+	_, remote := bi.Remotes[dgst]
+	if !local && !remote {
+		return fmt.Errorf("blob %v: %w", dgst, errdefs.ErrNotFound)
+	}
+
+	delete(bi.Blobs, dgst)
+	delete(bi.Remotes, dgst)
+	delete(bi.Descriptors, dgst)
+
+	return nil
 }
 
+// ReaderAt opens the file of a blob. Remote blobs are fetched and cached the
+// first time they're read.
 func (bi *Index) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
 	path, ok := bi.Blobs[desc.Digest]
 	if !ok {
-		return nil, errdefs.ErrNotFound
+		repo, ok := bi.Remotes[desc.Digest]
+		if !ok {
+			return nil, errdefs.ErrNotFound
+		}
+
+		var err error
+		path, err = bi.fetchRemote(ctx, repo, desc)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	f, err := os.Open(path)
@@ -153,3 +563,77 @@ type fileWithSize struct {
 func (f *fileWithSize) Size() int64 {
 	return f.size
 }
+
+func (bi *Index) cachePath(dgst digest.Digest) string {
+	dir := bi.CacheDir
+	if dir == "" {
+		dir = DefaultCacheDir()
+	}
+
+	return filepath.Join(dir, dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// fetchRemote returns the path of a cached remote blob, fetching it first if
+// it isn't cached yet. The fetched content is verified against the digest of
+// the blob, and its size if it's known, before being moved into the cache.
+func (bi *Index) fetchRemote(ctx context.Context, repo string, desc ocispec.Descriptor) (string, error) {
+	dgst := desc.Digest
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+
+	if recorded, ok := bi.Descriptors[dgst]; ok {
+		desc = recorded
+	}
+
+	path := bi.cachePath(dgst)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	if bi.Fetcher == nil {
+		return "", fmt.Errorf("blob %v is in %v, but can't be fetched: %w", dgst, repo, errdefs.ErrNotFound)
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	rc, err := bi.Fetcher(ctx, repo, desc)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch blob %v from %v: %w", dgst, repo, err)
+	}
+	defer rc.Close()
+
+	// Write to a temporary file of the cache directory, so that concurrent
+	// fetches of the blob don't see a partial file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+dgst.Encoded()+"-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	digester := dgst.Algorithm().Digester()
+	n, err := io.Copy(io.MultiWriter(tmp, digester.Hash()), rc)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch blob %v from %v: %w", dgst, repo, err)
+	}
+
+	if desc.Size > 0 && n != desc.Size {
+		return "", fmt.Errorf("fetched blob %v from %v has size %d, expected %d: %w", dgst, repo, n, desc.Size, errdefs.ErrFailedPrecondition)
+	}
+	if actual := digester.Digest(); actual != dgst {
+		return "", fmt.Errorf("fetched blob %v from %v has digest %v: %w", dgst, repo, actual, errdefs.ErrFailedPrecondition)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
@@ -0,0 +1,216 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestIndexVersions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	writeFile := func(name, data string) (ocispec.Descriptor, string) {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    digest.FromString(data),
+			Size:      int64(len(data)),
+		}, path
+	}
+
+	known, knownPath := writeFile("known", "known content")
+	known.Annotations = map[string]string{"foo": "bar"}
+	unknown, unknownPath := writeFile("unknown", "unknown")
+
+	bi := &Index{}
+	bi.Add(known, knownPath)
+	bi.Blobs[unknown.Digest] = unknownPath
+	bi.AddRoot("image", known)
+
+	var buf bytes.Buffer
+	_, err := bi.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Index
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, bi) {
+		t.Fatalf("expected %+v after a round trip, got %+v", bi, decoded)
+	}
+
+	v1 := `{"Blobs":{"` + known.Digest.String() + `":"` + knownPath + `"}}`
+	err = json.Unmarshal([]byte(v1), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Blobs[known.Digest] != knownPath || decoded.Descriptors != nil {
+		t.Fatalf("expected a v1 index with only paths, got %+v", decoded)
+	}
+
+	var infos []content.Info
+	err = bi.Walk(ctx, func(info content.Info) error {
+		infos = append(infos, info)
+		return nil
+	}, "labels.foo==bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Digest != known.Digest || infos[0].Size != known.Size {
+		t.Fatalf("expected the info of the known blob, got %+v", infos)
+	}
+
+	info, err := bi.Info(ctx, unknown.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != unknown.Size {
+		t.Fatalf("expected size %d from the file, got %d", unknown.Size, info.Size)
+	}
+}
+
+func TestIndexRemote(t *testing.T) {
+	ctx := context.Background()
+
+	data := []byte("remote layer")
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+
+	var fetches int
+	served := data
+	fetcher := func(ctx context.Context, repo string, fetched ocispec.Descriptor) (io.ReadCloser, error) {
+		if repo != "ghcr.io/foo/bar" {
+			t.Errorf("expected a fetch from ghcr.io/foo/bar, got %v", repo)
+		}
+		if fetched.Size != desc.Size {
+			t.Errorf("expected the recorded size %d, got %d", desc.Size, fetched.Size)
+		}
+
+		fetches++
+		return io.NopCloser(bytes.NewReader(served)), nil
+	}
+
+	bi := &Index{
+		CacheDir: t.TempDir(),
+	}
+	bi.AddRemote(desc, "ghcr.io/foo/bar")
+
+	// Remote blobs round trip, without the fetcher and cache.
+	var buf bytes.Buffer
+	_, err := bi.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Index
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Remotes, bi.Remotes) || !reflect.DeepEqual(decoded.Descriptors, bi.Descriptors) || len(decoded.Blobs) != 0 {
+		t.Fatalf("expected %+v after a round trip, got %+v", bi, decoded)
+	}
+
+	_, err = decoded.ReaderAt(ctx, ocispec.Descriptor{Digest: desc.Digest})
+	if !errdefs.IsNotFound(err) {
+		t.Errorf("expected a not found error without fetcher, got %v", err)
+	}
+
+	info, err := bi.Info(ctx, desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != desc.Size {
+		t.Errorf("expected size %d, got %d", desc.Size, info.Size)
+	}
+
+	bi.Fetcher = fetcher
+
+	t.Run("mismatch", func(t *testing.T) {
+		served = []byte("corrupted!!!")
+		defer func() { served = data }()
+
+		_, err := bi.ReaderAt(ctx, ocispec.Descriptor{Digest: desc.Digest})
+		if !errdefs.IsFailedPrecondition(err) {
+			t.Fatalf("expected a verification error, got %v", err)
+		}
+
+		entries, err := os.ReadDir(filepath.Join(bi.CacheDir, "sha256"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("expected nothing to be cached, got %v", entries)
+		}
+	})
+
+	fetches = 0
+
+	// Blobs are fetched once, then read from the cache.
+	for i := 0; i < 2; i++ {
+		got, err := content.ReadBlob(ctx, bi, ocispec.Descriptor{Digest: desc.Digest})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("expected %q, got %q", data, got)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected 1 fetch, got %d", fetches)
+	}
+
+	var walked []digest.Digest
+	err = bi.Walk(ctx, func(info content.Info) error {
+		walked = append(walked, info.Digest)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(walked, []digest.Digest{desc.Digest}) {
+		t.Errorf("expected to walk %v, got %v", desc.Digest, walked)
+	}
+}
+
+func TestIndexSetRoot(t *testing.T) {
+	base := ocispec.Descriptor{Digest: digest.FromString("base")}
+	image := ocispec.Descriptor{Digest: digest.FromString("image")}
+
+	var inputs Index
+	inputs.AddRoot("base", base)
+
+	bi := &Index{}
+	bi.Merge(&inputs)
+	bi.SetRoot("image", image)
+
+	expected := map[string]ocispec.Descriptor{"image": image}
+	if !reflect.DeepEqual(bi.Roots, expected) {
+		t.Fatalf("expected roots %v, got %v", expected, bi.Roots)
+	}
+	if len(inputs.Roots) != 1 {
+		t.Fatalf("expected the roots of the merged index to be unchanged, got %v", inputs.Roots)
+	}
+}
@@ -0,0 +1,161 @@
+package layer
+
+import (
+	"archive/tar"
+	"context"
+	"sort"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// WasteKind is the reason the content of a file is wasted.
+type WasteKind string
+
+const (
+	// WasteOverwritten is a file replaced by a file of a higher layer.
+	WasteOverwritten WasteKind = "overwritten"
+	// WasteDeleted is a file deleted by a whiteout of a higher layer.
+	WasteDeleted WasteKind = "deleted"
+	// WasteDuplicate is a file with the same content as a file of a lower
+	// layer.
+	WasteDuplicate WasteKind = "duplicate"
+)
+
+// Waste is a file whose content is shipped in a layer, but isn't needed in
+// the merged filesystem.
+type Waste struct {
+	Path   string        `json:"path"`
+	Kind   WasteKind     `json:"kind"`
+	Size   int64         `json:"size"`
+	Digest digest.Digest `json:"digest"`
+	// Layer is the index of the layer shipping the file.
+	Layer int `json:"layer"`
+	// By is the index of the layer that overwrote or deleted the file, or of
+	// the layer with the original content for duplicates.
+	By int `json:"by"`
+}
+
+// LayerStats are the totals of a layer.
+type LayerStats struct {
+	Digest digest.Digest `json:"digest"`
+	// Size is the size of the layer blob.
+	Size int64 `json:"size"`
+	// ContentSize is the size of the regular files of the layer.
+	ContentSize int64 `json:"contentSize"`
+	Files       int   `json:"files"`
+	// WastedSize is the size of the files of the layer that are wasted.
+	WastedSize int64 `json:"wastedSize"`
+}
+
+// Analysis is the wasted space of an image.
+type Analysis struct {
+	Layers []LayerStats `json:"layers"`
+	// Waste is sorted by decreasing size.
+	Waste       []Waste `json:"waste"`
+	ContentSize int64   `json:"contentSize"`
+	WastedSize  int64   `json:"wastedSize"`
+	// Efficiency is the fraction of the content that isn't wasted, between 0
+	// and 1.
+	Efficiency float64 `json:"efficiency"`
+}
+
+// Analyze merges layers and reports the content of the regular files that is
+// overwritten, deleted or duplicated by the layers.
+func Analyze(ctx context.Context, provider content.Provider, layers []ocispec.Descriptor) (*Analysis, error) {
+	analysis := &Analysis{
+		Layers:     make([]LayerStats, len(layers)),
+		Waste:      []Waste{},
+		Efficiency: 1,
+	}
+
+	fsys := NewFS()
+	for i, desc := range layers {
+		entries, err := LayerEntries(ctx, provider, desc, i)
+		if err != nil {
+			return nil, err
+		}
+
+		stats := &analysis.Layers[i]
+		stats.Digest = desc.Digest
+		stats.Size = desc.Size
+
+		for _, entry := range entries {
+			if isRegular(entry) && !entry.IsWhiteout() {
+				stats.Files++
+				stats.ContentSize += entry.Size
+			}
+
+			kind := WasteOverwritten
+			if entry.IsWhiteout() {
+				kind = WasteDeleted
+			}
+
+			// Apply entries one by one to know what replaced or deleted the
+			// lower files.
+			for _, removed := range fsys.Apply([]Entry{entry}) {
+				analysis.addWaste(removed, kind, i)
+			}
+		}
+
+		analysis.ContentSize += stats.ContentSize
+	}
+
+	// The lowest layer with some content is its original.
+	original := make(map[digest.Digest]Entry)
+	for _, p := range fsys.Paths() {
+		entry := fsys.Entries[p]
+		if !isRegular(entry) || entry.Size == 0 {
+			continue
+		}
+
+		if orig, ok := original[entry.Digest]; !ok || entry.Layer < orig.Layer {
+			original[entry.Digest] = entry
+		}
+	}
+	for _, p := range fsys.Paths() {
+		entry := fsys.Entries[p]
+		if !isRegular(entry) || entry.Size == 0 {
+			continue
+		}
+
+		if orig := original[entry.Digest]; orig.Layer < entry.Layer {
+			analysis.addWaste(entry, WasteDuplicate, orig.Layer)
+		}
+	}
+
+	sort.SliceStable(analysis.Waste, func(i, j int) bool {
+		if analysis.Waste[i].Size != analysis.Waste[j].Size {
+			return analysis.Waste[i].Size > analysis.Waste[j].Size
+		}
+		return analysis.Waste[i].Path < analysis.Waste[j].Path
+	})
+
+	if analysis.ContentSize > 0 {
+		analysis.Efficiency = 1 - float64(analysis.WastedSize)/float64(analysis.ContentSize)
+	}
+
+	return analysis, nil
+}
+
+func (a *Analysis) addWaste(entry Entry, kind WasteKind, by int) {
+	if !isRegular(entry) || entry.Size == 0 {
+		return
+	}
+
+	a.Waste = append(a.Waste, Waste{
+		Path:   entry.Path,
+		Kind:   kind,
+		Size:   entry.Size,
+		Digest: entry.Digest,
+		Layer:  entry.Layer,
+		By:     by,
+	})
+	a.Layers[entry.Layer].WastedSize += entry.Size
+	a.WastedSize += entry.Size
+}
+
+func isRegular(entry Entry) bool {
+	return entry.Type == tar.TypeReg || entry.Type == tar.TypeRegA
+}
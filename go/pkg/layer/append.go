@@ -17,7 +17,7 @@ import (
 // FIXME: this was removed from ocispec in https://github.com/opencontainers/image-spec/pull/1078 as
 // being a redundant duplicate of "org.opencontainers.image.created"; the code should be updated to
 // use that.
-const AnnotationArtifactDescription = "org.opencontainers.artifact.created"
+const AnnotationArtifactDescription = ociutil.AnnotationArtifactDescription
 
 func AppendLayers(
 	ctx context.Context,
@@ -90,42 +90,10 @@ func AppendLayers(
 
 	// Update image with base image reference
 	if baseRef != "" {
-		refTy, err := dreference.ParseNamed(baseRef)
+		err = annotateBaseLayers(manifest.Layers, baseRef, baseManifestDesc.Digest)
 		if err != nil {
 			return ocispec.Descriptor{}, ocispec.Descriptor{}, err
 		}
-
-		ref := refTy.Name()
-
-		for idx, layer := range manifest.Layers {
-			if layer.Annotations == nil {
-				layer.Annotations = make(map[string]string)
-			}
-
-			// It's arguably incorrect to label the layers with the base image name/digest, since
-			// that annotation is intended to indicate the image which an image builds on, not the
-			// image origin of the layer it comes from; see
-			// https://github.com/opencontainers/image-spec/issues/821.  This code only annotates
-			// layers with no annotations, but unannotated layers are the default with
-			// docker-created images, and we cannot know whether _all_ the layers in those images
-			// _really_ came FROM the specified base layer.  Only if all images are built with
-			// rules_oci can we guarantee this.
-			//
-			// SIDE EFFECT: The presence of this label is used in ociutil.CopyContent to determine
-			// whether to copy the layer into the target repo via an OCI mount request i.e. we use
-			// the label to tag layers that should already exist in the target registry.
-			if _, ok := layer.Annotations[ocispec.AnnotationBaseImageName]; !ok {
-				layer.Annotations[ocispec.AnnotationBaseImageName] = ref
-			}
-
-			if _, ok := layer.Annotations[ocispec.AnnotationBaseImageDigest]; !ok {
-				layer.Annotations[ocispec.AnnotationBaseImageDigest] = baseManifestDesc.Digest.String()
-			}
-
-			layer.MediaType = converter.ConvertDockerMediaTypeToOCI(layer.MediaType)
-
-			manifest.Layers[idx] = layer
-		}
 	}
 
 	// we're OCI now
@@ -173,3 +141,47 @@ func AppendLayers(
 
 	return newManifest, newConfig, nil
 }
+
+// annotateBaseLayers annotates layers, which are from the base image baseRef,
+// with the name and digest of the base image and converts them to OCI media
+// types.
+func annotateBaseLayers(layers []ocispec.Descriptor, baseRef string, baseDigest digest.Digest) error {
+	refTy, err := dreference.ParseNamed(baseRef)
+	if err != nil {
+		return err
+	}
+
+	ref := refTy.Name()
+
+	for idx, layer := range layers {
+		if layer.Annotations == nil {
+			layer.Annotations = make(map[string]string)
+		}
+
+		// It's arguably incorrect to label the layers with the base image name/digest, since
+		// that annotation is intended to indicate the image which an image builds on, not the
+		// image origin of the layer it comes from; see
+		// https://github.com/opencontainers/image-spec/issues/821.  This code only annotates
+		// layers with no annotations, but unannotated layers are the default with
+		// docker-created images, and we cannot know whether _all_ the layers in those images
+		// _really_ came FROM the specified base layer.  Only if all images are built with
+		// rules_oci can we guarantee this.
+		//
+		// SIDE EFFECT: The presence of this label is used in ociutil.CopyContent to determine
+		// whether to copy the layer into the target repo via an OCI mount request i.e. we use
+		// the label to tag layers that should already exist in the target registry.
+		if _, ok := layer.Annotations[ocispec.AnnotationBaseImageName]; !ok {
+			layer.Annotations[ocispec.AnnotationBaseImageName] = ref
+		}
+
+		if _, ok := layer.Annotations[ocispec.AnnotationBaseImageDigest]; !ok {
+			layer.Annotations[ocispec.AnnotationBaseImageDigest] = baseDigest.String()
+		}
+
+		layer.MediaType = converter.ConvertDockerMediaTypeToOCI(layer.MediaType)
+
+		layers[idx] = layer
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package layer
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxSymlinks is the number of symlinks followed when extracting a file, like
+// the Linux limit.
+const maxSymlinks = 40
+
+// errFileFound stops the walk of a layer once the file has been extracted.
+var errFileFound = errors.New("file found")
+
+// ExtractFile writes the content of the file at p in the filesystem of layers
+// to w and returns its entry. Only the topmost layer containing the file is
+// read past the file, lower layers aren't read once a whiteout or opaque
+// directory hides the file.
+//
+// Hard links and symlinks are followed, but not symlinks of the parent
+// directories of p. fs.ErrNotExist is returned if there is no such file.
+func ExtractFile(ctx context.Context, provider content.Provider, layers []ocispec.Descriptor, p string, w io.Writer) (Entry, error) {
+	return extractFile(ctx, provider, layers, CleanPath(p), w, 0)
+}
+
+func extractFile(ctx context.Context, provider content.Provider, layers []ocispec.Descriptor, p string, w io.Writer, symlinks int) (Entry, error) {
+	for i := len(layers) - 1; i >= 0; i-- {
+		var found *Entry
+		hidden := false
+
+		err := WalkLayer(ctx, provider, layers[i], func(hdr *tar.Header, r io.Reader) error {
+			name := CleanPath(hdr.Name)
+
+			if name == p {
+				// Only the content of regular files is read, and so written.
+				entry, err := NewEntry(hdr, io.TeeReader(r, w), i)
+				if err != nil {
+					return err
+				}
+				found = &entry
+
+				return errFileFound
+			}
+
+			if hidesPath(hdr, name, p) {
+				hidden = true
+			}
+
+			return nil
+		})
+		if err != nil && !errors.Is(err, errFileFound) {
+			return Entry{}, err
+		}
+
+		if found != nil {
+			return followEntry(ctx, provider, layers[:i+1], layers, *found, w, symlinks)
+		}
+
+		if hidden {
+			break
+		}
+	}
+
+	return Entry{}, fmt.Errorf("%v: %w", p, fs.ErrNotExist)
+}
+
+// followEntry returns entry if it's a regular file, or extracts the target of
+// links.
+func followEntry(ctx context.Context, provider content.Provider, layerLayers, allLayers []ocispec.Descriptor, entry Entry, w io.Writer, symlinks int) (Entry, error) {
+	switch entry.Type {
+	case tar.TypeReg, tar.TypeRegA:
+		return entry, nil
+	case tar.TypeLink:
+		// The target of a hard link is in the same layer or a lower one.
+		return extractFile(ctx, provider, layerLayers, entry.Linkname, w, symlinks)
+	case tar.TypeSymlink:
+		if symlinks >= maxSymlinks {
+			return Entry{}, fmt.Errorf("%v: too many levels of symbolic links", entry.Path)
+		}
+
+		target := entry.Linkname
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir("/"+entry.Path), target)
+		}
+
+		return extractFile(ctx, provider, allLayers, CleanPath(target), w, symlinks+1)
+	case tar.TypeDir:
+		return Entry{}, fmt.Errorf("%v is a directory", entry.Path)
+	default:
+		return Entry{}, fmt.Errorf("%v isn't a regular file but a %v", entry.Path, entry.Type)
+	}
+}
+
+// hidesPath reports whether the entry name of a layer hides p in the lower
+// layers: a whiteout of p or of one of its parents, an opaque marker of one of
+// its parents, or a parent replaced by something else than a directory.
+func hidesPath(hdr *tar.Header, name, p string) bool {
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+
+	switch {
+	case base == WhiteoutOpaqueDir:
+		return isParent(dir, p)
+	case strings.HasPrefix(base, WhiteoutPrefix):
+		target := path.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix))
+		return target == p || isParent(target, p)
+	default:
+		return hdr.Typeflag != tar.TypeDir && isParent(name, p)
+	}
+}
+
+// isParent reports whether dir is a parent directory of p, the root being the
+// parent of every path.
+func isParent(dir, p string) bool {
+	return dir == "" || strings.HasPrefix(p, dir+"/")
+}
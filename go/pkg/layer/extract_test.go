@@ -0,0 +1,83 @@
+package layer
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/containerd/containerd/content/local"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestExtractFile(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layers := []ocispec.Descriptor{
+		testLayer(t, store,
+			tar.Header{Name: "./etc/", Typeflag: tar.TypeDir, Mode: 0755},
+			tar.Header{Name: "./etc/passwd", Typeflag: tar.TypeReg, Mode: 0644, Linkname: "old"},
+			tar.Header{Name: "./etc/group", Typeflag: tar.TypeReg, Mode: 0644},
+			tar.Header{Name: "./opt/app/bin", Typeflag: tar.TypeReg, Mode: 0755},
+			tar.Header{Name: "./var/lib/db", Typeflag: tar.TypeReg, Mode: 0600},
+		),
+		testLayer(t, store,
+			tar.Header{Name: "./etc/passwd", Typeflag: tar.TypeReg, Mode: 0640, Uid: 1, Linkname: "new"},
+			tar.Header{Name: "./etc/.wh.group", Typeflag: tar.TypeReg},
+			tar.Header{Name: "./opt/app/.wh..wh..opq", Typeflag: tar.TypeReg},
+			tar.Header{Name: "./opt/app/lib", Typeflag: tar.TypeReg, Mode: 0644},
+			tar.Header{Name: "./var/lib", Typeflag: tar.TypeSymlink, Linkname: "/opt"},
+			tar.Header{Name: "./bin/app", Typeflag: tar.TypeLink, Linkname: "./opt/app/lib"},
+			tar.Header{Name: "./bin/sh", Typeflag: tar.TypeSymlink, Linkname: "../etc/passwd"},
+			tar.Header{Name: "./bin/loop", Typeflag: tar.TypeSymlink, Linkname: "loop"},
+		),
+	}
+
+	for _, tc := range []struct {
+		path    string
+		content string
+		entry   Entry
+		err     error
+	}{
+		{path: "/etc/passwd", content: "new", entry: Entry{Path: "etc/passwd", Mode: 0640, UID: 1, Layer: 1}},
+		{path: "etc/group", err: fs.ErrNotExist},
+		{path: "opt/app/bin", err: fs.ErrNotExist},
+		{path: "opt/app/lib", content: "./opt/app/lib", entry: Entry{Path: "opt/app/lib", Mode: 0644, Layer: 1}},
+		{path: "var/lib/db", err: fs.ErrNotExist},
+		{path: "bin/app", content: "./opt/app/lib", entry: Entry{Path: "opt/app/lib", Mode: 0644, Layer: 1}},
+		{path: "bin/sh", content: "new", entry: Entry{Path: "etc/passwd", Mode: 0640, UID: 1, Layer: 1}},
+		{path: "etc", err: errAny},
+		{path: "bin/loop", err: errAny},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			var buf bytes.Buffer
+			entry, err := ExtractFile(ctx, store, layers, tc.path, &buf)
+			if tc.err != nil {
+				if err == nil || (tc.err != errAny && !errors.Is(err, tc.err)) {
+					t.Fatalf("expected error %v, got %v", tc.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if buf.String() != tc.content {
+				t.Errorf("expected content %q, got %q", tc.content, buf.String())
+			}
+
+			if entry.Path != tc.entry.Path || entry.Mode != tc.entry.Mode || entry.UID != tc.entry.UID || entry.Layer != tc.entry.Layer {
+				t.Errorf("expected entry %+v, got %+v", tc.entry, entry)
+			}
+		})
+	}
+}
+
+// errAny expects any error.
+var errAny = errors.New("any error")
@@ -0,0 +1,360 @@
+package layer
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// Flatten writes the merged filesystem of layers to w as a single tar. The
+// tar is normalized: entries are sorted by path, without leading "./", user
+// and group names, or duplicates.
+func Flatten(ctx context.Context, provider content.Provider, layers []ocispec.Descriptor, w io.Writer) error {
+	fsys, err := MergeLayers(ctx, provider, layers)
+	if err != nil {
+		return err
+	}
+
+	fl, err := newFlattener(ctx, provider, layers, fsys)
+	if err != nil {
+		return err
+	}
+	defer fl.Close()
+
+	return fl.writeTar(w, nil)
+}
+
+// FlattenToDir extracts the merged filesystem of layers into dir, which must
+// be empty or not exist. Ownership is only applied when running as root and
+// device files are skipped.
+func FlattenToDir(ctx context.Context, provider content.Provider, layers []ocispec.Descriptor, dir string) error {
+	fsys, err := MergeLayers(ctx, provider, layers)
+	if err != nil {
+		return err
+	}
+
+	fl, err := newFlattener(ctx, provider, layers, fsys)
+	if err != nil {
+		return err
+	}
+	defer fl.Close()
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	existing, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("output directory %v isn't empty", dir)
+	}
+
+	var dirs []Entry
+	for _, p := range fl.paths() {
+		entry := fl.fs.Entries[p]
+		target := filepath.Join(dir, filepath.FromSlash(p))
+
+		// Parents aren't necessarily in the layers.
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+
+		switch entry.Type {
+		case tar.TypeDir:
+			// Permissions are applied once the content has been written, in
+			// case the directory isn't writable.
+			err = os.MkdirAll(target, 0755)
+			dirs = append(dirs, entry)
+		case tar.TypeReg, tar.TypeRegA:
+			err = fl.writeFile(target, entry)
+		case tar.TypeSymlink:
+			err = os.Symlink(entry.Linkname, target)
+		case tar.TypeLink:
+			err = os.Link(filepath.Join(dir, filepath.FromSlash(entry.Linkname)), target)
+		default:
+			log.WithField("path", p).Warnf("skipping %v", entry.Type)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %v: %w", p, err)
+		}
+
+		if os.Geteuid() == 0 {
+			err = os.Lchown(target, entry.UID, entry.GID)
+			if err != nil {
+				return fmt.Errorf("failed to extract %v: %w", p, err)
+			}
+		}
+
+		if entry.Type != tar.TypeSymlink && entry.Type != tar.TypeDir {
+			err = os.Chtimes(target, entry.ModTime, entry.ModTime)
+			if err != nil {
+				return fmt.Errorf("failed to extract %v: %w", p, err)
+			}
+		}
+	}
+
+	// Children first, so that their parents are still writable.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target := filepath.Join(dir, filepath.FromSlash(dirs[i].Path))
+
+		err = os.Chmod(target, tarMode(dirs[i].Mode))
+		if err != nil {
+			return err
+		}
+
+		err = os.Chtimes(target, dirs[i].ModTime, dirs[i].ModTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flattener holds the merged filesystem of an image and the content of its
+// regular files, spooled by digest.
+type flattener struct {
+	fs       *FS
+	spoolDir string
+}
+
+// newFlattener spools the content of the regular files of fsys, which is the
+// result of merging layers.
+func newFlattener(ctx context.Context, provider content.Provider, layers []ocispec.Descriptor, fsys *FS) (*flattener, error) {
+	spoolDir, err := os.MkdirTemp("", "ocitool-flatten-*")
+	if err != nil {
+		return nil, err
+	}
+
+	fl := &flattener{
+		fs:       fsys,
+		spoolDir: spoolDir,
+	}
+
+	for i, desc := range layers {
+		err = WalkLayer(ctx, provider, desc, func(hdr *tar.Header, r io.Reader) error {
+			entry, ok := fsys.Entries[CleanPath(hdr.Name)]
+			if !ok || entry.Layer != i || (hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA) {
+				return nil
+			}
+
+			return fl.spool(entry.Digest, r)
+		})
+		if err != nil {
+			fl.Close()
+			return nil, err
+		}
+	}
+
+	return fl, nil
+}
+
+// writeTar writes the filesystem as a normalized tar: entries are sorted by
+// path, without leading "./", user and group names, or duplicates. whiteouts
+// are written empty, right after their parent directory.
+func (fl *flattener) writeTar(w io.Writer, whiteouts []Entry) error {
+	var entries, links []Entry
+	for _, p := range fl.paths() {
+		entry := fl.fs.Entries[p]
+		if entry.Type == tar.TypeLink {
+			links = append(links, entry)
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+	for _, entry := range whiteouts {
+		if fl.hasValidParents(entry.Path) {
+			entries = append(entries, entry)
+		}
+	}
+
+	// Whiteouts must come before the files of the same layer that replace
+	// what they delete.
+	sortKey := func(entry Entry) string {
+		if !entry.IsWhiteout() {
+			return entry.Path
+		}
+		dir, base := path.Split(entry.Path)
+		return dir + "\x00" + base
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return sortKey(entries[i]) < sortKey(entries[j])
+	})
+
+	tw := tar.NewWriter(w)
+	for _, entry := range append(entries, links...) {
+		hdr := &tar.Header{
+			Name:     entry.Path,
+			Typeflag: byte(entry.Type),
+			Mode:     entry.Mode,
+			Uid:      entry.UID,
+			Gid:      entry.GID,
+			ModTime:  entry.ModTime,
+			Linkname: entry.Linkname,
+			Devmajor: entry.Devmajor,
+			Devminor: entry.Devminor,
+		}
+		if entry.Type == tar.TypeDir {
+			hdr.Name += "/"
+		}
+		if entry.Type == tar.TypeRegA {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Typeflag == tar.TypeReg && !entry.IsWhiteout() {
+			hdr.Size = entry.Size
+		}
+
+		err := tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if hdr.Size > 0 {
+			err = fl.copyContent(tw, entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+// spool writes content to the spool directory if it has the expected digest,
+// earlier versions of a file in the same layer are discarded.
+func (fl *flattener) spool(expected digest.Digest, r io.Reader) error {
+	target := filepath.Join(fl.spoolDir, expected.Encoded())
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+
+	f, err := os.CreateTemp(fl.spoolDir, "spool-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	digester := expected.Algorithm().Digester()
+	_, err = io.Copy(io.MultiWriter(f, digester.Hash()), r)
+	if err != nil {
+		return err
+	}
+
+	if digester.Digest() != expected {
+		return nil
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), target)
+}
+
+// paths returns the sorted paths of the filesystem, without the entries below
+// a parent that isn't a directory. Hard links come last so that their targets
+// exist, and are dropped if their target isn't a regular file.
+func (fl *flattener) paths() []string {
+	var paths, links []string
+	for _, p := range fl.fs.Paths() {
+		if !fl.hasValidParents(p) {
+			log.WithField("path", p).Debug("skipping entry below a non-directory")
+			continue
+		}
+
+		entry := fl.fs.Entries[p]
+		if entry.Type != tar.TypeLink {
+			paths = append(paths, p)
+			continue
+		}
+
+		target, ok := fl.fs.Entries[entry.Linkname]
+		if !ok || (target.Type != tar.TypeReg && target.Type != tar.TypeRegA) || !fl.hasValidParents(entry.Linkname) {
+			log.WithField("path", p).Warnf("skipping hard link to missing file %v", entry.Linkname)
+			continue
+		}
+		links = append(links, p)
+	}
+
+	return append(paths, links...)
+}
+
+func (fl *flattener) hasValidParents(p string) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if entry, ok := fl.fs.Entries[dir]; ok && entry.Type != tar.TypeDir {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (fl *flattener) copyContent(w io.Writer, entry Entry) error {
+	f, err := os.Open(filepath.Join(fl.spoolDir, entry.Digest.Encoded()))
+	if err != nil {
+		return fmt.Errorf("missing content for %v: %w", entry.Path, err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (fl *flattener) writeFile(target string, entry Entry) error {
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, tarMode(entry.Mode))
+	if err != nil {
+		return err
+	}
+
+	err = fl.copyContent(f, entry)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	// The mode given to OpenFile is subject to the umask.
+	return os.Chmod(target, tarMode(entry.Mode))
+}
+
+func (fl *flattener) Close() error {
+	return os.RemoveAll(fl.spoolDir)
+}
+
+// tarMode converts the permission bits of a tar header to a file mode.
+func tarMode(mode int64) fs.FileMode {
+	m := fs.FileMode(mode & 0o777)
+	if mode&0o4000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		m |= fs.ModeSticky
+	}
+
+	return m
+}
@@ -0,0 +1,270 @@
+package layer
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// WhiteoutPrefix marks a file that deletes the file of the same name,
+	// without the prefix, in the lower layers.
+	WhiteoutPrefix = ".wh."
+	// WhiteoutOpaqueDir marks a directory whose content in the lower layers is
+	// hidden.
+	WhiteoutOpaqueDir = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+)
+
+// EntryType is the tar type flag of an entry.
+type EntryType byte
+
+func (t EntryType) String() string {
+	switch t {
+	case tar.TypeReg, tar.TypeRegA:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeFifo:
+		return "fifo"
+	default:
+		return fmt.Sprintf("type-%c", byte(t))
+	}
+}
+
+func (t EntryType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Entry is a file in a layer.
+type Entry struct {
+	// Path is the cleaned path of the file, relative to the root.
+	Path     string    `json:"path"`
+	Type     EntryType `json:"type"`
+	Mode     int64     `json:"mode"`
+	UID      int       `json:"uid"`
+	GID      int       `json:"gid"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Linkname string    `json:"link,omitempty"`
+	Devmajor int64     `json:"devmajor,omitempty"`
+	Devminor int64     `json:"devminor,omitempty"`
+	// Digest is the digest of the content of regular files.
+	Digest digest.Digest `json:"digest,omitempty"`
+	// Layer is the index of the layer the entry is from.
+	Layer int `json:"layer"`
+}
+
+// IsWhiteout reports whether the entry is a whiteout or an opaque directory
+// marker.
+func (e Entry) IsWhiteout() bool {
+	return strings.HasPrefix(path.Base(e.Path), WhiteoutPrefix)
+}
+
+// CleanPath normalizes a path of a layer, removing leading "./" and "/".
+func CleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// WalkLayer calls fn with each header of the decompressed layer, the reader
+// returns the content of the entry.
+func WalkLayer(ctx context.Context, provider content.Provider, desc ocispec.Descriptor, fn func(hdr *tar.Header, r io.Reader) error) error {
+	r, err := ociutil.DecompressedLayerReader(ctx, provider, desc)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	err = WalkTar(r, fn)
+	if err != nil {
+		return fmt.Errorf("failed to read layer %v: %w", desc.Digest, err)
+	}
+
+	return nil
+}
+
+// WalkTar calls fn with each header of the uncompressed tar stream r.
+func WalkTar(r io.Reader, fn func(hdr *tar.Header, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = fn(hdr, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// NewEntry creates an entry from a tar header, hashing the content of regular
+// files from r.
+func NewEntry(hdr *tar.Header, r io.Reader, layer int) (Entry, error) {
+	entry := Entry{
+		Path:    CleanPath(hdr.Name),
+		Type:    EntryType(hdr.Typeflag),
+		Mode:    hdr.Mode & 0o7777,
+		UID:     hdr.Uid,
+		GID:     hdr.Gid,
+		Size:    hdr.Size,
+		ModTime: hdr.ModTime,
+		Layer:   layer,
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		dgst, err := digest.SHA256.FromReader(r)
+		if err != nil {
+			return Entry{}, err
+		}
+		entry.Digest = dgst
+	case tar.TypeLink:
+		entry.Linkname = CleanPath(hdr.Linkname)
+	case tar.TypeSymlink:
+		entry.Linkname = hdr.Linkname
+	case tar.TypeChar, tar.TypeBlock:
+		entry.Devmajor = hdr.Devmajor
+		entry.Devminor = hdr.Devminor
+	}
+
+	return entry, nil
+}
+
+// LayerEntries returns the entries of a layer in archive order, including
+// whiteouts. layer is recorded as the index of the layer in the entries.
+func LayerEntries(ctx context.Context, provider content.Provider, desc ocispec.Descriptor, layer int) ([]Entry, error) {
+	var entries []Entry
+	err := WalkLayer(ctx, provider, desc, func(hdr *tar.Header, r io.Reader) error {
+		entry, err := NewEntry(hdr, r, layer)
+		if err != nil {
+			return err
+		}
+
+		if entry.Path != "" {
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// FS is the filesystem resulting from applying layers on top of each other.
+type FS struct {
+	// Entries maps paths to the entry from the topmost layer.
+	Entries map[string]Entry
+}
+
+// NewFS creates an empty filesystem.
+func NewFS() *FS {
+	return &FS{
+		Entries: make(map[string]Entry),
+	}
+}
+
+// MergeLayers applies layers in order and returns the resulting filesystem.
+func MergeLayers(ctx context.Context, provider content.Provider, layers []ocispec.Descriptor) (*FS, error) {
+	fsys := NewFS()
+	for i, desc := range layers {
+		entries, err := LayerEntries(ctx, provider, desc, i)
+		if err != nil {
+			return nil, err
+		}
+
+		fsys.Apply(entries)
+	}
+
+	return fsys, nil
+}
+
+// Apply applies the entries of a layer, handling whiteouts and opaque
+// directories. It returns the entries of lower layers that were replaced or
+// deleted.
+func (fsys *FS) Apply(entries []Entry) []Entry {
+	var removed []Entry
+	for _, entry := range entries {
+		dir, base := path.Split(entry.Path)
+		dir = strings.TrimSuffix(dir, "/")
+
+		switch {
+		case base == WhiteoutOpaqueDir:
+			removed = append(removed, fsys.removeChildren(dir, entry.Layer)...)
+		case strings.HasPrefix(base, WhiteoutPrefix):
+			target := path.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix))
+			if old, ok := fsys.Entries[target]; ok && old.Layer < entry.Layer {
+				delete(fsys.Entries, target)
+				removed = append(removed, old)
+			}
+			removed = append(removed, fsys.removeChildren(target, entry.Layer)...)
+		default:
+			if old, ok := fsys.Entries[entry.Path]; ok {
+				removed = append(removed, old)
+
+				if old.Type == tar.TypeDir && entry.Type != tar.TypeDir {
+					removed = append(removed, fsys.removeChildren(entry.Path, entry.Layer+1)...)
+				}
+			}
+
+			fsys.Entries[entry.Path] = entry
+		}
+	}
+
+	return removed
+}
+
+// removeChildren removes the entries below dir that are from layers lower
+// than layer.
+func (fsys *FS) removeChildren(dir string, layer int) []Entry {
+	prefix := dir + "/"
+	if dir == "" {
+		prefix = ""
+	}
+
+	var removed []Entry
+	for p, entry := range fsys.Entries {
+		if strings.HasPrefix(p, prefix) && entry.Layer < layer {
+			delete(fsys.Entries, p)
+			removed = append(removed, entry)
+		}
+	}
+
+	return removed
+}
+
+// Paths returns the sorted paths of the filesystem.
+func (fsys *FS) Paths() []string {
+	paths := make([]string, 0, len(fsys.Entries))
+	for p := range fsys.Entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
@@ -0,0 +1,312 @@
+package layer
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testLayer writes an uncompressed layer with the given headers to the store,
+// regular files contain their Linkname if set, or their name.
+func testLayer(t *testing.T, store content.Store, hdrs ...tar.Header) ocispec.Descriptor {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range hdrs {
+		hdr := hdr
+		var data []byte
+		if hdr.Typeflag == tar.TypeReg {
+			data = []byte(hdr.Name)
+			if hdr.Linkname != "" {
+				data = []byte(hdr.Linkname)
+				hdr.Linkname = ""
+			}
+			hdr.Size = int64(len(data))
+		}
+
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(buf.Bytes()),
+		Size:      int64(buf.Len()),
+	}
+
+	err := content.WriteBlob(context.Background(), store, desc.Digest.String(), &buf, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return desc
+}
+
+func TestMergeLayers(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layers := []ocispec.Descriptor{
+		testLayer(t, store,
+			tar.Header{Name: "./etc/", Typeflag: tar.TypeDir, Mode: 0755},
+			tar.Header{Name: "./etc/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+			tar.Header{Name: "./etc/shadow", Typeflag: tar.TypeReg, Mode: 0600},
+			tar.Header{Name: "./opt/app/bin", Typeflag: tar.TypeReg, Mode: 0755},
+			tar.Header{Name: "./opt/app/lib", Typeflag: tar.TypeReg, Mode: 0644},
+		),
+		testLayer(t, store,
+			tar.Header{Name: "./etc/.wh.shadow", Typeflag: tar.TypeReg},
+			tar.Header{Name: "./opt/app/new", Typeflag: tar.TypeReg, Mode: 0644},
+			tar.Header{Name: "./opt/app/.wh..wh..opq", Typeflag: tar.TypeReg},
+			tar.Header{Name: "/etc/passwd", Typeflag: tar.TypeReg, Mode: 0640, Uid: 1},
+		),
+	}
+
+	fsys, err := MergeLayers(ctx, store, layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"etc", "etc/passwd", "opt/app/new"}
+	if paths := fsys.Paths(); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected paths %v, got %v", expected, paths)
+	}
+
+	passwd := fsys.Entries["etc/passwd"]
+	if passwd.Layer != 1 || passwd.Mode != 0640 || passwd.UID != 1 {
+		t.Fatalf("expected etc/passwd from the top layer, got %+v", passwd)
+	}
+
+	base, err := MergeLayers(ctx, store, layers[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []string
+	for _, change := range DiffFS(base, fsys) {
+		changes = append(changes, string(change.Kind)+" "+change.Path)
+	}
+
+	expected = []string{
+		"modified etc/passwd",
+		"removed etc/shadow",
+		"removed opt/app/bin",
+		"removed opt/app/lib",
+		"added opt/app/new",
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected changes %v, got %v", expected, changes)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layers := []ocispec.Descriptor{
+		testLayer(t, store,
+			tar.Header{Name: "./bin/", Typeflag: tar.TypeDir, Mode: 0755},
+			tar.Header{Name: "./bin/sh", Typeflag: tar.TypeReg, Mode: 0755},
+			tar.Header{Name: "./bin/old", Typeflag: tar.TypeReg, Mode: 0755},
+		),
+		testLayer(t, store,
+			tar.Header{Name: "./bin/.wh.old", Typeflag: tar.TypeReg},
+			tar.Header{Name: "./bin/bash", Typeflag: tar.TypeLink, Linkname: "./bin/sh"},
+			tar.Header{Name: "./a/link", Typeflag: tar.TypeSymlink, Linkname: "../bin/sh"},
+		),
+	}
+
+	var buf bytes.Buffer
+	err = Flatten(ctx, store, layers, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+
+	// Sorted, with hard links last.
+	expected := []string{"a/link", "bin/", "bin/sh", "bin/bash"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected entries %v, got %v", expected, names)
+	}
+
+	dir := t.TempDir()
+	err = FlattenToDir(ctx, store, layers, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "a", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "./bin/sh" {
+		t.Fatalf("expected content of bin/sh through the symlink, got %q", data)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "bin", "old")); !os.IsNotExist(err) {
+		t.Fatalf("expected bin/old to be deleted by its whiteout, got %v", err)
+	}
+}
+
+func TestSquashLayers(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layers := []ocispec.Descriptor{
+		testLayer(t, store,
+			tar.Header{Name: "./a/", Typeflag: tar.TypeDir, Mode: 0755},
+			tar.Header{Name: "./a/x", Typeflag: tar.TypeReg, Mode: 0644},
+			tar.Header{Name: "./b", Typeflag: tar.TypeReg, Mode: 0644},
+		),
+		testLayer(t, store,
+			tar.Header{Name: "./a/.wh.x", Typeflag: tar.TypeReg},
+			tar.Header{Name: "./.wh.c", Typeflag: tar.TypeReg},
+			tar.Header{Name: "./b", Typeflag: tar.TypeReg, Mode: 0600},
+			tar.Header{Name: "./d/", Typeflag: tar.TypeDir, Mode: 0755},
+			tar.Header{Name: "./d/.wh..wh..opq", Typeflag: tar.TypeReg},
+		),
+	}
+
+	var buf bytes.Buffer
+	err = SquashLayers(ctx, store, layers, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+
+	// Whiteouts are kept for the layers below, right after their parent.
+	expected := []string{".wh.c", "a/", "a/.wh.x", "b", "d/", "d/.wh..wh..opq"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected entries %v, got %v", expected, names)
+	}
+}
+
+func TestSquashHistory(t *testing.T) {
+	history := []ocispec.History{
+		{CreatedBy: "base"},
+		{CreatedBy: "app 1"},
+		{CreatedBy: "env", EmptyLayer: true},
+		{CreatedBy: "app 2"},
+		{CreatedBy: "cmd", EmptyLayer: true},
+	}
+
+	squashed, err := squashHistory(history, 3, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var createdBy []string
+	for _, h := range squashed {
+		createdBy = append(createdBy, h.CreatedBy)
+	}
+
+	expected := []string{"base", "env", "squash of layers 1 to 2", "cmd"}
+	if !reflect.DeepEqual(createdBy, expected) {
+		t.Fatalf("expected history %v, got %v", expected, createdBy)
+	}
+
+	_, err = squashHistory(history, 4, 1, 3)
+	if err == nil {
+		t.Fatal("expected an error for history that doesn't match the layers")
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layers := []ocispec.Descriptor{
+		testLayer(t, store,
+			tar.Header{Name: "./etc/", Typeflag: tar.TypeDir, Mode: 0755},
+			tar.Header{Name: "./etc/config", Typeflag: tar.TypeReg, Mode: 0644},
+			tar.Header{Name: "./tmp/cache", Typeflag: tar.TypeReg, Mode: 0644},
+			tar.Header{Name: "./lib/a", Typeflag: tar.TypeReg, Mode: 0644, Linkname: "shared"},
+		),
+		testLayer(t, store,
+			tar.Header{Name: "./etc/config", Typeflag: tar.TypeReg, Mode: 0600},
+			tar.Header{Name: "./tmp/.wh.cache", Typeflag: tar.TypeReg},
+			tar.Header{Name: "./lib/b", Typeflag: tar.TypeReg, Mode: 0644, Linkname: "shared"},
+		),
+	}
+
+	analysis, err := Analyze(ctx, store, layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var waste []string
+	for _, w := range analysis.Waste {
+		waste = append(waste, fmt.Sprintf("%s %s %d by %d", w.Kind, w.Path, w.Layer, w.By))
+	}
+
+	// Sorted by size, then path.
+	expected := []string{
+		"overwritten etc/config 0 by 1",
+		"deleted tmp/cache 0 by 1",
+		"duplicate lib/b 1 by 0",
+	}
+	if !reflect.DeepEqual(waste, expected) {
+		t.Fatalf("expected waste %v, got %v", expected, waste)
+	}
+
+	if analysis.Layers[1].WastedSize != int64(len("shared")) {
+		t.Fatalf("expected the duplicate to be wasted in layer 1, got %+v", analysis.Layers[1])
+	}
+
+	expectedEfficiency := 1 - float64(analysis.WastedSize)/float64(analysis.ContentSize)
+	if analysis.Efficiency != expectedEfficiency {
+		t.Fatalf("expected efficiency %v, got %v", expectedEfficiency, analysis.Efficiency)
+	}
+}
@@ -0,0 +1,114 @@
+package layer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ChangeKind is the kind of a FileChange.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified"
+)
+
+// FileChange is a difference of a single path between two filesystems.
+type FileChange struct {
+	Path   string     `json:"path"`
+	Kind   ChangeKind `json:"kind"`
+	Before *Entry     `json:"before,omitempty"`
+	After  *Entry     `json:"after,omitempty"`
+	// Fields lists what changed for modified files: type, mode, owner,
+	// content or link.
+	Fields []string `json:"fields,omitempty"`
+}
+
+func (c FileChange) String() string {
+	switch c.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("A %s (%s)", c.Path, describeEntry(*c.After))
+	case ChangeRemoved:
+		return fmt.Sprintf("D %s (%s)", c.Path, describeEntry(*c.Before))
+	}
+
+	var details []string
+	for _, field := range c.Fields {
+		switch field {
+		case "type":
+			details = append(details, fmt.Sprintf("type %v -> %v", c.Before.Type, c.After.Type))
+		case "mode":
+			details = append(details, fmt.Sprintf("mode %04o -> %04o", c.Before.Mode, c.After.Mode))
+		case "owner":
+			details = append(details, fmt.Sprintf("owner %d:%d -> %d:%d", c.Before.UID, c.Before.GID, c.After.UID, c.After.GID))
+		case "content":
+			details = append(details, fmt.Sprintf("content %v -> %v", c.Before.Digest, c.After.Digest))
+		case "link":
+			details = append(details, fmt.Sprintf("link %q -> %q", c.Before.Linkname, c.After.Linkname))
+		}
+	}
+
+	return fmt.Sprintf("M %s: %s", c.Path, strings.Join(details, ", "))
+}
+
+func describeEntry(e Entry) string {
+	desc := fmt.Sprintf("%v %04o %d:%d", e.Type, e.Mode, e.UID, e.GID)
+	if e.Digest != "" {
+		desc += " " + e.Digest.String()
+	}
+	if e.Linkname != "" {
+		desc += " -> " + e.Linkname
+	}
+
+	return desc
+}
+
+// DiffFS returns the changes from a to b, sorted by path. Modification times
+// are ignored.
+func DiffFS(a, b *FS) []FileChange {
+	var changes []FileChange
+	for p, before := range a.Entries {
+		before := before
+
+		after, ok := b.Entries[p]
+		if !ok {
+			changes = append(changes, FileChange{Path: p, Kind: ChangeRemoved, Before: &before})
+			continue
+		}
+
+		var fields []string
+		if before.Type != after.Type {
+			fields = append(fields, "type")
+		}
+		if before.Mode != after.Mode {
+			fields = append(fields, "mode")
+		}
+		if before.UID != after.UID || before.GID != after.GID {
+			fields = append(fields, "owner")
+		}
+		if before.Digest != after.Digest {
+			fields = append(fields, "content")
+		}
+		if before.Linkname != after.Linkname {
+			fields = append(fields, "link")
+		}
+
+		if len(fields) > 0 {
+			changes = append(changes, FileChange{Path: p, Kind: ChangeModified, Before: &before, After: &after, Fields: fields})
+		}
+	}
+
+	for p, after := range b.Entries {
+		after := after
+
+		if _, ok := a.Entries[p]; !ok {
+			changes = append(changes, FileChange{Path: p, Kind: ChangeAdded, After: &after})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	return changes
+}
@@ -0,0 +1,230 @@
+package layer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images/converter"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ErrBaseMismatch is returned when an image isn't built on the expected base.
+var ErrBaseMismatch = errors.New("image doesn't match its base")
+
+// RebaseImage replaces the layers of the old base image at the bottom of the
+// image manifest with the layers of the new base. The diffIDs and history of
+// the base are swapped in the config, the rest of the config and the layers
+// above the base are kept.
+//
+// The new manifest and config are written to store, like AppendLayers. The
+// result is an OCI manifest, and the layers of the new base are annotated with
+// its name if newBaseDesc has a ref name annotation.
+func RebaseImage(
+	ctx context.Context,
+	store content.Store,
+	manifestDesc ocispec.Descriptor,
+	oldBaseDesc ocispec.Descriptor,
+	newBaseDesc ocispec.Descriptor,
+) (ocispec.Descriptor, ocispec.Descriptor, error) {
+	manifest, imageConfig, err := loadImage(ctx, store, manifestDesc)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, err
+	}
+
+	oldBase, oldBaseConfig, err := loadImage(ctx, store, oldBaseDesc)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, fmt.Errorf("failed to load old base: %w", err)
+	}
+
+	newBase, newBaseConfig, err := loadImage(ctx, store, newBaseDesc)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, fmt.Errorf("failed to load new base: %w", err)
+	}
+
+	err = checkBase(manifest, imageConfig, oldBase, oldBaseConfig)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, err
+	}
+
+	if newBaseConfig.OS != imageConfig.OS || newBaseConfig.Architecture != imageConfig.Architecture {
+		return ocispec.Descriptor{}, ocispec.Descriptor{},
+			fmt.Errorf("new base is %v/%v, but the image is %v/%v", newBaseConfig.OS, newBaseConfig.Architecture, imageConfig.OS, imageConfig.Architecture)
+	}
+
+	history, err := rebaseHistory(imageConfig.History, len(manifest.Layers), oldBaseConfig.History, len(oldBase.Layers), newBaseConfig.History, len(newBase.Layers))
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, err
+	}
+
+	baseRef := newBaseDesc.Annotations[ocispec.AnnotationRefName]
+
+	baseLayers := append([]ocispec.Descriptor{}, newBase.Layers...)
+	if baseRef != "" {
+		err = annotateBaseLayers(baseLayers, baseRef, newBaseDesc.Digest)
+		if err != nil {
+			return ocispec.Descriptor{}, ocispec.Descriptor{}, err
+		}
+	}
+
+	appLayers := manifest.Layers[len(oldBase.Layers):]
+	manifest.Layers = append(baseLayers, appLayers...)
+	for idx, layer := range manifest.Layers {
+		manifest.Layers[idx].MediaType = converter.ConvertDockerMediaTypeToOCI(layer.MediaType)
+	}
+
+	appDiffIDs := imageConfig.RootFS.DiffIDs[len(oldBase.Layers):]
+	imageConfig.RootFS.DiffIDs = append(append([]digest.Digest{}, newBaseConfig.RootFS.DiffIDs...), appDiffIDs...)
+	imageConfig.History = history
+
+	// Only update the base annotations of the manifest if it has them.
+	if _, ok := manifest.Annotations[ocispec.AnnotationBaseImageDigest]; ok {
+		manifest.Annotations[ocispec.AnnotationBaseImageDigest] = newBaseDesc.Digest.String()
+	}
+	if _, ok := manifest.Annotations[ocispec.AnnotationBaseImageName]; ok && baseRef != "" {
+		manifest.Annotations[ocispec.AnnotationBaseImageName] = baseRef
+	}
+
+	// we're OCI now
+	manifest.MediaType = ocispec.MediaTypeImageManifest
+
+	newConfig, err := ociutil.IngestorJSONEncode(
+		/* context     */ ctx,
+		/* ingestor    */ store,
+		/* annotations */ nil,
+		/* media type  */ ocispec.MediaTypeImageConfig,
+		/* interface   */ imageConfig,
+		/* platform    */ nil,
+	)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, err
+	}
+
+	manifest.Config = newConfig
+
+	newManifest, err := ociutil.IngestorJSONEncode(
+		/* context     */ ctx,
+		/* ingestor    */ store,
+		/* annotations */ manifestDesc.Annotations,
+		/* media type  */ ocispec.MediaTypeImageManifest,
+		/* interface   */ manifest,
+		/* platform    */ manifestDesc.Platform,
+	)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, err
+	}
+
+	return newManifest, newConfig, nil
+}
+
+func loadImage(ctx context.Context, provider content.Provider, desc ocispec.Descriptor) (ocispec.Manifest, ocispec.Image, error) {
+	manifest, err := ociutil.ImageManifestFromProvider(ctx, provider, desc)
+	if err != nil {
+		return ocispec.Manifest{}, ocispec.Image{}, fmt.Errorf("no image manifest (%v) in store: %w", desc, err)
+	}
+
+	imageConfig, err := ociutil.ImageConfigFromProvider(ctx, provider, manifest.Config)
+	if err != nil {
+		return ocispec.Manifest{}, ocispec.Image{}, fmt.Errorf("no image config (%v) in store: %w", manifest.Config, err)
+	}
+
+	if len(imageConfig.RootFS.DiffIDs) != len(manifest.Layers) {
+		return ocispec.Manifest{}, ocispec.Image{}, fmt.Errorf("image config has %d diffIDs for %d layers", len(imageConfig.RootFS.DiffIDs), len(manifest.Layers))
+	}
+
+	return manifest, imageConfig, nil
+}
+
+// CheckBase verifies that the lower layers of the image of manifestDesc are
+// the layers of the image of baseDesc, with the same diffIDs. It returns an
+// error wrapping ErrBaseMismatch if they aren't.
+func CheckBase(ctx context.Context, provider content.Provider, manifestDesc, baseDesc ocispec.Descriptor) error {
+	manifest, imageConfig, err := loadImage(ctx, provider, manifestDesc)
+	if err != nil {
+		return err
+	}
+
+	base, baseConfig, err := loadImage(ctx, provider, baseDesc)
+	if err != nil {
+		return fmt.Errorf("failed to load base: %w", err)
+	}
+
+	return checkBase(manifest, imageConfig, base, baseConfig)
+}
+
+// checkBase verifies that the lower layers of the image are the layers of
+// base.
+func checkBase(manifest ocispec.Manifest, imageConfig ocispec.Image, base ocispec.Manifest, baseConfig ocispec.Image) error {
+	if len(base.Layers) > len(manifest.Layers) {
+		return fmt.Errorf("%w: image has %d layers, fewer than the %d of the base", ErrBaseMismatch, len(manifest.Layers), len(base.Layers))
+	}
+
+	for i, layer := range base.Layers {
+		if manifest.Layers[i].Digest != layer.Digest {
+			return fmt.Errorf("%w: layer %d is %v, expected %v", ErrBaseMismatch, i, manifest.Layers[i].Digest, layer.Digest)
+		}
+
+		if imageConfig.RootFS.DiffIDs[i] != baseConfig.RootFS.DiffIDs[i] {
+			return fmt.Errorf("%w: diffID %d is %v, expected %v", ErrBaseMismatch, i, imageConfig.RootFS.DiffIDs[i], baseConfig.RootFS.DiffIDs[i])
+		}
+	}
+
+	return nil
+}
+
+// rebaseHistory replaces the history of the old base at the start of the
+// image history with the history of the new base. Bases without history get
+// an empty entry per layer when the image has history. The image history must
+// start with the history of the old base, the arguments are left untouched.
+func rebaseHistory(history []ocispec.History, numLayers int, oldBase []ocispec.History, oldBaseLayers int, newBase []ocispec.History, newBaseLayers int) ([]ocispec.History, error) {
+	if len(history) == 0 {
+		return nil, nil
+	}
+
+	// Skip the entries of the old base, which may also have entries for empty
+	// layers.
+	split := len(oldBase)
+	if split == 0 {
+		// Images built on a base without history lack the entries of the base
+		// layers.
+		missing := numLayers
+		for _, h := range history {
+			if !h.EmptyLayer {
+				missing--
+			}
+		}
+
+		for layers := 0; split < len(history) && layers < oldBaseLayers-missing; split++ {
+			if !history[split].EmptyLayer {
+				layers++
+			}
+		}
+	}
+	if split > len(history) {
+		return nil, fmt.Errorf("%w: image has %d history entries, fewer than the %d of the base", ErrBaseMismatch, len(history), split)
+	}
+	for i, h := range oldBase {
+		if !sameHistory(history[i], h) {
+			return nil, fmt.Errorf("%w: history entry %d of the image differs from the base", ErrBaseMismatch, i)
+		}
+	}
+
+	res := append([]ocispec.History{}, newBase...)
+	if len(newBase) == 0 {
+		res = make([]ocispec.History, newBaseLayers)
+	}
+
+	return append(res, history[split:]...), nil
+}
+
+// sameHistory reports whether two history entries describe the same step.
+func sameHistory(a, b ocispec.History) bool {
+	if (a.Created == nil) != (b.Created == nil) || a.Created != nil && !a.Created.Equal(*b.Created) {
+		return false
+	}
+	return a.CreatedBy == b.CreatedBy && a.Author == b.Author && a.Comment == b.Comment && a.EmptyLayer == b.EmptyLayer
+}
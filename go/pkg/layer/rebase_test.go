@@ -0,0 +1,269 @@
+package layer
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// refStore sets the ref of writers from their descriptor, which the local
+// store requires.
+type refStore struct {
+	content.Store
+}
+
+func (s refStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	var wOpts content.WriterOpts
+	for _, o := range opts {
+		if err := o(&wOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.Store.Writer(ctx, append(opts, content.WithRef(wOpts.Desc.Digest.String()))...)
+}
+
+// testImage writes an image with the given layers to the store, with a
+// history entry per layer.
+func testImage(t *testing.T, store content.Store, layers ...ocispec.Descriptor) ocispec.Descriptor {
+	t.Helper()
+	ctx := context.Background()
+
+	imageConfig := ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   ocispec.RootFS{Type: "layers"},
+	}
+	for _, l := range layers {
+		imageConfig.RootFS.DiffIDs = append(imageConfig.RootFS.DiffIDs, l.Digest)
+		imageConfig.History = append(imageConfig.History, ocispec.History{CreatedBy: l.Digest.Encoded()[:8]})
+	}
+
+	configDesc, err := ociutil.IngestorJSONEncode(ctx, store, nil, ocispec.MediaTypeImageConfig, imageConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    layers,
+	}
+	manifest.SchemaVersion = 2
+
+	manifestDesc, err := ociutil.IngestorJSONEncode(ctx, store, nil, ocispec.MediaTypeImageManifest, manifest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return manifestDesc
+}
+
+func TestRebaseImage(t *testing.T) {
+	ctx := context.Background()
+
+	localStore, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := refStore{localStore}
+
+	oldBaseLayer := testLayer(t, store, tar.Header{Name: "old", Typeflag: tar.TypeReg})
+	newBaseLayers := []ocispec.Descriptor{
+		testLayer(t, store, tar.Header{Name: "new", Typeflag: tar.TypeReg}),
+		testLayer(t, store, tar.Header{Name: "patch", Typeflag: tar.TypeReg}),
+	}
+	appLayer := testLayer(t, store, tar.Header{Name: "app", Typeflag: tar.TypeReg})
+
+	oldBase := testImage(t, store, oldBaseLayer)
+	newBase := testImage(t, store, newBaseLayers...)
+	image := testImage(t, store, oldBaseLayer, appLayer)
+
+	manifestDesc, _, err := RebaseImage(ctx, store, image, oldBase, newBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, imageConfig, err := loadImage(ctx, store, manifestDesc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var layers []digest.Digest
+	for _, l := range manifest.Layers {
+		layers = append(layers, l.Digest)
+	}
+	expected := []digest.Digest{newBaseLayers[0].Digest, newBaseLayers[1].Digest, appLayer.Digest}
+	if !reflect.DeepEqual(layers, expected) {
+		t.Fatalf("expected layers %v, got %v", expected, layers)
+	}
+	if !reflect.DeepEqual(imageConfig.RootFS.DiffIDs, expected) {
+		t.Fatalf("expected diffIDs %v, got %v", expected, imageConfig.RootFS.DiffIDs)
+	}
+
+	var history []string
+	for _, h := range imageConfig.History {
+		history = append(history, h.CreatedBy)
+	}
+	expectedHistory := []string{newBaseLayers[0].Digest.Encoded()[:8], newBaseLayers[1].Digest.Encoded()[:8], appLayer.Digest.Encoded()[:8]}
+	if !reflect.DeepEqual(history, expectedHistory) {
+		t.Fatalf("expected history %v, got %v", expectedHistory, history)
+	}
+
+	_, _, err = RebaseImage(ctx, store, image, newBase, oldBase)
+	if !errors.Is(err, ErrBaseMismatch) {
+		t.Fatalf("expected %v, got %v", ErrBaseMismatch, err)
+	}
+}
+
+func TestCheckBase(t *testing.T) {
+	ctx := context.Background()
+
+	localStore, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := refStore{localStore}
+
+	baseLayer := testLayer(t, store, tar.Header{Name: "base", Typeflag: tar.TypeReg})
+	appLayer := testLayer(t, store, tar.Header{Name: "app", Typeflag: tar.TypeReg})
+
+	base := testImage(t, store, baseLayer)
+	image := testImage(t, store, baseLayer, appLayer)
+
+	err = CheckBase(ctx, store, image, base)
+	if err != nil {
+		t.Fatalf("expected the image to be built on its base, got %v", err)
+	}
+
+	err = CheckBase(ctx, store, image, testImage(t, store, appLayer))
+	if !errors.Is(err, ErrBaseMismatch) {
+		t.Errorf("expected %v for other layers, got %v", ErrBaseMismatch, err)
+	}
+
+	// The same layer, with another diffID.
+	baseManifest, baseConfig, err := loadImage(ctx, store, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseConfig.RootFS.DiffIDs[0] = digest.FromString("other")
+
+	baseManifest.Config, err = ociutil.IngestorJSONEncode(ctx, store, nil, ocispec.MediaTypeImageConfig, baseConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBase, err := ociutil.IngestorJSONEncode(ctx, store, nil, ocispec.MediaTypeImageManifest, baseManifest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CheckBase(ctx, store, image, otherBase)
+	if !errors.Is(err, ErrBaseMismatch) {
+		t.Errorf("expected %v for other diffIDs, got %v", ErrBaseMismatch, err)
+	}
+}
+
+func TestRebaseHistory(t *testing.T) {
+	entries := func(names ...string) []ocispec.History {
+		var res []ocispec.History
+		for _, n := range names {
+			res = append(res, ocispec.History{CreatedBy: n})
+		}
+		return res
+	}
+	names := func(history []ocispec.History) []string {
+		var res []string
+		for _, h := range history {
+			res = append(res, h.CreatedBy)
+		}
+		return res
+	}
+
+	for _, tc := range []struct {
+		name          string
+		history       []ocispec.History
+		numLayers     int
+		oldBase       []ocispec.History
+		oldBaseLayers int
+		newBase       []ocispec.History
+		newBaseLayers int
+		expected      []string
+		err           error
+	}{
+		{
+			name:          "matching base",
+			history:       entries("old", "app"),
+			numLayers:     2,
+			oldBase:       entries("old"),
+			oldBaseLayers: 1,
+			newBase:       entries("new1", "new2"),
+			newBaseLayers: 2,
+			expected:      []string{"new1", "new2", "app"},
+		},
+		{
+			name:          "old base without history",
+			history:       entries("app"),
+			numLayers:     2,
+			oldBaseLayers: 1,
+			newBase:       entries("new"),
+			newBaseLayers: 1,
+			expected:      []string{"new", "app"},
+		},
+		{
+			name:          "new base without history",
+			history:       entries("old", "app"),
+			numLayers:     2,
+			oldBase:       entries("old"),
+			oldBaseLayers: 1,
+			newBaseLayers: 1,
+			expected:      []string{"", "app"},
+		},
+		{
+			name:          "other base",
+			history:       entries("other", "app"),
+			numLayers:     2,
+			oldBase:       entries("old"),
+			oldBaseLayers: 1,
+			newBase:       entries("new"),
+			newBaseLayers: 1,
+			err:           ErrBaseMismatch,
+		},
+		{
+			name:          "short history",
+			history:       entries("old"),
+			numLayers:     2,
+			oldBase:       entries("old", "old2"),
+			oldBaseLayers: 2,
+			newBase:       entries("new"),
+			newBaseLayers: 1,
+			err:           ErrBaseMismatch,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			history := slices.Clone(tc.history)
+			oldBase := slices.Clone(tc.oldBase)
+			newBase := slices.Clone(tc.newBase)
+
+			res, err := rebaseHistory(tc.history, tc.numLayers, tc.oldBase, tc.oldBaseLayers, tc.newBase, tc.newBaseLayers)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if tc.err == nil && !reflect.DeepEqual(names(res), tc.expected) {
+				t.Errorf("expected history %v, got %v", tc.expected, names(res))
+			}
+
+			if !reflect.DeepEqual(tc.history, history) || !reflect.DeepEqual(tc.oldBase, oldBase) || !reflect.DeepEqual(tc.newBase, newBase) {
+				t.Errorf("arguments were modified")
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package layer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// SquashLayers writes the content of layers, applied in order, to w as a
+// single uncompressed layer. Unlike Flatten, whiteouts that may delete files
+// from layers below the range are kept.
+func SquashLayers(ctx context.Context, provider content.Provider, layers []ocispec.Descriptor, w io.Writer) error {
+	fsys := NewFS()
+	whiteouts := make(map[string]Entry)
+	for i, desc := range layers {
+		entries, err := LayerEntries(ctx, provider, desc, i)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			dir, base := path.Split(entry.Path)
+			dir = strings.TrimSuffix(dir, "/")
+
+			switch {
+			case base == WhiteoutOpaqueDir:
+				removeWhiteoutsBelow(whiteouts, dir, entry.Layer)
+				whiteouts[entry.Path] = entry
+			case strings.HasPrefix(base, WhiteoutPrefix):
+				removeWhiteoutsBelow(whiteouts, path.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix)), entry.Layer)
+				whiteouts[entry.Path] = entry
+			case entry.Type != tar.TypeDir:
+				// Nothing below a file is visible.
+				removeWhiteoutsBelow(whiteouts, entry.Path, entry.Layer)
+			}
+		}
+
+		fsys.Apply(entries)
+	}
+
+	fl, err := newFlattener(ctx, provider, layers, fsys)
+	if err != nil {
+		return err
+	}
+	defer fl.Close()
+
+	var extra []Entry
+	for _, entry := range whiteouts {
+		extra = append(extra, entry)
+	}
+
+	return fl.writeTar(w, extra)
+}
+
+// removeWhiteoutsBelow removes the whiteouts for paths below dir that are
+// from layers lower than layer.
+func removeWhiteoutsBelow(whiteouts map[string]Entry, dir string, layer int) {
+	prefix := dir + "/"
+	if dir == "" {
+		prefix = ""
+	}
+
+	for p, entry := range whiteouts {
+		if strings.HasPrefix(p, prefix) && entry.Layer < layer {
+			delete(whiteouts, p)
+		}
+	}
+}
+
+// SquashImage replaces the layers of the image manifest in the range
+// [from, to) with a single gzip compressed layer, which is written to w. The
+// diffIDs and history of the config are rewritten to match, the new manifest
+// and config are written to store.
+//
+// It returns the descriptors of the new manifest, config and layer.
+func SquashImage(
+	ctx context.Context,
+	store content.Store,
+	manifestDesc ocispec.Descriptor,
+	from, to int,
+	w io.Writer,
+) (ocispec.Descriptor, ocispec.Descriptor, ocispec.Descriptor, error) {
+	manifest, err := ociutil.ImageManifestFromProvider(ctx, store, manifestDesc)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, ocispec.Descriptor{}, fmt.Errorf("no image manifest (%v) in store: %w", manifestDesc, err)
+	}
+
+	if from < 0 || to > len(manifest.Layers) || from >= to {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, ocispec.Descriptor{},
+			fmt.Errorf("invalid layer range [%d, %d) for an image with %d layers", from, to, len(manifest.Layers))
+	}
+
+	imageConfig, err := ociutil.ImageConfigFromProvider(ctx, store, manifest.Config)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, ocispec.Descriptor{}, fmt.Errorf("no image config (%v) in store: %w", manifest.Config, err)
+	}
+
+	if len(imageConfig.RootFS.DiffIDs) != len(manifest.Layers) {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, ocispec.Descriptor{},
+			fmt.Errorf("image config has %d diffIDs for %d layers", len(imageConfig.RootFS.DiffIDs), len(manifest.Layers))
+	}
+
+	history, err := squashHistory(imageConfig.History, len(manifest.Layers), from, to)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, ocispec.Descriptor{}, err
+	}
+
+	layerDesc, diffID, err := writeSquashedLayer(ctx, store, manifest.Layers[from:to], w)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, ocispec.Descriptor{}, err
+	}
+	if manifest.MediaType == images.MediaTypeDockerSchema2Manifest || manifestDesc.MediaType == images.MediaTypeDockerSchema2Manifest {
+		layerDesc.MediaType = images.MediaTypeDockerSchema2LayerGzip
+	}
+
+	layers := append([]ocispec.Descriptor{}, manifest.Layers[:from]...)
+	layers = append(layers, layerDesc)
+	manifest.Layers = append(layers, manifest.Layers[to:]...)
+
+	diffIDs := append([]digest.Digest{}, imageConfig.RootFS.DiffIDs[:from]...)
+	diffIDs = append(diffIDs, diffID)
+	imageConfig.RootFS.DiffIDs = append(diffIDs, imageConfig.RootFS.DiffIDs[to:]...)
+	imageConfig.History = history
+
+	newConfig, err := ociutil.IngestorJSONEncode(
+		/* context     */ ctx,
+		/* ingestor    */ store,
+		/* annotations */ nil,
+		/* media type  */ manifest.Config.MediaType,
+		/* interface   */ imageConfig,
+		/* platform    */ nil,
+	)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, ocispec.Descriptor{}, err
+	}
+
+	manifest.Config = newConfig
+
+	newManifest, err := ociutil.IngestorJSONEncode(
+		/* context     */ ctx,
+		/* ingestor    */ store,
+		/* annotations */ manifestDesc.Annotations,
+		/* media type  */ manifestDesc.MediaType,
+		/* interface   */ manifest,
+		/* platform    */ manifestDesc.Platform,
+	)
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Descriptor{}, ocispec.Descriptor{}, err
+	}
+
+	return newManifest, newConfig, layerDesc, nil
+}
+
+// writeSquashedLayer writes the squashed layers to w with gzip and returns
+// the descriptor and diffID of the new layer.
+func writeSquashedLayer(ctx context.Context, provider content.Provider, layers []ocispec.Descriptor, w io.Writer) (ocispec.Descriptor, digest.Digest, error) {
+	layerDigester := digest.Canonical.Digester()
+	wc := ociutil.NewWriterCounter(io.MultiWriter(w, layerDigester.Hash()))
+
+	gw := gzip.NewWriter(wc)
+	diffIDDigester := digest.Canonical.Digester()
+
+	err := SquashLayers(ctx, provider, layers, io.MultiWriter(gw, diffIDDigester.Hash()))
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+
+	err = gw.Close()
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    layerDigester.Digest(),
+		Size:      int64(wc.Count()),
+	}
+
+	return desc, diffIDDigester.Digest(), nil
+}
+
+// squashHistory replaces the history of the layers in [from, to) with a
+// single entry. Entries for empty layers in the range are kept before it.
+func squashHistory(history []ocispec.History, numLayers, from, to int) ([]ocispec.History, error) {
+	if len(history) == 0 {
+		return nil, nil
+	}
+
+	var nonEmpty int
+	for _, h := range history {
+		if !h.EmptyLayer {
+			nonEmpty++
+		}
+	}
+	if nonEmpty != numLayers {
+		return nil, fmt.Errorf("image config has %d history entries for %d layers", nonEmpty, numLayers)
+	}
+
+	squashed := ocispec.History{
+		Comment:   "rules_oci",
+		CreatedBy: fmt.Sprintf("squash of layers %d to %d", from, to-1),
+	}
+
+	var res []ocispec.History
+	layer := 0
+	for _, h := range history {
+		inRange := layer >= from && layer < to
+		if !h.EmptyLayer {
+			layer++
+		}
+
+		if !inRange {
+			res = append(res, h)
+			continue
+		}
+
+		if h.EmptyLayer {
+			res = append(res, h)
+			continue
+		}
+
+		// The squashed layer is as recent as its newest layer.
+		if h.Created != nil && (squashed.Created == nil || h.Created.After(*squashed.Created)) {
+			created := *h.Created
+			squashed.Created = &created
+		}
+
+		if layer == to {
+			res = append(res, squashed)
+		}
+	}
+
+	return res, nil
+}
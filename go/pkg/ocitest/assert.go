@@ -0,0 +1,245 @@
+package ocitest
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/layer"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+// AssertEntrypoint checks the entrypoint of the image config.
+func (img *Image) AssertEntrypoint(t testing.TB, want ...string) {
+	t.Helper()
+	assert.Equal(t, want, img.Config.Config.Entrypoint, "entrypoint of %v", img.Descriptor.Digest)
+}
+
+// AssertCmd checks the command of the image config.
+func (img *Image) AssertCmd(t testing.TB, want ...string) {
+	t.Helper()
+	assert.Equal(t, want, img.Config.Config.Cmd, "cmd of %v", img.Descriptor.Digest)
+}
+
+// AssertUser checks the user of the image config.
+func (img *Image) AssertUser(t testing.TB, want string) {
+	t.Helper()
+	assert.Equal(t, want, img.Config.Config.User, "user of %v", img.Descriptor.Digest)
+}
+
+// AssertWorkingDir checks the working directory of the image config.
+func (img *Image) AssertWorkingDir(t testing.TB, want string) {
+	t.Helper()
+	assert.Equal(t, want, img.Config.Config.WorkingDir, "working dir of %v", img.Descriptor.Digest)
+}
+
+// AssertEnv checks that the image config sets the environment variable key
+// to value.
+func (img *Image) AssertEnv(t testing.TB, key, value string) {
+	t.Helper()
+
+	for _, env := range img.Config.Config.Env {
+		if k, v, _ := strings.Cut(env, "="); k == key {
+			assert.Equal(t, value, v, "env %v of %v", key, img.Descriptor.Digest)
+			return
+		}
+	}
+
+	t.Errorf("env %v of %v isn't set, the env is:\n\t%v", key, img.Descriptor.Digest, strings.Join(img.Config.Config.Env, "\n\t"))
+}
+
+// AssertLabel checks that the image config has the label key with value.
+func (img *Image) AssertLabel(t testing.TB, key, value string) {
+	t.Helper()
+
+	v, ok := img.Config.Config.Labels[key]
+	if !ok {
+		t.Errorf("label %v of %v isn't set, the labels are: %v", key, img.Descriptor.Digest, img.Config.Config.Labels)
+		return
+	}
+
+	assert.Equal(t, value, v, "label %v of %v", key, img.Descriptor.Digest)
+}
+
+// AssertLayerCount checks the number of layers of the image.
+func (img *Image) AssertLayerCount(t testing.TB, want int) {
+	t.Helper()
+
+	if len(img.Manifest.Layers) != want {
+		t.Errorf("expected %d layers in %v, got %d:\n\t%v", want, img.Descriptor.Digest, len(img.Manifest.Layers), strings.Join(img.layerLines(), "\n\t"))
+	}
+}
+
+// AssertLayerSizes checks the sizes of the layer blobs of the image, from the
+// lowest layer.
+func (img *Image) AssertLayerSizes(t testing.TB, want ...int64) {
+	t.Helper()
+	assert.Equal(t, want, img.LayerSizes(), "layer sizes of %v", img.Descriptor.Digest)
+}
+
+// AssertMaxLayerSize checks that no layer blob of the image is larger than
+// size bytes.
+func (img *Image) AssertMaxLayerSize(t testing.TB, size int64) {
+	t.Helper()
+
+	for i, desc := range img.Manifest.Layers {
+		if desc.Size > size {
+			t.Errorf("layer %d of %v is %d bytes, more than %d:\n\t%v", i, img.Descriptor.Digest, desc.Size, size, strings.Join(img.layerLines(), "\n\t"))
+		}
+	}
+}
+
+// LayerSizes returns the sizes of the layer blobs of the image, from the
+// lowest layer.
+func (img *Image) LayerSizes() []int64 {
+	sizes := make([]int64, 0, len(img.Manifest.Layers))
+	for _, desc := range img.Manifest.Layers {
+		sizes = append(sizes, desc.Size)
+	}
+
+	return sizes
+}
+
+func (img *Image) layerLines() []string {
+	lines := make([]string, 0, len(img.Manifest.Layers))
+	for i, desc := range img.Manifest.Layers {
+		lines = append(lines, fmt.Sprintf("%d: %v %d bytes", i, desc.Digest, desc.Size))
+	}
+
+	return lines
+}
+
+// FileCheck is a check of a file of the merged filesystem, see AssertFile.
+type FileCheck func(t testing.TB, img *Image, entry layer.Entry)
+
+// Mode checks the permission bits, including setuid, setgid and sticky, of
+// a file.
+func Mode(want fs.FileMode) FileCheck {
+	mode := int64(want.Perm())
+	if want&fs.ModeSetuid != 0 {
+		mode |= 0o4000
+	}
+	if want&fs.ModeSetgid != 0 {
+		mode |= 0o2000
+	}
+	if want&fs.ModeSticky != 0 {
+		mode |= 0o1000
+	}
+
+	return func(t testing.TB, img *Image, entry layer.Entry) {
+		t.Helper()
+		assert.Equal(t, fmt.Sprintf("%#o", mode), fmt.Sprintf("%#o", entry.Mode), "mode of %v", entry.Path)
+	}
+}
+
+// Owner checks the owner and group of a file.
+func Owner(uid, gid int) FileCheck {
+	return func(t testing.TB, img *Image, entry layer.Entry) {
+		t.Helper()
+		assert.Equal(t, fmt.Sprintf("%d:%d", uid, gid), fmt.Sprintf("%d:%d", entry.UID, entry.GID), "owner of %v", entry.Path)
+	}
+}
+
+// Type checks the type of a file, e.g. tar.TypeDir.
+func Type(want layer.EntryType) FileCheck {
+	return func(t testing.TB, img *Image, entry layer.Entry) {
+		t.Helper()
+		assert.Equal(t, want.String(), entry.Type.String(), "type of %v", entry.Path)
+	}
+}
+
+// Content checks the content of a regular file, following links. The
+// content is diffed line by line on mismatch.
+func Content(want string) FileCheck {
+	return func(t testing.TB, img *Image, entry layer.Entry) {
+		t.Helper()
+
+		// The content is only read when it doesn't match.
+		if (entry.Type == tar.TypeReg || entry.Type == tar.TypeRegA) && entry.Digest == digest.SHA256.FromString(want) {
+			return
+		}
+
+		var buf bytes.Buffer
+		_, err := layer.ExtractFile(context.Background(), img.Provider, img.Manifest.Layers, entry.Path, &buf)
+		if err != nil {
+			t.Errorf("failed to read %v: %v", entry.Path, err)
+			return
+		}
+
+		assert.Equal(t, want, buf.String(), "content of %v", entry.Path)
+	}
+}
+
+// AssertFile checks that the merged filesystem of the image has a file at p,
+// and runs the checks on it.
+func (img *Image) AssertFile(t testing.TB, p string, checks ...FileCheck) {
+	t.Helper()
+
+	fsys := img.FS(t)
+
+	entry, ok := fsys.Entries[layer.CleanPath(p)]
+	if !ok {
+		t.Errorf("%v not found in %v, %v", p, img.Descriptor.Digest, dirListing(fsys, p))
+		return
+	}
+
+	for _, check := range checks {
+		check(t, img, entry)
+	}
+}
+
+// AssertNoFile checks that the merged filesystem of the image doesn't have a
+// file at p.
+func (img *Image) AssertNoFile(t testing.TB, p string) {
+	t.Helper()
+
+	if entry, ok := img.FS(t).Entries[layer.CleanPath(p)]; ok {
+		t.Errorf("expected no %v in %v, found a %v from layer %d", p, img.Descriptor.Digest, entry.Type, entry.Layer)
+	}
+}
+
+// dirListing describes the files of the closest parent of p with files, to
+// spot typos in the paths of failed assertions.
+func dirListing(fsys *layer.FS, p string) string {
+	paths := fsys.Paths()
+
+	dir := layer.CleanPath(p)
+	for dir != "" {
+		dir = path.Dir(dir)
+		if dir == "." {
+			dir = ""
+		}
+
+		// Directories without entries of their own are listed too.
+		var children []string
+		seen := make(map[string]bool)
+		for _, child := range paths {
+			rest, ok := strings.CutPrefix(child, dir+"/")
+			if dir == "" {
+				rest, ok = child, true
+			}
+			if !ok || rest == "" {
+				continue
+			}
+
+			name, _, _ := strings.Cut(rest, "/")
+			if !seen[name] {
+				seen[name] = true
+				children = append(children, path.Join("/", dir, name))
+			}
+		}
+
+		if len(children) > 0 {
+			return fmt.Sprintf("/%v contains:\n\t%v", dir, strings.Join(children, "\n\t"))
+		}
+	}
+
+	return "the filesystem is empty"
+}
@@ -0,0 +1,152 @@
+// Package ocitest provides assertions on images for Go tests, e.g. on the
+// images built by Bazel. Images are loaded once with LoadFromBlobIndex or
+// LoadFromDescriptor, and the assertions take the testing.TB of the test or
+// subtest they report to:
+//
+//	img := ocitest.LoadFromDescriptor(t, "image.json", []string{"image.blob-index.json"})
+//	img.AssertEntrypoint(t, "/app/server")
+//	img.AssertFile(t, "/etc/app.yaml", ocitest.Mode(0o644), ocitest.Owner(0, 0))
+package ocitest
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/layer"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Image is an image loaded for assertions.
+type Image struct {
+	// Provider provides the blobs of the image.
+	Provider content.Provider
+	// Descriptor is the descriptor of the image manifest, the manifest of
+	// the selected platform for image indexes.
+	Descriptor ocispec.Descriptor
+	Manifest   ocispec.Manifest
+	Config     ocispec.Image
+
+	fsOnce sync.Once
+	fsys   *layer.FS
+	fsErr  error
+}
+
+type options struct {
+	platform ocispec.Platform
+}
+
+// Option configures the loading of an image.
+type Option func(*options) error
+
+// WithPlatform selects the manifest of image indexes with a platform
+// specifier like "linux/arm64". Defaults to Linux on the host architecture.
+func WithPlatform(platform string) Option {
+	return func(o *options) error {
+		p, err := platforms.Parse(platform)
+		if err != nil {
+			return err
+		}
+
+		o.platform = p
+		return nil
+	}
+}
+
+// LoadFromBlobIndex loads the image named name in the blob index file or OCI
+// image layout directory at path. If name is empty, the blob index or layout
+// must have a single image.
+func LoadFromBlobIndex(t testing.TB, path, name string, opts ...Option) *Image {
+	t.Helper()
+
+	provider, err := ociutil.LoadLayout(path)
+	if err != nil {
+		t.Fatalf("failed to load %v: %v", path, err)
+	}
+
+	desc, err := ociutil.ResolveLayoutRoot(path, name)
+	if err != nil {
+		t.Fatalf("failed to find image %q in %v: %v", name, path, err)
+	}
+
+	return load(t, provider, desc, opts)
+}
+
+// LoadFromDescriptor loads the image of the descriptor file at descPath, its
+// blobs being in the blob index files or OCI image layout directories at
+// layouts.
+func LoadFromDescriptor(t testing.TB, descPath string, layouts []string, opts ...Option) *Image {
+	t.Helper()
+
+	desc, err := ociutil.ReadDescriptorFromFile(descPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providers := make([]content.Provider, 0, len(layouts))
+	for _, path := range layouts {
+		provider, err := ociutil.LoadLayout(path)
+		if err != nil {
+			t.Fatalf("failed to load %v: %v", path, err)
+		}
+
+		providers = append(providers, provider)
+	}
+
+	return load(t, ociutil.MultiProvider(providers...), desc, opts)
+}
+
+func load(t testing.TB, provider content.Provider, desc ocispec.Descriptor, opts []Option) *Image {
+	t.Helper()
+	ctx := context.Background()
+
+	o := options{
+		platform: ocispec.Platform{OS: "linux", Architecture: runtime.GOARCH},
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifestDesc, err := ociutil.ResolveManifest(ctx, provider, desc, platforms.Only(o.platform))
+	if err != nil {
+		t.Fatalf("failed to resolve the manifest of %v: %v", desc.Digest, err)
+	}
+
+	manifest, err := ociutil.ImageManifestFromProvider(ctx, provider, manifestDesc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ociutil.ImageConfigFromProvider(ctx, provider, manifest.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Image{
+		Provider:   provider,
+		Descriptor: manifestDesc,
+		Manifest:   manifest,
+		Config:     config,
+	}
+}
+
+// FS returns the merged filesystem of the layers of the image.
+func (img *Image) FS(t testing.TB) *layer.FS {
+	t.Helper()
+
+	img.fsOnce.Do(func() {
+		img.fsys, img.fsErr = layer.MergeLayers(context.Background(), img.Provider, img.Manifest.Layers)
+	})
+	if img.fsErr != nil {
+		t.Fatalf("failed to merge the layers of %v: %v", img.Descriptor.Digest, img.fsErr)
+	}
+
+	return img.fsys
+}
@@ -0,0 +1,211 @@
+package ocitest
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// recorder records the failures of the assertions instead of failing the
+// test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// writeBlob writes a blob to dir and adds it to bi.
+func writeBlob(t *testing.T, bi *blob.Index, dir, mediaType string, data []byte) ocispec.Descriptor {
+	t.Helper()
+
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+
+	path := filepath.Join(dir, desc.Digest.Encoded())
+	err := os.WriteFile(path, data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bi.Add(desc, path)
+
+	return desc
+}
+
+// writeLayer writes an uncompressed layer with files of the given content.
+func writeLayer(t *testing.T, bi *blob.Index, dir string, hdrs ...tar.Header) ocispec.Descriptor {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range hdrs {
+		data := hdr.Linkname
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Linkname = ""
+			hdr.Size = int64(len(data))
+		}
+
+		err := tw.WriteHeader(&hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hdr.Typeflag == tar.TypeReg {
+			_, err = tw.Write([]byte(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return writeBlob(t, bi, dir, ocispec.MediaTypeImageLayer, buf.Bytes())
+}
+
+// writeImage writes a test image and returns the paths of its blob index and
+// descriptor.
+func writeImage(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	bi := &blob.Index{}
+
+	layers := []ocispec.Descriptor{
+		writeLayer(t, bi, dir,
+			tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755},
+			tar.Header{Name: "etc/app.yaml", Typeflag: tar.TypeReg, Mode: 0o644, Linkname: "port: 80\nhost: a\n"},
+			tar.Header{Name: "etc/old", Typeflag: tar.TypeReg, Mode: 0o644},
+		),
+		writeLayer(t, bi, dir,
+			tar.Header{Name: "etc/.wh.old", Typeflag: tar.TypeReg},
+			tar.Header{Name: "app/bin/server", Typeflag: tar.TypeReg, Mode: 0o4755, Uid: 1000, Gid: 1000, Linkname: "binary"},
+			tar.Header{Name: "usr/bin/server", Typeflag: tar.TypeSymlink, Linkname: "/app/bin/server"},
+		),
+	}
+
+	config, err := json.Marshal(ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+		Config: ocispec.ImageConfig{
+			User:       "app",
+			Env:        []string{"PATH=/usr/bin", "PORT=80"},
+			Entrypoint: []string{"/app/bin/server"},
+			Cmd:        []string{"--verbose"},
+			WorkingDir: "/app",
+			Labels:     map[string]string{"team": "oci"},
+		},
+		RootFS: ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{layers[0].Digest, layers[1].Digest}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeBlob(t, bi, dir, ocispec.MediaTypeImageConfig, config),
+		Layers:    layers,
+	}
+	manifest.SchemaVersion = 2
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := writeBlob(t, bi, dir, ocispec.MediaTypeImageManifest, data)
+	bi.AddRoot("app", desc)
+
+	indexPath := filepath.Join(dir, "image.blob-index.json")
+	err = bi.WriteToFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	descPath := filepath.Join(dir, "image.json")
+	err = ociutil.WriteDescriptorToFile(descPath, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return indexPath, descPath
+}
+
+func TestImage(t *testing.T) {
+	indexPath, descPath := writeImage(t)
+
+	for name, img := range map[string]*Image{
+		"blob index": LoadFromBlobIndex(t, indexPath, "", WithPlatform("linux/amd64")),
+		"descriptor": LoadFromDescriptor(t, descPath, []string{indexPath}, WithPlatform("linux/amd64")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			img.AssertEntrypoint(t, "/app/bin/server")
+			img.AssertCmd(t, "--verbose")
+			img.AssertUser(t, "app")
+			img.AssertWorkingDir(t, "/app")
+			img.AssertEnv(t, "PORT", "80")
+			img.AssertLabel(t, "team", "oci")
+
+			img.AssertFile(t, "/etc/app.yaml", Mode(0o644), Owner(0, 0), Content("port: 80\nhost: a\n"))
+			img.AssertFile(t, "/app/bin/server", Mode(0o755|os.ModeSetuid), Owner(1000, 1000), Content("binary"))
+			img.AssertFile(t, "/usr/bin/server", Type(tar.TypeSymlink), Content("binary"))
+			img.AssertFile(t, "/etc", Type(tar.TypeDir))
+			img.AssertNoFile(t, "/etc/old")
+
+			img.AssertLayerCount(t, 2)
+			img.AssertLayerSizes(t, img.Manifest.Layers[0].Size, img.Manifest.Layers[1].Size)
+			img.AssertMaxLayerSize(t, 1<<20)
+		})
+	}
+}
+
+func TestImageFailures(t *testing.T) {
+	indexPath, _ := writeImage(t)
+	img := LoadFromBlobIndex(t, indexPath, "app", WithPlatform("linux/amd64"))
+
+	for _, tc := range []struct {
+		name   string
+		assert func(t testing.TB)
+		error  string
+	}{
+		{"entrypoint", func(t testing.TB) { img.AssertEntrypoint(t, "/server") }, `- (string) (len=7) "/server"`},
+		{"env", func(t testing.TB) { img.AssertEnv(t, "HOST", "a") }, "PATH=/usr/bin\n\tPORT=80"},
+		{"label", func(t testing.TB) { img.AssertLabel(t, "team", "bazel") }, "-bazel\n"},
+		{"missing file", func(t testing.TB) { img.AssertFile(t, "/app/bin/client") }, "/app/bin contains:\n\t/app/bin/server"},
+		{"mode", func(t testing.TB) { img.AssertFile(t, "/etc/app.yaml", Mode(0o600)) }, `+0644`},
+		{"owner", func(t testing.TB) { img.AssertFile(t, "/etc/app.yaml", Owner(1000, 1000)) }, `+0:0`},
+		{"content", func(t testing.TB) { img.AssertFile(t, "/etc/app.yaml", Content("port: 80\nhost: b\n")) }, "-host: b\n"},
+		{"no file", func(t testing.TB) { img.AssertNoFile(t, "/etc/app.yaml") }, "found a file from layer 0"},
+		{"layer count", func(t testing.TB) { img.AssertLayerCount(t, 1) }, "expected 1 layers"},
+		{"layer sizes", func(t testing.TB) { img.AssertLayerSizes(t, 1, 2) }, "layer sizes of"},
+		{"max layer size", func(t testing.TB) { img.AssertMaxLayerSize(t, 1) }, "layer 0 of"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &recorder{TB: t}
+			tc.assert(r)
+
+			if len(r.errors) == 0 {
+				t.Fatal("expected the assertion to fail")
+			}
+			if msg := strings.Join(r.errors, "\n"); !strings.Contains(msg, tc.error) {
+				t.Errorf("expected the failure to contain %q, got:\n%v", tc.error, msg)
+			}
+		})
+	}
+}
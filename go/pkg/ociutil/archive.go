@@ -0,0 +1,352 @@
+package ociutil
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/archive"
+	dref "github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	// ErrNoArchiveImage is returned when no image in an archive matches the
+	// requested repo tag.
+	ErrNoArchiveImage = fmt.Errorf("no matching image in archive")
+
+	// ErrAmbiguousArchiveImage is returned when an archive contains several
+	// images and no repo tag was given to choose between them.
+	ErrAmbiguousArchiveImage = fmt.Errorf("archive contains several images, a repo tag is required")
+)
+
+// ImportArchive reads a `docker save` tarball or an OCI image layout tarball
+// into store and returns the descriptor of the image selected by repoTag. If
+// repoTag is empty, the archive must contain exactly one image.
+//
+// Docker archives are converted into an image manifest with the platform
+// recovered from the image config. The OCI reference annotation is stripped
+// from the returned descriptor, as it names the archive's repository rather
+// than a registry the layers can be mounted from. The containerd image name
+// annotation is kept, it gives the image its name once imported.
+func ImportArchive(ctx context.Context, store content.Store, reader io.Reader, repoTag string) (ocispec.Descriptor, error) {
+	indexDesc, err := archive.ImportIndex(ctx, store, reader)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to import archive: %w", err)
+	}
+
+	index, err := ImageIndexFromProvider(ctx, store, indexDesc)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to read archive index: %w", err)
+	}
+
+	desc, err := selectArchiveImage(index, repoTag)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	delete(desc.Annotations, ocispec.AnnotationRefName)
+	if len(desc.Annotations) == 0 {
+		desc.Annotations = nil
+	}
+
+	return desc, nil
+}
+
+// selectArchiveImage picks the image from an archive's index that matches
+// repoTag. Repo tags are compared in their normalized form so that
+// "busybox:latest" matches "docker.io/library/busybox:latest".
+func selectArchiveImage(index ocispec.Index, repoTag string) (ocispec.Descriptor, error) {
+	if repoTag == "" {
+		if len(index.Manifests) == 0 {
+			return ocispec.Descriptor{}, ErrNoArchiveImage
+		}
+
+		// Several tags of the same image are fine.
+		for _, desc := range index.Manifests[1:] {
+			if desc.Digest != index.Manifests[0].Digest {
+				return ocispec.Descriptor{}, fmt.Errorf("%w: %v", ErrAmbiguousArchiveImage, archiveImageNames(index))
+			}
+		}
+
+		return index.Manifests[0], nil
+	}
+
+	normalized := repoTag
+	if named, err := dref.ParseNormalizedNamed(repoTag); err == nil {
+		normalized = dref.TagNameOnly(named).String()
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.Annotations[images.AnnotationImageName] == normalized {
+			return desc, nil
+		}
+
+		if ref := desc.Annotations[ocispec.AnnotationRefName]; ref == repoTag || ref == normalized {
+			return desc, nil
+		}
+	}
+
+	return ocispec.Descriptor{}, fmt.Errorf("%w: %q, available: %v", ErrNoArchiveImage, repoTag, archiveImageNames(index))
+}
+
+func archiveImageNames(index ocispec.Index) string {
+	names := make([]string, 0, len(index.Manifests))
+	for _, desc := range index.Manifests {
+		name := desc.Annotations[images.AnnotationImageName]
+		if name == "" {
+			name = desc.Annotations[ocispec.AnnotationRefName]
+		}
+		if name == "" {
+			name = desc.Digest.String()
+		}
+
+		names = append(names, name)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// NormalizeRepoTags normalizes repo tags into fully qualified references,
+// adding the "latest" tag when none is given.
+func NormalizeRepoTags(repoTags []string) ([]dref.NamedTagged, error) {
+	named := make([]dref.NamedTagged, 0, len(repoTags))
+	for _, repoTag := range repoTags {
+		n, err := dref.ParseNormalizedNamed(repoTag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repo tag %q: %w", repoTag, err)
+		}
+
+		tagged, ok := dref.TagNameOnly(n).(dref.NamedTagged)
+		if !ok {
+			return nil, fmt.Errorf("invalid repo tag %q: must not contain a digest", repoTag)
+		}
+
+		named = append(named, tagged)
+	}
+
+	return named, nil
+}
+
+// WriteOCIArchive writes desc and all of its children as an OCI image layout
+// tarball, with an entry in index.json for each repo tag.
+func WriteOCIArchive(ctx context.Context, provider content.Provider, writer io.Writer, desc ocispec.Descriptor, repoTags []string) error {
+	named, err := NormalizeRepoTags(repoTags)
+	if err != nil {
+		return err
+	}
+
+	ing := NewTarIngestor(writer)
+
+	err = CopyChildrenFromHandler(ctx, images.ChildrenHandler(provider), provider, ing, desc)
+	if err != nil {
+		return err
+	}
+
+	err = CopyContent(ctx, provider, ing, desc)
+	if err != nil {
+		return err
+	}
+
+	manifests := []ocispec.Descriptor{desc}
+	if len(named) > 0 {
+		manifests = make([]ocispec.Descriptor, 0, len(named))
+		for _, n := range named {
+			manifests = append(manifests, withImageName(desc, n))
+		}
+	}
+
+	return ing.Finish(manifests...)
+}
+
+// withImageName annotates a descriptor with the name of the image, using the
+// same annotations as containerd.
+func withImageName(desc ocispec.Descriptor, named dref.NamedTagged) ocispec.Descriptor {
+	annotations := make(map[string]string, len(desc.Annotations)+2)
+	for k, v := range desc.Annotations {
+		annotations[k] = v
+	}
+
+	annotations[images.AnnotationImageName] = named.String()
+	annotations[ocispec.AnnotationRefName] = named.Tag()
+
+	desc.Annotations = annotations
+	return desc
+}
+
+// dockerArchiveManifest is an entry of manifest.json in a `docker save`
+// tarball.
+type dockerArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// WriteDockerArchive writes an image manifest as a tarball that can be loaded
+// with `docker load` or `podman load`.
+//
+// Layers are written uncompressed, as older versions of docker can only load
+// tar layers.
+func WriteDockerArchive(ctx context.Context, provider content.Provider, writer io.Writer, manifestDesc ocispec.Descriptor, repoTags []string) error {
+	named, err := NormalizeRepoTags(repoTags)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := ImageManifestFromProvider(ctx, provider, manifestDesc)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	config, err := content.ReadBlob(ctx, provider, manifest.Config)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	tw := tar.NewWriter(writer)
+
+	entry := dockerArchiveManifest{
+		Config: manifest.Config.Digest.Encoded() + ".json",
+	}
+
+	err = writeTarFile(tw, entry.Config, config)
+	if err != nil {
+		return err
+	}
+
+	written := make(map[digest.Digest]bool)
+	var topLayerID string
+	for _, layer := range manifest.Layers {
+		diffID, path, err := writeDockerArchiveLayer(ctx, provider, tw, layer, written)
+		if err != nil {
+			return fmt.Errorf("failed to write layer %v: %w", layer.Digest, err)
+		}
+
+		entry.Layers = append(entry.Layers, path)
+		topLayerID = diffID.Encoded()
+	}
+
+	// The repositories file is used by older versions of docker, mapping
+	// each tag to the ID of the top most layer.
+	repositories := make(map[string]map[string]string)
+	for _, n := range named {
+		entry.RepoTags = append(entry.RepoTags, dref.FamiliarString(n))
+
+		repo := dref.FamiliarName(n)
+		if repositories[repo] == nil {
+			repositories[repo] = make(map[string]string)
+		}
+		repositories[repo][n.Tag()] = topLayerID
+	}
+
+	manifestData, err := json.Marshal([]dockerArchiveManifest{entry})
+	if err != nil {
+		return err
+	}
+
+	err = writeTarFile(tw, "manifest.json", manifestData)
+	if err != nil {
+		return err
+	}
+
+	if len(repositories) > 0 {
+		repositoriesData, err := json.Marshal(repositories)
+		if err != nil {
+			return err
+		}
+
+		err = writeTarFile(tw, "repositories", repositoriesData)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// writeDockerArchiveLayer writes the uncompressed layer as
+// "<diffID>/layer.tar" and returns its diffID and path. The layer is spooled
+// to a temporary file as the uncompressed size must be known for the tar
+// header.
+func writeDockerArchiveLayer(ctx context.Context, provider content.Provider, tw *tar.Writer, layer ocispec.Descriptor, written map[digest.Digest]bool) (digest.Digest, string, error) {
+	lr, err := DecompressedLayerReader(ctx, provider, layer)
+	if err != nil {
+		return "", "", err
+	}
+	defer lr.Close()
+
+	tmp, err := os.CreateTemp("", "ocitool-layer-*.tar")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	desc, err := CopyAndCreateDescriptor(lr, tmp)
+	if err != nil {
+		return "", "", err
+	}
+
+	path := desc.Digest.Encoded() + "/layer.tar"
+	if written[desc.Digest] {
+		return desc.Digest, path, nil
+	}
+	written[desc.Digest] = true
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:     desc.Digest.Encoded() + "/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:     path,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     desc.Size,
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = io.Copy(tw, tmp)
+	if err != nil {
+		return "", "", err
+	}
+
+	return desc.Digest, path, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+	return err
+}
@@ -0,0 +1,155 @@
+package ociutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// dockerArchive builds a minimal `docker save` tarball with one image per
+// entry of repoTags, each with a single empty layer.
+func dockerArchive(t *testing.T, repoTags ...[]string) []byte {
+	t.Helper()
+
+	var layer bytes.Buffer
+	ltw := tar.NewWriter(&layer)
+	ltw.WriteHeader(&tar.Header{Name: "hello.txt", Mode: 0644, Size: 5})
+	ltw.Write([]byte("hello"))
+	ltw.Close()
+	layerDigest := digest.FromBytes(layer.Bytes())
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	writeFile := func(name string, data []byte) {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		tw.Write(data)
+	}
+
+	writeFile("layer/layer.tar", layer.Bytes())
+
+	type manifestEntry struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	var manifest []manifestEntry
+	for i, tags := range repoTags {
+		cfg, _ := json.Marshal(ocispec.Image{
+			Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+			Author:   string(rune('a' + i)),
+			RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{layerDigest}},
+		})
+		cfgName := digest.FromBytes(cfg).Encoded() + ".json"
+		writeFile(cfgName, cfg)
+
+		manifest = append(manifest, manifestEntry{
+			Config:   cfgName,
+			RepoTags: tags,
+			Layers:   []string{"layer/layer.tar"},
+		})
+	}
+
+	manifestData, _ := json.Marshal(manifest)
+	writeFile("manifest.json", manifestData)
+	tw.Close()
+
+	return buf.Bytes()
+}
+
+func TestImportArchive(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name     string
+		repoTags [][]string
+		repoTag  string
+		err      error
+	}{
+		{name: "single image", repoTags: [][]string{{"foo:1"}}},
+		{name: "single image by tag", repoTags: [][]string{{"foo:1", "foo:2"}}, repoTag: "foo:2"},
+		{name: "normalized tag", repoTags: [][]string{{"foo:1"}, {"bar:1"}}, repoTag: "docker.io/library/bar:1"},
+		{name: "ambiguous", repoTags: [][]string{{"foo:1"}, {"bar:1"}}, err: ErrAmbiguousArchiveImage},
+		{name: "missing tag", repoTags: [][]string{{"foo:1"}}, repoTag: "bar:1", err: ErrNoArchiveImage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := local.NewStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			desc, err := ImportArchive(ctx, store, bytes.NewReader(dockerArchive(t, tc.repoTags...)), tc.repoTag)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected error %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !images.IsManifestType(desc.MediaType) {
+				t.Fatalf("expected a manifest, got %q", desc.MediaType)
+			}
+			if desc.Platform == nil || desc.Platform.Architecture != "amd64" {
+				t.Fatalf("expected platform to be recovered from config, got %v", desc.Platform)
+			}
+			if _, ok := desc.Annotations[ocispec.AnnotationRefName]; ok {
+				t.Fatalf("expected ref name annotation to be stripped, got %v", desc.Annotations)
+			}
+			if desc.Annotations[images.AnnotationImageName] == "" {
+				t.Fatalf("expected image name annotation to be kept, got %v", desc.Annotations)
+			}
+
+			manifest, err := ImageManifestFromProvider(ctx, store, desc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(manifest.Layers) != 1 {
+				t.Fatalf("expected 1 layer, got %d", len(manifest.Layers))
+			}
+		})
+	}
+}
+
+func TestWriteDockerArchiveRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc, err := ImportArchive(ctx, store, bytes.NewReader(dockerArchive(t, []string{"foo:1"})), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = WriteDockerArchive(ctx, store, &buf, desc, []string{"foo/bar:dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roundTripStore, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roundTripDesc, err := ImportArchive(ctx, roundTripStore, &buf, "foo/bar:dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if roundTripDesc.Digest != desc.Digest {
+		t.Fatalf("expected manifest %v after round trip, got %v", desc.Digest, roundTripDesc.Digest)
+	}
+}
@@ -19,6 +19,14 @@ import (
 // TODO Ideally, this should actually be a content.WalkFunc, but ocilayout doesn't
 // implement this interface yet
 func GenerateBuildFilesHandler(handler images.HandlerFunc, layoutRoot string, provider content.Provider) images.HandlerFunc {
+	return GenerateBuildFilesHandlerWithRemotes(handler, layoutRoot, provider, nil)
+}
+
+// GenerateBuildFilesHandlerWithRemotes is GenerateBuildFilesHandler, where the
+// blobs missing from the layout are added to the layout index as remote blobs
+// if they're in remotes, a map of their digest to the repository they can be
+// fetched from.
+func GenerateBuildFilesHandlerWithRemotes(handler images.HandlerFunc, layoutRoot string, provider content.Provider, remotes map[digest.Digest]string) images.HandlerFunc {
 	blobBuildFiles := make(map[digest.Algorithm]*rule.File)
 	var writemx sync.Mutex
 
@@ -57,6 +65,9 @@ func GenerateBuildFilesHandler(handler images.HandlerFunc, layoutRoot string, pr
 	// Duplicate rules make bazel sad.
 	handledBlobs := make([]string, 0)
 
+	// The blobs missing from the layout that can be fetched, by digest.
+	remoteBlobs := make(map[string]string)
+
 	return func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
 		writemx.Lock()
 		defer writemx.Unlock()
@@ -67,6 +78,18 @@ func GenerateBuildFilesHandler(handler images.HandlerFunc, layoutRoot string, pr
 		}
 
 		if !blobExists(layoutRoot, desc.Digest) {
+			repo, ok := remotes[desc.Digest]
+			if !ok {
+				return nil, images.ErrSkipDesc
+			}
+
+			remoteBlobs[desc.Digest.String()] = repo
+			indexRule.SetAttr("remote_blobs", remoteBlobs)
+			err := layoutBuild.Save(filepath.Join(layoutRoot, "BUILD.bazel"))
+			if err != nil {
+				return nil, err
+			}
+
 			return nil, images.ErrSkipDesc
 		}
 
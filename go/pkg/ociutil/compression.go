@@ -1,8 +1,13 @@
 package ociutil
 
 import (
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+
+	"github.com/DataDog/zstd"
 )
 
 type Compression int
@@ -20,13 +25,24 @@ func DetectCompression(path string) (Compression, error) {
 	}
 	defer f.Close()
 
-	// Read up to 4 bytes for magic numbers
-	var hdr [4]byte
-	n, err := f.Read(hdr[:])
+	compression, err := DetectCompressionFromReader(f)
 	if err != nil {
 		return CompressionNone, fmt.Errorf("failed to read %s: %w", path, err)
 	}
 
+	return compression, nil
+}
+
+// DetectCompressionFromReader detects the compression from the magic number
+// at the start of r, consuming up to 4 bytes.
+func DetectCompressionFromReader(r io.Reader) (Compression, error) {
+	// Read up to 4 bytes for magic numbers
+	var hdr [4]byte
+	n, err := io.ReadFull(r, hdr[:])
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return CompressionNone, err
+	}
+
 	// gzip: 1F 8B
 	if n >= 2 && hdr[0] == 0x1F && hdr[1] == 0x8B {
 		return CompressionGzip, nil
@@ -40,3 +56,15 @@ func DetectCompression(path string) (Compression, error) {
 
 	return CompressionNone, nil
 }
+
+// Decompress returns a reader of the uncompressed content of r.
+func Decompress(r io.Reader, compression Compression) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		return zstd.NewReader(r), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
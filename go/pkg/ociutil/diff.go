@@ -8,6 +8,7 @@ import (
 
 	"github.com/DataDog/zstd"
 	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
@@ -20,24 +21,49 @@ func GetLayerDiffID(ctx context.Context, store content.Store, desc ocispec.Descr
 		return desc.Digest, nil
 	}
 
-	r, err := store.ReaderAt(ctx, desc)
+	cr, err := DecompressedLayerReader(ctx, store, desc)
 	if err != nil {
-		return "", fmt.Errorf("failed to get reader for layer: %w", err)
+		return "", err
 	}
-	defer r.Close()
+	defer cr.Close()
 
-	var cr io.Reader
+	return digest.SHA256.FromReader(cr)
+}
+
+// DecompressedLayerReader returns a reader over the uncompressed tar stream of
+// a layer, the compression is chosen by the media type of the descriptor.
+func DecompressedLayerReader(ctx context.Context, provider content.Provider, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	r, err := provider.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reader for layer: %w", err)
+	}
+
+	var cr io.ReadCloser
 	switch desc.MediaType {
-	case ocispec.MediaTypeImageLayerGzip:
+	case ocispec.MediaTypeImageLayerGzip, ocispec.MediaTypeImageLayerNonDistributableGzip, images.MediaTypeDockerSchema2LayerGzip, images.MediaTypeDockerSchema2LayerForeignGzip:
 		cr, err = gzip.NewReader(&readerAtReader{ReaderAt: r})
 		if err != nil {
-			return "", fmt.Errorf("failed to get gzip reader for layer: %w", err)
+			r.Close()
+			return nil, fmt.Errorf("failed to get gzip reader for layer: %w", err)
 		}
-	case ocispec.MediaTypeImageLayerZstd:
+	case ocispec.MediaTypeImageLayerZstd, ocispec.MediaTypeImageLayerNonDistributableZstd:
 		cr = zstd.NewReader(&readerAtReader{ReaderAt: r})
+	default:
+		cr = io.NopCloser(&readerAtReader{ReaderAt: r})
 	}
 
-	return digest.SHA256.FromReader(cr)
+	return &layerReadCloser{ReadCloser: cr, ra: r}, nil
+}
+
+// layerReadCloser closes both the decompressor and the underlying reader.
+type layerReadCloser struct {
+	io.ReadCloser
+	ra content.ReaderAt
+}
+
+func (l *layerReadCloser) Close() error {
+	l.ReadCloser.Close()
+	return l.ra.Close()
 }
 
 type readerAtReader struct {
@@ -2,15 +2,75 @@ package ociutil
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/fs"
 	"sync"
 
 	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
 
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+var (
+	ErrNoLayoutRef        = fmt.Errorf("no matching ref name in layout")
+	ErrAmbiguousLayoutRef = fmt.Errorf("layout contains several images, a ref name is required")
+)
+
+// IsOciLayout reports whether fsys is an OCI Image Layout, based on the
+// presence of the oci-layout file.
+func IsOciLayout(fsys fs.FS) bool {
+	_, err := fs.Stat(fsys, OciLayoutFileName)
+	return err == nil
+}
+
+// OciLayoutIndex reads the index.json of the OCI Image Layout in fsys.
+func OciLayoutIndex(fsys fs.FS) (ocispec.Index, error) {
+	data, err := fs.ReadFile(fsys, OciIndexFileName)
+	if err != nil {
+		return ocispec.Index{}, fmt.Errorf("couldn't read layout index: %w", err)
+	}
+
+	var index ocispec.Index
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return ocispec.Index{}, fmt.Errorf("couldn't parse layout index: %w", err)
+	}
+
+	return index, nil
+}
+
+// ResolveOciLayoutRef returns the descriptor from the index.json of the OCI
+// Image Layout in fsys with the given ref name. If refName is empty, the
+// index must contain a single descriptor.
+func ResolveOciLayoutRef(fsys fs.FS, refName string) (ocispec.Descriptor, error) {
+	index, err := OciLayoutIndex(fsys)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	if refName == "" {
+		switch len(index.Manifests) {
+		case 0:
+			return ocispec.Descriptor{}, fmt.Errorf("%w: layout index is empty", ErrNoLayoutRef)
+		case 1:
+			return index.Manifests[0], nil
+		default:
+			return ocispec.Descriptor{}, ErrAmbiguousLayoutRef
+		}
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.Annotations[ocispec.AnnotationRefName] == refName || desc.Annotations[images.AnnotationImageName] == refName {
+			return desc, nil
+		}
+	}
+
+	return ocispec.Descriptor{}, fmt.Errorf("%w: %q", ErrNoLayoutRef, refName)
+}
+
 type iofsProvider struct {
 	FS fs.FS
 }
@@ -0,0 +1,58 @@
+package ociutil
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestOciLayoutFS(t *testing.T) {
+	data := []byte("hello world")
+	desc := func(refName string) ocispec.Descriptor {
+		return ocispec.Descriptor{
+			MediaType:   ocispec.MediaTypeImageManifest,
+			Digest:      digest.FromBytes(data),
+			Size:        int64(len(data)),
+			Annotations: map[string]string{ocispec.AnnotationRefName: refName},
+		}
+	}
+
+	index, err := json.Marshal(ocispec.Index{
+		Manifests: []ocispec.Descriptor{desc("foo"), desc("bar")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{
+		OciLayoutFileName: {Data: []byte(OciLayoutFileContent)},
+		OciIndexFileName:  {Data: index},
+		"blobs/sha256/" + digest.FromBytes(data).Encoded(): {Data: data},
+	}
+
+	if !IsOciLayout(fsys) {
+		t.Fatal("expected fs to be detected as an OCI layout")
+	}
+
+	got, err := ResolveOciLayoutRef(fsys, "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Annotations[ocispec.AnnotationRefName] != "bar" {
+		t.Fatalf("expected descriptor for ref bar, got %v", got)
+	}
+
+	_, err = ResolveOciLayoutRef(fsys, "")
+	if !errors.Is(err, ErrAmbiguousLayoutRef) {
+		t.Fatalf("expected ambiguous ref error, got %v", err)
+	}
+
+	_, err = ResolveOciLayoutRef(fsys, "baz")
+	if !errors.Is(err, ErrNoLayoutRef) {
+		t.Fatalf("expected missing ref error, got %v", err)
+	}
+}
@@ -0,0 +1,336 @@
+package ociutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// AnnotationArtifactDescription is the layer annotation holding the Bazel
+// label the layer was built by.
+const AnnotationArtifactDescription = "org.opencontainers.artifact.created"
+
+// FsckProblem is an inconsistency found in a blob by Fsck.
+type FsckProblem struct {
+	Digest digest.Digest
+	// Label is the Bazel label the blob came from, empty if unknown.
+	Label string
+	Err   error
+}
+
+func (p FsckProblem) String() string {
+	if p.Label == "" {
+		return fmt.Sprintf("%v: %v", p.Digest, p.Err)
+	}
+
+	return fmt.Sprintf("%v (%v): %v", p.Digest, p.Label, p.Err)
+}
+
+// Fsck checks all of the content reachable from roots: the size and digest of
+// every blob, that indexes, manifests and configs parse and have the fields
+// required by the OCI schemas, that the diffIDs of configs match the
+// decompressed layers, and that no blob is missing.
+//
+// Problems with the content are returned rather than failing the check, the
+// error is only set if the check itself couldn't be run.
+func Fsck(ctx context.Context, provider content.Provider, roots ...ocispec.Descriptor) ([]FsckProblem, error) {
+	f := &fsck{
+		provider: provider,
+		seen:     make(map[digest.Digest]bool),
+		configs:  make(map[digest.Digest]ocispec.Image),
+		diffIDs:  make(map[digest.Digest]digest.Digest),
+	}
+
+	for _, root := range roots {
+		err := f.check(ctx, root, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return f.problems, nil
+}
+
+type fsck struct {
+	provider content.Provider
+	seen     map[digest.Digest]bool
+	configs  map[digest.Digest]ocispec.Image
+	diffIDs  map[digest.Digest]digest.Digest
+	problems []FsckProblem
+}
+
+// labelOf returns the Bazel label of desc, or label if it has none.
+func labelOf(desc ocispec.Descriptor, label string) string {
+	if l, ok := desc.Annotations[AnnotationArtifactDescription]; ok {
+		return l
+	}
+
+	return label
+}
+
+func (f *fsck) report(desc ocispec.Descriptor, label string, format string, args ...interface{}) {
+	f.problems = append(f.problems, FsckProblem{
+		Digest: desc.Digest,
+		Label:  label,
+		Err:    fmt.Errorf(format, args...),
+	})
+}
+
+// check verifies desc and its children, label is the Bazel label of the
+// closest parent that has one.
+func (f *fsck) check(ctx context.Context, desc ocispec.Descriptor, label string) error {
+	label = labelOf(desc, label)
+
+	if images.IsConfigType(desc.MediaType) {
+		f.config(ctx, desc, label)
+		return nil
+	}
+
+	if f.seen[desc.Digest] {
+		return nil
+	}
+	f.seen[desc.Digest] = true
+
+	if err := desc.Digest.Validate(); err != nil {
+		f.report(desc, label, "invalid digest: %v", err)
+		return nil
+	}
+
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		var index ocispec.Index
+		if !f.checkJSON(ctx, desc, label, &index) {
+			return nil
+		}
+		if !f.checkVersioned(desc, label, index.SchemaVersion, index.MediaType) {
+			return nil
+		}
+
+		for _, child := range index.Manifests {
+			if !f.checkDescriptor(desc, label, child) {
+				continue
+			}
+
+			err := f.check(ctx, child, label)
+			if err != nil {
+				return err
+			}
+		}
+	case images.IsManifestType(desc.MediaType):
+		return f.checkManifest(ctx, desc, label)
+	default:
+		f.checkBlob(ctx, desc, label)
+	}
+
+	return nil
+}
+
+func (f *fsck) checkManifest(ctx context.Context, desc ocispec.Descriptor, label string) error {
+	var manifest ocispec.Manifest
+	if !f.checkJSON(ctx, desc, label, &manifest) {
+		return nil
+	}
+	if !f.checkVersioned(desc, label, manifest.SchemaVersion, manifest.MediaType) {
+		return nil
+	}
+
+	if !f.checkDescriptor(desc, label, manifest.Config) {
+		return nil
+	}
+
+	config, configOK := f.config(ctx, manifest.Config, label)
+
+	for _, layer := range manifest.Layers {
+		if !f.checkDescriptor(desc, label, layer) || f.seen[layer.Digest] {
+			continue
+		}
+		f.seen[layer.Digest] = true
+
+		layerLabel := labelOf(layer, label)
+		if !f.checkBlob(ctx, layer, layerLabel) || !images.IsLayerType(layer.MediaType) {
+			continue
+		}
+
+		diffID, err := f.diffID(ctx, layer)
+		if err != nil {
+			f.report(layer, layerLabel, "failed to decompress layer: %v", err)
+			continue
+		}
+		f.diffIDs[layer.Digest] = diffID
+	}
+
+	if !configOK {
+		return nil
+	}
+
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		f.report(manifest.Config, label, "config has %d diffIDs but manifest %v has %d layers", len(config.RootFS.DiffIDs), desc.Digest, len(manifest.Layers))
+		return nil
+	}
+
+	// Layers that couldn't be read were already reported.
+	for i, layer := range manifest.Layers {
+		diffID, ok := f.diffIDs[layer.Digest]
+		if !ok {
+			continue
+		}
+
+		if config.RootFS.DiffIDs[i] != diffID {
+			f.report(layer, labelOf(layer, label), "diffID %v of layer %d doesn't match %v in config %v", diffID, i, config.RootFS.DiffIDs[i], manifest.Config.Digest)
+		}
+	}
+
+	return nil
+}
+
+// config verifies and parses an image config, configs are only checked once.
+func (f *fsck) config(ctx context.Context, desc ocispec.Descriptor, label string) (ocispec.Image, bool) {
+	if config, ok := f.configs[desc.Digest]; ok {
+		return config, true
+	}
+	if f.seen[desc.Digest] {
+		// Already reported.
+		return ocispec.Image{}, false
+	}
+	f.seen[desc.Digest] = true
+
+	var config ocispec.Image
+	if !f.checkJSON(ctx, desc, label, &config) || !f.checkConfig(desc, label, config) {
+		return ocispec.Image{}, false
+	}
+	f.configs[desc.Digest] = config
+
+	return config, true
+}
+
+func (f *fsck) diffID(ctx context.Context, desc ocispec.Descriptor) (digest.Digest, error) {
+	r, err := DecompressedLayerReader(ctx, f.provider, desc)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	return digest.SHA256.FromReader(r)
+}
+
+// checkBlob verifies the size and digest of a blob.
+func (f *fsck) checkBlob(ctx context.Context, desc ocispec.Descriptor, label string) bool {
+	ra, err := f.provider.ReaderAt(ctx, desc)
+	if errdefs.IsNotFound(err) {
+		// Foreign layers don't have to be available locally.
+		if len(desc.URLs) > 0 {
+			return false
+		}
+
+		f.report(desc, label, "missing blob")
+		return false
+	} else if err != nil {
+		f.report(desc, label, "failed to open blob: %v", err)
+		return false
+	}
+	defer ra.Close()
+
+	digester := desc.Digest.Algorithm().Digester()
+	n, err := io.Copy(digester.Hash(), content.NewReader(ra))
+	if err != nil {
+		f.report(desc, label, "failed to read blob: %v", err)
+		return false
+	}
+
+	ok := true
+	if n != desc.Size {
+		f.report(desc, label, "size is %d, expected %d", n, desc.Size)
+		ok = false
+	}
+
+	if dgst := digester.Digest(); dgst != desc.Digest {
+		f.report(desc, label, "content has digest %v", dgst)
+		ok = false
+	}
+
+	return ok
+}
+
+// checkJSON verifies a blob and decodes it into v.
+func (f *fsck) checkJSON(ctx context.Context, desc ocispec.Descriptor, label string, v interface{}) bool {
+	if !f.checkBlob(ctx, desc, label) {
+		return false
+	}
+
+	data, err := content.ReadBlob(ctx, f.provider, desc)
+	if err != nil {
+		f.report(desc, label, "failed to read blob: %v", err)
+		return false
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		f.report(desc, label, "failed to parse %v: %v", desc.MediaType, err)
+		return false
+	}
+
+	return true
+}
+
+func (f *fsck) checkVersioned(desc ocispec.Descriptor, label string, schemaVersion int, mediaType string) bool {
+	if schemaVersion != 2 {
+		f.report(desc, label, "unsupported schemaVersion %d", schemaVersion)
+		return false
+	}
+
+	if mediaType != "" && mediaType != desc.MediaType {
+		f.report(desc, label, "mediaType %q doesn't match descriptor mediaType %q", mediaType, desc.MediaType)
+		return false
+	}
+
+	return true
+}
+
+// checkDescriptor verifies the required fields of a descriptor referenced by
+// parent.
+func (f *fsck) checkDescriptor(parent ocispec.Descriptor, label string, desc ocispec.Descriptor) bool {
+	if desc.MediaType == "" {
+		f.report(parent, label, "descriptor for %v has no mediaType", desc.Digest)
+		return false
+	}
+
+	if err := desc.Digest.Validate(); err != nil {
+		f.report(parent, label, "descriptor has invalid digest %q: %v", desc.Digest, err)
+		return false
+	}
+
+	if desc.Size < 0 {
+		f.report(parent, label, "descriptor for %v has negative size %d", desc.Digest, desc.Size)
+		return false
+	}
+
+	return true
+}
+
+func (f *fsck) checkConfig(desc ocispec.Descriptor, label string, config ocispec.Image) bool {
+	if config.OS == "" || config.Architecture == "" {
+		f.report(desc, label, "config is missing os or architecture")
+		return false
+	}
+
+	if config.RootFS.Type != "layers" {
+		f.report(desc, label, "config has rootfs type %q, expected \"layers\"", config.RootFS.Type)
+		return false
+	}
+
+	for _, diffID := range config.RootFS.DiffIDs {
+		if err := diffID.Validate(); err != nil {
+			f.report(desc, label, "config has invalid diffID %q: %v", diffID, err)
+			return false
+		}
+	}
+
+	return true
+}
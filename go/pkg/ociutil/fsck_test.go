@@ -0,0 +1,77 @@
+package ociutil
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispecv "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestFsck(t *testing.T) {
+	ctx := context.Background()
+
+	store := newTestStore(t)
+
+	layer := store.writeBlob(ocispec.MediaTypeImageLayer, []byte("layer"), map[string]string{
+		AnnotationArtifactDescription: "//foo:layer",
+	})
+
+	image := func(diffID digest.Digest, layers ...ocispec.Descriptor) ocispec.Descriptor {
+		config := store.writeJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+			Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+			RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{diffID}},
+		})
+
+		return store.writeJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned: ocispecv.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    layers,
+		})
+	}
+
+	problems, err := Fsck(ctx, store, image(layer.Digest, layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+
+	missing := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromString("missing"),
+		Size:      7,
+	}
+
+	for _, tc := range []struct {
+		name    string
+		root    ocispec.Descriptor
+		problem string
+	}{
+		{
+			name:    "diffID mismatch",
+			root:    image(digest.FromString("other"), layer),
+			problem: layer.Digest.String() + " (//foo:layer): diffID",
+		},
+		{
+			name:    "missing blob",
+			root:    image(missing.Digest, missing),
+			problem: missing.Digest.String() + ": missing blob",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			problems, err := Fsck(ctx, store, tc.root)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(problems) != 1 || !strings.HasPrefix(problems[0].String(), tc.problem) {
+				t.Fatalf("expected a problem starting with %q, got %v", tc.problem, problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package ociutil
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// GraphNode is a blob of a descriptor graph.
+type GraphNode struct {
+	Descriptor ocispec.Descriptor
+	// Roots are the indexes of the roots the blob is reachable from.
+	Roots []int
+}
+
+// Shared reports whether the blob is reachable from more than one root.
+func (n *GraphNode) Shared() bool {
+	return len(n.Roots) > 1
+}
+
+// GraphEdge is a reference from a blob to one of its children.
+type GraphEdge struct {
+	From digest.Digest
+	To   digest.Digest
+}
+
+// Graph is the DAG of blobs reachable from roots, e.g. indexes to manifests
+// to configs and layers.
+type Graph struct {
+	Roots []ocispec.Descriptor
+	// Nodes are in the order they were found, from the roots in order.
+	Nodes []*GraphNode
+	Edges []GraphEdge
+
+	nodes map[digest.Digest]*GraphNode
+	edges map[GraphEdge]bool
+}
+
+// Node returns the node of a blob, or nil if it isn't in the graph.
+func (g *Graph) Node(dgst digest.Digest) *GraphNode {
+	return g.nodes[dgst]
+}
+
+// DescriptorGraph walks the children of roots, as returned by
+// images.ChildrenHandler, and returns the resulting graph.
+func DescriptorGraph(ctx context.Context, provider content.Provider, roots ...ocispec.Descriptor) (*Graph, error) {
+	g := &Graph{
+		Roots: roots,
+		nodes: make(map[digest.Digest]*GraphNode),
+		edges: make(map[GraphEdge]bool),
+	}
+
+	children := images.ChildrenHandler(provider)
+	for i, root := range roots {
+		err := g.walk(ctx, children, root, i, make(map[digest.Digest]bool))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+func (g *Graph) walk(ctx context.Context, handler images.HandlerFunc, desc ocispec.Descriptor, root int, visited map[digest.Digest]bool) error {
+	if visited[desc.Digest] {
+		return nil
+	}
+	visited[desc.Digest] = true
+
+	node, ok := g.nodes[desc.Digest]
+	if !ok {
+		node = &GraphNode{
+			Descriptor: desc,
+		}
+		g.nodes[desc.Digest] = node
+		g.Nodes = append(g.Nodes, node)
+	} else {
+		// Keep the annotations given by all of the parents, e.g. the Bazel
+		// label of a layer.
+		for k, v := range desc.Annotations {
+			if _, ok := node.Descriptor.Annotations[k]; !ok {
+				if node.Descriptor.Annotations == nil {
+					node.Descriptor.Annotations = make(map[string]string)
+				}
+				node.Descriptor.Annotations[k] = v
+			}
+		}
+	}
+	node.Roots = append(node.Roots, root)
+
+	children, err := handler(ctx, desc)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		edge := GraphEdge{From: desc.Digest, To: child.Digest}
+		if !g.edges[edge] {
+			g.edges[edge] = true
+			g.Edges = append(g.Edges, edge)
+		}
+
+		err = g.walk(ctx, handler, child, root, visited)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package ociutil
+
+import (
+	"context"
+	"testing"
+
+	ocispecv "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestDescriptorGraph(t *testing.T) {
+	ctx := context.Background()
+
+	store := newTestStore(t)
+
+	base := store.writeBlob(ocispec.MediaTypeImageLayer, []byte("base"), map[string]string{
+		AnnotationArtifactDescription: "//base:layer",
+	})
+
+	image := func(name string) ocispec.Descriptor {
+		config := store.writeJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+			Platform: ocispec.Platform{OS: "linux", Architecture: name},
+		})
+
+		return store.writeJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned: ocispecv.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    []ocispec.Descriptor{base, store.writeBlob(ocispec.MediaTypeImageLayer, []byte(name), nil)},
+		})
+	}
+
+	a, b := image("amd64"), image("arm64")
+	index := store.writeJSON(ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned: ocispecv.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{a},
+	})
+
+	graph, err := DescriptorGraph(ctx, store, index, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The index, 2 manifests, 2 configs and 3 layers.
+	if len(graph.Nodes) != 8 {
+		t.Fatalf("expected 8 nodes, got %d", len(graph.Nodes))
+	}
+	if len(graph.Edges) != 7 {
+		t.Fatalf("expected 7 edges, got %d", len(graph.Edges))
+	}
+
+	for _, node := range graph.Nodes {
+		if shared := node.Descriptor.Digest == base.Digest; node.Shared() != shared {
+			t.Errorf("expected %v to be shared: %v, got %v", node.Descriptor.Digest, shared, node.Shared())
+		}
+	}
+
+	if label := graph.Node(base.Digest).Descriptor.Annotations[AnnotationArtifactDescription]; label != "//base:layer" {
+		t.Fatalf("expected the Bazel label of the base layer, got %q", label)
+	}
+}
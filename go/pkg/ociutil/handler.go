@@ -2,11 +2,13 @@ package ociutil
 
 import (
 	"context"
+	"sync"
 
 	"github.com/DataDog/rules_oci/go/internal/set"
 
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -54,6 +56,26 @@ func copyContentFromHandler(ctx context.Context, handler images.HandlerFunc, fro
 	return nil
 }
 
+// Reachable returns the digests of roots and of all of their descendants, as
+// returned by images.ChildrenHandler.
+func Reachable(ctx context.Context, provider content.Provider, roots ...ocispec.Descriptor) (map[digest.Digest]bool, error) {
+	var mx sync.Mutex
+	reachable := make(map[digest.Digest]bool)
+
+	err := images.Walk(ctx, images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		mx.Lock()
+		reachable[desc.Digest] = true
+		mx.Unlock()
+
+		return images.ChildrenHandler(provider)(ctx, desc)
+	}), roots...)
+	if err != nil {
+		return nil, err
+	}
+
+	return reachable, nil
+}
+
 // ContentTypesFilterHandler filters the children of the handler to only include
 // the listed content types
 func ContentTypesFilterHandler(handler images.HandlerFunc, contentTypes ...string) images.HandlerFunc {
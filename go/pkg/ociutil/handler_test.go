@@ -0,0 +1,77 @@
+package ociutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispecv "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestReachable(t *testing.T) {
+	ctx := context.Background()
+
+	store := newTestStore(t)
+
+	shared := store.writeBlob(ocispec.MediaTypeImageLayer, []byte("shared"), nil)
+	image := func(name string) (ocispec.Descriptor, []ocispec.Descriptor) {
+		layer := store.writeBlob(ocispec.MediaTypeImageLayer, []byte(name), nil)
+		config := store.writeJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+			Platform: ocispec.Platform{OS: "linux", Architecture: name},
+		})
+		manifest := store.writeJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned: ocispecv.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    []ocispec.Descriptor{shared, layer},
+		})
+
+		return manifest, []ocispec.Descriptor{manifest, config, layer}
+	}
+
+	amd64, amd64Blobs := image("amd64")
+	arm64, arm64Blobs := image("arm64")
+	_, otherBlobs := image("other")
+
+	index := store.writeJSON(ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned: ocispecv.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{amd64},
+	})
+
+	reachable, err := Reachable(ctx, store, index, arm64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[digest.Digest]bool{index.Digest: true, shared.Digest: true}
+	for _, desc := range append(amd64Blobs, arm64Blobs...) {
+		expected[desc.Digest] = true
+	}
+
+	if len(reachable) != len(expected) {
+		t.Errorf("expected %d reachable blobs, got %d: %v", len(expected), len(reachable), reachable)
+	}
+	for dgst := range expected {
+		if !reachable[dgst] {
+			t.Errorf("expected %v to be reachable", dgst)
+		}
+	}
+	for _, desc := range otherBlobs {
+		if reachable[desc.Digest] {
+			t.Errorf("expected %v not to be reachable", desc.Digest)
+		}
+	}
+
+	// A missing manifest can't be walked.
+	missing := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("missing"),
+		Size:      7,
+	}
+	_, err = Reachable(ctx, store, missing)
+	if err == nil {
+		t.Fatal("expected an error for a missing manifest")
+	}
+}
@@ -0,0 +1,60 @@
+package ociutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testStore is a content store that the tests write their blobs to.
+type testStore struct {
+	content.Store
+
+	t testing.TB
+}
+
+func newTestStore(t testing.TB) *testStore {
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testStore{Store: store, t: t}
+}
+
+// writeBlob writes data and returns its descriptor.
+func (s *testStore) writeBlob(mediaType string, data []byte, annotations map[string]string) ocispec.Descriptor {
+	s.t.Helper()
+
+	desc := ocispec.Descriptor{
+		MediaType:   mediaType,
+		Digest:      digest.FromBytes(data),
+		Size:        int64(len(data)),
+		Annotations: annotations,
+	}
+
+	err := content.WriteBlob(context.Background(), s, desc.Digest.String(), bytes.NewReader(data), desc)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	return desc
+}
+
+// writeJSON writes v encoded as JSON and returns its descriptor.
+func (s *testStore) writeJSON(mediaType string, v interface{}) ocispec.Descriptor {
+	s.t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	return s.writeBlob(mediaType, data, nil)
+}
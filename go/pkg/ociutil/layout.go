@@ -0,0 +1,71 @@
+package ociutil
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// IsOciLayoutDir reports whether path is an OCI Image Layout directory, rather
+// than a blob index file.
+func IsOciLayoutDir(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+
+	return IsOciLayout(os.DirFS(path))
+}
+
+// LoadLayout loads the blobs of the blob index file or OCI Image Layout
+// directory at path.
+func LoadLayout(path string) (*blob.Index, error) {
+	if IsOciLayoutDir(path) {
+		return blob.LoadIndexFromOciLayout(path)
+	}
+
+	provider, err := blob.LoadIndexFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.(*blob.Index), nil
+}
+
+// ResolveLayoutRoot returns the image with the given name in the blob index
+// file or OCI Image Layout directory at path, a root name of blob indexes and
+// a ref name of layouts. If name is empty, there must be a single image.
+func ResolveLayoutRoot(path, name string) (ocispec.Descriptor, error) {
+	if IsOciLayoutDir(path) {
+		return ResolveOciLayoutRef(os.DirFS(path), name)
+	}
+
+	provider, err := blob.LoadIndexFromFile(path)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	roots := provider.(*blob.Index).Roots
+
+	if name == "" {
+		switch len(roots) {
+		case 0:
+			return ocispec.Descriptor{}, fmt.Errorf("%w: blob index has no roots", ErrNoLayoutRef)
+		case 1:
+			for _, desc := range roots {
+				return desc, nil
+			}
+		default:
+			return ocispec.Descriptor{}, ErrAmbiguousLayoutRef
+		}
+	}
+
+	desc, ok := roots[name]
+	if !ok {
+		return ocispec.Descriptor{}, fmt.Errorf("%w: %q", ErrNoLayoutRef, name)
+	}
+
+	return desc, nil
+}
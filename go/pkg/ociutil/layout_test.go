@@ -0,0 +1,105 @@
+package ociutil
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/blob"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestResolveLayoutRoot(t *testing.T) {
+	dir := t.TempDir()
+
+	data := []byte("hello world")
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+
+	// A layout with a single image, and a blob index with two.
+	layoutPath := filepath.Join(dir, "layout")
+	blobPath := filepath.Join(layoutPath, "blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+	err := os.MkdirAll(filepath.Dir(blobPath), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(blobPath, data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(layoutPath, OciLayoutFileName), []byte(OciLayoutFileContent), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layoutDesc := desc
+	layoutDesc.Annotations = map[string]string{ocispec.AnnotationRefName: "app"}
+	index, err := json.Marshal(ocispec.Index{Manifests: []ocispec.Descriptor{layoutDesc}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(layoutPath, OciIndexFileName), index, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bi := &blob.Index{}
+	bi.Add(desc, blobPath)
+	bi.AddRoot("app", desc)
+	bi.AddRoot("base", desc)
+	indexPath := filepath.Join(dir, "image.blob-index.json")
+	err = bi.WriteToFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsOciLayoutDir(layoutPath) {
+		t.Errorf("expected %v to be an OCI layout", layoutPath)
+	}
+	if IsOciLayoutDir(indexPath) {
+		t.Errorf("expected %v not to be an OCI layout", indexPath)
+	}
+
+	for _, path := range []string{layoutPath, indexPath} {
+		loaded, err := LoadLayout(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := loaded.Blobs[desc.Digest]; !ok {
+			t.Errorf("expected the blobs of %v to contain %v, got %v", path, desc.Digest, loaded.Blobs)
+		}
+
+		got, err := ResolveLayoutRoot(path, "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Digest != desc.Digest {
+			t.Errorf("expected the root app of %v to be %v, got %v", path, desc.Digest, got.Digest)
+		}
+
+		_, err = ResolveLayoutRoot(path, "other")
+		if !errors.Is(err, ErrNoLayoutRef) {
+			t.Errorf("expected a missing ref error for %v, got %v", path, err)
+		}
+	}
+
+	got, err := ResolveLayoutRoot(layoutPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Digest != desc.Digest {
+		t.Errorf("expected the only image of the layout to be %v, got %v", desc.Digest, got.Digest)
+	}
+
+	_, err = ResolveLayoutRoot(indexPath, "")
+	if !errors.Is(err, ErrAmbiguousLayoutRef) {
+		t.Errorf("expected an ambiguous ref error, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package ociutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// LinkMode is how a blob that is already a file is materialized in a layout.
+type LinkMode string
+
+const (
+	// LinkCopy copies the content of the blob.
+	LinkCopy LinkMode = "copy"
+	// LinkHardlink creates a hard link to the blob file.
+	LinkHardlink LinkMode = "hardlink"
+	// LinkReflink clones the blob file, sharing its extents on filesystems
+	// supporting it, e.g. Btrfs and XFS.
+	LinkReflink LinkMode = "reflink"
+	// LinkSymlink creates a symbolic link to the absolute path of the blob
+	// file.
+	LinkSymlink LinkMode = "symlink"
+	// LinkAuto reflinks or hard links the blob file, whichever works first.
+	LinkAuto LinkMode = "auto"
+)
+
+// LinkModes are the valid link modes.
+var LinkModes = []LinkMode{LinkCopy, LinkHardlink, LinkReflink, LinkSymlink, LinkAuto}
+
+// ParseLinkMode parses a link mode, an empty string is LinkCopy.
+func ParseLinkMode(s string) (LinkMode, error) {
+	if s == "" {
+		return LinkCopy, nil
+	}
+
+	for _, mode := range LinkModes {
+		if LinkMode(s) == mode {
+			return mode, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown link mode %q, expected one of %v", s, LinkModes)
+}
+
+// LinkFile materializes the file src at dst with mode. It doesn't fall back to
+// copying: an error wrapping errdefs.ErrNotImplemented is returned when the
+// mode isn't supported, e.g. when src and dst are on different filesystems,
+// and dst is left untouched.
+func LinkFile(src, dst string, mode LinkMode) error {
+	// Link the actual file, e.g. not the symlinks of a Bazel sandbox.
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case LinkHardlink:
+		err = os.Link(src, dst)
+	case LinkReflink:
+		err = reflink(src, dst)
+	case LinkSymlink:
+		src, err = filepath.Abs(src)
+		if err != nil {
+			return err
+		}
+		err = os.Symlink(src, dst)
+	case LinkAuto:
+		err = LinkFile(src, dst, LinkReflink)
+		if errors.Is(err, errdefs.ErrNotImplemented) {
+			err = LinkFile(src, dst, LinkHardlink)
+		}
+		return err
+	case LinkCopy, "":
+		return copyFile(src, dst)
+	default:
+		return fmt.Errorf("unknown link mode %q", mode)
+	}
+
+	if err != nil && linkUnsupported(err) {
+		return fmt.Errorf("can't %s %v to %v: %v: %w", mode, src, dst, err, errdefs.ErrNotImplemented)
+	}
+
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, ContentFileMode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return out.Close()
+}
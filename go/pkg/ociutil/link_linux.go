@@ -0,0 +1,44 @@
+package ociutil
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink clones src to dst with the FICLONE ioctl.
+func reflink(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, ContentFileMode)
+	if err != nil {
+		return err
+	}
+
+	err = unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return &os.LinkError{Op: "reflink", Old: src, New: dst, Err: err}
+	}
+
+	return out.Close()
+}
+
+// linkUnsupported reports whether a link failed because the filesystems don't
+// support it, rather than because of the files.
+func linkUnsupported(err error) bool {
+	return errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EPERM) ||
+		errors.Is(err, unix.EMLINK) ||
+		errors.Is(err, unix.EOPNOTSUPP) ||
+		errors.Is(err, unix.ENOTSUP) ||
+		errors.Is(err, unix.ENOSYS) ||
+		errors.Is(err, unix.ENOTTY) ||
+		errors.Is(err, unix.EINVAL)
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package ociutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// reflink isn't supported outside of Linux.
+func reflink(src, dst string) error {
+	return fmt.Errorf("reflink isn't supported on this platform: %w", errdefs.ErrNotImplemented)
+}
+
+// linkUnsupported reports whether a link failed because the filesystems don't
+// support it, rather than because of the files.
+func linkUnsupported(err error) bool {
+	return errors.Is(err, syscall.EXDEV) || errors.Is(err, os.ErrPermission)
+}
@@ -0,0 +1,176 @@
+package ociutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestLinkFile(t *testing.T) {
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "src")
+	err := os.WriteFile(src, []byte("blob"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Links go to the actual file, not to the symlinks in front of it.
+	srcLink := filepath.Join(dir, "src-link")
+	err = os.Symlink(src, srcLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []LinkMode{LinkCopy, LinkHardlink, LinkSymlink, LinkAuto} {
+		t.Run(string(mode), func(t *testing.T) {
+			dst := filepath.Join(dir, string(mode))
+
+			err := LinkFile(srcLink, dst, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "blob" {
+				t.Errorf("expected %q, got %q", "blob", data)
+			}
+
+			dstInfo, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Auto either reflinks or hard links depending on the filesystem.
+			if same := os.SameFile(srcInfo, dstInfo); mode != LinkAuto && same != (mode == LinkHardlink || mode == LinkSymlink) {
+				t.Errorf("expected same file to be %v", !same)
+			}
+
+			if mode == LinkSymlink {
+				target, err := os.Readlink(dst)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if target != src {
+					t.Errorf("expected symlink to %v, got %v", src, target)
+				}
+			}
+		})
+	}
+
+	_, err = ParseLinkMode("clone")
+	if err == nil {
+		t.Error("expected an error for an unknown link mode")
+	}
+}
+
+func TestOciImageLayoutIngesterLinkBlob(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	data := []byte("layer")
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+
+	src := filepath.Join(dir, "layer.tar")
+	err := os.WriteFile(src, data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := fileProvider{desc.Digest: src}
+
+	for _, mode := range []LinkMode{LinkCopy, LinkHardlink} {
+		t.Run(string(mode), func(t *testing.T) {
+			ing, err := NewOciImageLayoutIngester(filepath.Join(dir, string(mode)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ing.LinkMode = mode
+
+			err = CopyContent(ctx, provider, ing, desc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			blobInfo, err := os.Stat(descToFilePath(ing.Path, desc.Digest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same := os.SameFile(srcInfo, blobInfo); same != (mode == LinkHardlink) {
+				t.Errorf("expected same file to be %v", !same)
+			}
+
+			// Blobs already in the layout are kept.
+			err = CopyContent(ctx, provider, ing, desc)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		ing, err := NewOciImageLayoutIngester(filepath.Join(dir, "digest-mismatch"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ing.LinkMode = LinkHardlink
+
+		// The file has the size of the blob, but not its content.
+		other := filepath.Join(dir, "other.tar")
+		err = os.WriteFile(other, []byte("LAYER"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = CopyContent(ctx, fileProvider{desc.Digest: other}, ing, desc)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		_, err = os.Stat(descToFilePath(ing.Path, desc.Digest))
+		if !os.IsNotExist(err) {
+			t.Errorf("expected no blob, got %v", err)
+		}
+	})
+
+	t.Run("size mismatch", func(t *testing.T) {
+		ing, err := NewOciImageLayoutIngester(filepath.Join(dir, "mismatch"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ing.LinkMode = LinkHardlink
+
+		// The blob is copied instead, which reports the mismatch.
+		wrongDesc := desc
+		wrongDesc.Size++
+		err = CopyContent(ctx, fileProvider{desc.Digest: src}, ing, wrongDesc)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		_, err = os.Stat(descToFilePath(ing.Path, desc.Digest))
+		if !os.IsNotExist(err) {
+			t.Errorf("expected no blob, got %v", err)
+		}
+	})
+}
@@ -1,8 +1,11 @@
 package ociutil
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/platforms"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
@@ -22,3 +25,32 @@ func ManifestFromIndex(manifest ocispec.Index, platform platforms.MatchComparer)
 
 	return ocispec.Descriptor{}, fmt.Errorf("no matching manifest for platform")
 }
+
+// ResolveManifest resolves a descriptor that is either an image index or an
+// image manifest into an image manifest. Indexes are resolved to the manifest
+// that matches the desired platform, manifests without a platform have it
+// resolved from their image config.
+func ResolveManifest(ctx context.Context, provider content.Provider, desc ocispec.Descriptor, platform platforms.MatchComparer) (ocispec.Descriptor, error) {
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		index, err := ImageIndexFromProvider(ctx, provider, desc)
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+
+		return ManifestFromIndex(index, platform)
+	case images.IsManifestType(desc.MediaType):
+		if IsEmptyPlatform(desc.Platform) {
+			plat, err := ResolvePlatformFromDescriptor(ctx, provider, desc)
+			if err != nil {
+				return ocispec.Descriptor{}, fmt.Errorf("no platform for manifest: %w", err)
+			}
+
+			desc.Platform = &plat
+		}
+
+		return desc, nil
+	default:
+		return ocispec.Descriptor{}, fmt.Errorf("unknown image type %q", desc.MediaType)
+	}
+}
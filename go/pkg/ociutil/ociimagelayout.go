@@ -2,18 +2,26 @@ package ociutil
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/DataDog/rules_oci/go/pkg/jsonutil"
+
 	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/opencontainers/go-digest"
+	ocispecv "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 const BlobsFolderName = "blobs"
-const OciImageIndexMediaType = "application/vnd.oci.image.index.v1+json"
 const OciLayoutFileName = "oci-layout"
 const OciLayoutFileContent = `{
     "imageLayoutVersion": "1.0.0"
@@ -21,23 +29,138 @@ const OciLayoutFileContent = `{
 const OciIndexFileName = "index.json"
 const ContentFileMode = 0755
 
+// ingestFolderName is the directory of the layout that blobs are written to
+// before they are verified.
+const ingestFolderName = "ingest"
+
 // OciImageLayoutIngester implements functionality to write data to an OCI
 // Image Layout directory (https://github.com/opencontainers/image-spec/blob/main/image-layout.md)
+//
+// All content is written to the blobs directory, the entries of index.json
+// are added with AddReference and written by SaveIndex.
 type OciImageLayoutIngester struct {
 	// The path of the directory containing the OCI Image Layout.
 	Path string
+	// LinkMode is how blobs that are already files are materialized by
+	// LinkBlob, they are copied by default.
+	LinkMode LinkMode
+
+	index ocispec.Index
+	// active are the blobs being written, their channel is closed when the
+	// write is committed or abandoned.
+	active map[digest.Digest]chan struct{}
+	mx     sync.Mutex
 }
 
-func NewOciImageLayoutIngester(path string) (OciImageLayoutIngester, error) {
+// NewOciImageLayoutIngester creates an ingester for the layout at path. If the
+// layout already has an index.json its entries are kept, so that images can
+// be added to an existing layout.
+func NewOciImageLayoutIngester(path string) (*OciImageLayoutIngester, error) {
 	if err := os.MkdirAll(path, ContentFileMode); err != nil {
-		return OciImageLayoutIngester{}, fmt.Errorf("error creating directory for OciImageLayoutIngester: %v, Err: %w", path, err)
+		return nil, fmt.Errorf("error creating directory for OciImageLayoutIngester: %v, Err: %w", path, err)
+	}
+
+	ing := &OciImageLayoutIngester{
+		Path: path,
+		index: ocispec.Index{
+			Versioned: ocispecv.Versioned{
+				SchemaVersion: 2,
+			},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: []ocispec.Descriptor{},
+		},
 	}
-	return OciImageLayoutIngester{Path: path}, nil
+
+	err := jsonutil.DecodeFromFile(filepath.Join(path, OciIndexFileName), &ing.index)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading existing index of layout: %v, Err: %w", path, err)
+	}
+
+	return ing, nil
 }
 
-// writer returns a Writer object that will write one entity to the OCI Image Layout.
-// Examples are OCI Image Index, an OCI Image Manifest, an OCI Image Config,
-// and OCI image TAR/GZIP files.
+// AddReference adds desc as an entry of index.json. If refName is set it is
+// used as the org.opencontainers.image.ref.name of the entry and replaces any
+// existing entry with the same ref name; entries without a ref name replace
+// existing entries with the same digest and no ref name.
+func (ing *OciImageLayoutIngester) AddReference(desc ocispec.Descriptor, refName string) {
+	ing.mx.Lock()
+	defer ing.mx.Unlock()
+
+	if refName != "" {
+		annotations := make(map[string]string, len(desc.Annotations)+1)
+		for k, v := range desc.Annotations {
+			annotations[k] = v
+		}
+		annotations[ocispec.AnnotationRefName] = refName
+		desc.Annotations = annotations
+	}
+	refName = desc.Annotations[ocispec.AnnotationRefName]
+
+	manifests := ing.index.Manifests[:0]
+	for _, m := range ing.index.Manifests {
+		existingRefName := m.Annotations[ocispec.AnnotationRefName]
+		if refName != "" && existingRefName == refName {
+			continue
+		}
+		if refName == "" && existingRefName == "" && m.Digest == desc.Digest {
+			continue
+		}
+
+		manifests = append(manifests, m)
+	}
+
+	ing.index.Manifests = append(manifests, desc)
+}
+
+// SaveIndex writes the oci-layout and index.json files of the layout.
+func (ing *OciImageLayoutIngester) SaveIndex() error {
+	ing.mx.Lock()
+	defer ing.mx.Unlock()
+
+	layoutFile := path.Join(ing.Path, OciLayoutFileName)
+	if err := os.WriteFile(layoutFile, []byte(OciLayoutFileContent), ContentFileMode); err != nil {
+		return fmt.Errorf("error writing oci-layout file: %v, Err: %w", layoutFile, err)
+	}
+
+	ing.RemoveIngestDir()
+
+	data, err := json.Marshal(ing.index)
+	if err != nil {
+		return err
+	}
+
+	indexFile := path.Join(ing.Path, OciIndexFileName)
+	if err := os.WriteFile(indexFile, data, ContentFileMode); err != nil {
+		return fmt.Errorf("error writing index file: %v, Err: %w", indexFile, err)
+	}
+
+	return nil
+}
+
+// RemoveIngestDir removes the directory of the blobs being written, unless
+// writes were interrupted, so that they can be resumed. It's called by
+// SaveIndex, it only needs to be called directly when the layout has no
+// index.json, e.g. when it's described by a blob index instead.
+func (ing *OciImageLayoutIngester) RemoveIngestDir() {
+	os.Remove(filepath.Join(ing.Path, ingestFolderName))
+}
+
+// Writer returns a Writer object that will write one blob to the OCI Image
+// Layout. Examples are OCI Image Index, an OCI Image Manifest, an OCI Image
+// Config, and OCI image TAR/GZIP files.
+//
+// The blob is streamed into a file in the ingest directory of the layout and
+// only moved into the blobs directory once it has been verified by Commit. If
+// a previous write of the same blob was interrupted, the writer resumes from
+// the partially written file.
+//
+// Concurrent writers of the same blob wait for the first one to be committed
+// or closed, e.g. when images sharing layers are written in parallel.
+//
+// An error wrapping errdefs.ErrAlreadyExists is returned when the blob is
+// already in the layout. Existing blobs are trusted based on their size, their
+// content isn't verified.
 func (ing *OciImageLayoutIngester) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
 	// Initialize the writer options (for those unfamiliar with this pattern, it's known as the
 	// "functional options pattern").
@@ -47,113 +170,237 @@ func (ing *OciImageLayoutIngester) Writer(ctx context.Context, opts ...content.W
 			return nil, fmt.Errorf("unable to apply WriterOpt to WriterOpts. Err: %w", err)
 		}
 	}
-	status := content.Status{
-		Ref:      wOpts.Ref,
-		Offset:   0,
-		Total:    wOpts.Desc.Size,
-		Expected: wOpts.Desc.Digest,
+
+	dgst := wOpts.Desc.Digest
+	if err := dgst.Validate(); err != nil {
+		return nil, fmt.Errorf("OciImageLayoutIngester: must have digest: %w", err)
 	}
-	return &OciImageLayoutWriter{Path: ing.Path, Opts: wOpts, Stat: status}, nil
-}
 
-type OciImageLayoutWriter struct {
-	Path string
-	Opts content.WriterOpts
-	Dig  digest.Digest
-	Stat content.Status
+	blobPath := descToFilePath(ing.Path, dgst)
+	err := ing.acquire(ctx, wOpts.Desc)
+	if err != nil {
+		return nil, err
+	}
+
+	ingestDir := filepath.Join(ing.Path, ingestFolderName)
+	if err := os.MkdirAll(ingestDir, ContentFileMode); err != nil {
+		ing.release(dgst)
+		return nil, fmt.Errorf("error creating ingestDir: %v, Err: %w", ingestDir, err)
+	}
+
+	ingestPath := filepath.Join(ingestDir, dgst.Algorithm().String()+"-"+dgst.Encoded())
+	f, err := os.OpenFile(ingestPath, os.O_RDWR|os.O_CREATE, ContentFileMode)
+	if err != nil {
+		ing.release(dgst)
+		return nil, fmt.Errorf("error opening file for write: %v, Err: %w", ingestPath, err)
+	}
+
+	now := time.Now()
+	w := &OciImageLayoutWriter{
+		Path: ing.Path,
+		Opts: wOpts,
+		Stat: content.Status{
+			Ref:       wOpts.Ref,
+			Total:     wOpts.Desc.Size,
+			Expected:  dgst,
+			StartedAt: now,
+			UpdatedAt: now,
+		},
+		ing:        ing,
+		f:          f,
+		ingestPath: ingestPath,
+		blobPath:   blobPath,
+	}
+
+	// Resume from whatever was written by a previous, interrupted, writer.
+	fi, err := f.Stat()
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	err = w.Truncate(fi.Size())
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return w, nil
 }
 
-// Writes bytes to a file, using the provided write flags.
-func writeFile(filePath string, writeFlags int, b []byte) error {
-	f, err := os.OpenFile(filePath, writeFlags, ContentFileMode)
+// LinkBlob materializes the file at path as the blob of desc, with the link
+// mode of the ingester. It returns an error wrapping errdefs.ErrNotImplemented
+// when the blob can't be linked and should be copied instead, e.g. when the
+// file is on another filesystem.
+//
+// The file is verified against the size and digest of desc before it's
+// linked, a mismatch falls back to the copy, which reports it. Like with
+// Writer, a blob already in the layout with the size of desc is kept.
+func (ing *OciImageLayoutIngester) LinkBlob(ctx context.Context, desc ocispec.Descriptor, path string) error {
+	if ing.LinkMode == "" || ing.LinkMode == LinkCopy {
+		return fmt.Errorf("blob %v is copied: %w", desc.Digest, errdefs.ErrNotImplemented)
+	}
+
+	dgst := desc.Digest
+	if err := dgst.Validate(); err != nil {
+		return fmt.Errorf("OciImageLayoutIngester: must have digest: %w", err)
+	}
+
+	fi, err := os.Stat(path)
 	if err != nil {
-		return fmt.Errorf("error opening file for write: %v, Err: %w", filePath, err)
+		return err
+	}
+	if fi.Size() != desc.Size {
+		// Let the copy report the mismatch.
+		return fmt.Errorf("blob file %v has size %d, expected %d: %w", path, fi.Size(), desc.Size, errdefs.ErrNotImplemented)
 	}
-	defer f.Close()
 
-	if _, err = f.Write(b); err != nil {
-		return fmt.Errorf("error writing file: %v, Err: %w", filePath, err)
+	actual, err := fileDigest(path, dgst.Algorithm())
+	if err != nil {
+		return err
+	}
+	if actual != dgst {
+		return fmt.Errorf("blob file %v has digest %v, expected %v: %w", path, actual, dgst, errdefs.ErrNotImplemented)
+	}
+
+	blobPath := descToFilePath(ing.Path, dgst)
+	err = ing.acquire(ctx, desc)
+	if errdefs.IsAlreadyExists(err) {
+		return nil
+	} else if err != nil {
+		return err
 	}
+	defer ing.release(dgst)
+
+	ingestDir := filepath.Join(ing.Path, ingestFolderName)
+	if err := os.MkdirAll(ingestDir, ContentFileMode); err != nil {
+		return fmt.Errorf("error creating ingestDir: %v, Err: %w", ingestDir, err)
+	}
+
+	// Link into the ingest directory first, so that the blob is moved into
+	// place atomically.
+	linkPath := filepath.Join(ingestDir, dgst.Algorithm().String()+"-"+dgst.Encoded()+".link")
+	os.Remove(linkPath)
+
+	err = LinkFile(path, linkPath, ing.LinkMode)
+	if err != nil {
+		return err
+	}
+
+	blobDir := filepath.Dir(blobPath)
+	if err := os.MkdirAll(blobDir, ContentFileMode); err != nil {
+		os.Remove(linkPath)
+		return fmt.Errorf("error creating blobDir: %v, Err: %w", blobDir, err)
+	}
+
+	if err := os.Rename(linkPath, blobPath); err != nil {
+		os.Remove(linkPath)
+		return fmt.Errorf("error moving blob into place: %v, Err: %w", blobPath, err)
+	}
+
 	return nil
 }
 
-func (w *OciImageLayoutWriter) Write(b []byte) (n int, err error) {
-	firstWrite := w.Stat.StartedAt.IsZero()
-	if firstWrite {
-		w.Stat.StartedAt = time.Now()
-	}
-
-	// A function to get the OS flags used to create a writeable file.
-	getWriteFlags := func(filePath string) (int, error) {
-		_, err := os.Stat(filePath)
-		switch {
-		case firstWrite && err == nil:
-			// The file exists and it's first write; Truncate it.
-			return os.O_WRONLY | os.O_TRUNC, nil
-		case err == nil:
-			// The file exists and it's not first write; append to it.
-			return os.O_WRONLY | os.O_APPEND, nil
-		case errors.Is(err, os.ErrNotExist):
-			// The file doesn't exist. Create it.
-			return os.O_WRONLY | os.O_CREATE, nil
-		default:
-			// Something went wrong!
-			return 0, err
-		}
+// fileDigest returns the digest of the file at path.
+func fileDigest(path string, alg digest.Algorithm) (digest.Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
 	}
+	defer f.Close()
 
-	var filePath string
-	if w.Opts.Desc.MediaType == OciImageIndexMediaType {
-		// This is an OCI Image Index. It gets written to the top level index.json file.
-		// Write the oci-layout file (a simple canned file required by the standard).
-		layoutFile := path.Join(w.Path, OciLayoutFileName)
-		// It's possible (but unlikely) for Write to be called repeatedly for an index file.
-		// In that case, we'll repeatedly rewrite the oci-layout file, which doesn't hurt,
-		// because the content is always identical.
-		if err := os.WriteFile(layoutFile, []byte(OciLayoutFileContent), ContentFileMode); err != nil {
-			return 0, fmt.Errorf("error writing oci-layout file: %v, Err: %w", layoutFile, err)
-		}
+	return alg.FromReader(f)
+}
 
-		// Now write the index.json file.
-		filePath = path.Join(w.Path, OciIndexFileName)
-		writeFlags, err := getWriteFlags(filePath)
-		if err != nil {
-			return 0, fmt.Errorf("error stat'ing file: %v, Err: %w", filePath, err)
-		}
-		err = writeFile(filePath, writeFlags, b)
-		if err != nil {
-			return 0, err
-		}
-	} else {
-		// This is a blob. Write it to the blobs folder.
-		algo := w.Opts.Desc.Digest.Algorithm()
-		blobDir := path.Join(w.Path, BlobsFolderName, algo.String())
-		if err := os.MkdirAll(blobDir, ContentFileMode); err != nil {
-			return 0, fmt.Errorf("error creating blobDir: %v, Err: %w", blobDir, err)
-		}
-		filePath = path.Join(blobDir, w.Opts.Desc.Digest.Encoded())
+// acquire marks the blob of desc as being written, waiting for any other
+// write of the blob to be committed or abandoned first. It returns an error
+// wrapping errdefs.ErrAlreadyExists when a blob with the size of desc is
+// already in the layout.
+func (ing *OciImageLayoutIngester) acquire(ctx context.Context, desc ocispec.Descriptor) error {
+	blobPath := descToFilePath(ing.Path, desc.Digest)
+
+	for {
+		ing.mx.Lock()
+		done, ok := ing.active[desc.Digest]
+		if !ok {
+			if fi, err := os.Stat(blobPath); err == nil && fi.Size() == desc.Size {
+				ing.mx.Unlock()
+				return fmt.Errorf("blob %v: %w", desc.Digest, errdefs.ErrAlreadyExists)
+			}
+
+			if ing.active == nil {
+				ing.active = make(map[digest.Digest]chan struct{})
+			}
+			ing.active[desc.Digest] = make(chan struct{})
+			ing.mx.Unlock()
 
-		writeFlags, err := getWriteFlags(filePath)
-		if err != nil {
-			return 0, fmt.Errorf("error stat'ing file: %v, Err: %w", filePath, err)
+			return nil
 		}
+		ing.mx.Unlock()
 
-		// Now write the blob file.
-		err = writeFile(filePath, writeFlags, b)
-		if err != nil {
-			return 0, err
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
-	fInfo, err := os.Stat(filePath)
+}
+
+// release marks a blob as no longer being written, waking up the writers
+// waiting for it.
+func (ing *OciImageLayoutIngester) release(dgst digest.Digest) {
+	ing.mx.Lock()
+	defer ing.mx.Unlock()
+
+	if done, ok := ing.active[dgst]; ok {
+		close(done)
+		delete(ing.active, dgst)
+	}
+}
+
+// OciImageLayoutWriter writes a single blob into an OCI Image Layout.
+type OciImageLayoutWriter struct {
+	Path string
+	Opts content.WriterOpts
+	Dig  digest.Digest
+	Stat content.Status
+
+	ing        *OciImageLayoutIngester
+	f          *os.File
+	digester   digest.Digester
+	ingestPath string
+	blobPath   string
+}
+
+func (w *OciImageLayoutWriter) Write(b []byte) (n int, err error) {
+	if w.f == nil {
+		return 0, fmt.Errorf("write to closed writer for blob %v: %w", w.Opts.Desc.Digest, errdefs.ErrFailedPrecondition)
+	}
+
+	n, err = w.f.Write(b)
+	w.digester.Hash().Write(b[:n])
+	w.Stat.Offset += int64(n)
+	w.Stat.UpdatedAt = time.Now()
+
 	if err != nil {
-		return 0, fmt.Errorf("error retrieving FileInfo for file: %v, Err: %w", filePath, err)
+		return n, fmt.Errorf("error writing file: %v, Err: %w", w.ingestPath, err)
 	}
-	w.Stat.UpdatedAt = fInfo.ModTime()
-	return len(b), nil
+
+	return n, nil
 }
 
+// Close releases the writer without committing, the partially written blob is
+// kept so that a later writer can resume it.
 func (w *OciImageLayoutWriter) Close() error {
-	return nil
+	if w.f == nil {
+		return nil
+	}
+
+	err := w.f.Close()
+	w.f = nil
+	w.ing.release(w.Opts.Desc.Digest)
+
+	return err
 }
 
 // Returns an empty digest until after Commit is called.
@@ -161,15 +408,80 @@ func (w *OciImageLayoutWriter) Digest() digest.Digest {
 	return w.Dig
 }
 
+// Commit verifies the size and digest of the written content, then syncs it
+// to disk and atomically moves it into the blobs directory.
 func (w *OciImageLayoutWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
-	w.Dig = w.Opts.Desc.Digest
+	if w.f == nil {
+		return fmt.Errorf("commit of closed writer for blob %v: %w", w.Opts.Desc.Digest, errdefs.ErrFailedPrecondition)
+	}
+	defer w.Close()
+
+	if size <= 0 {
+		size = w.Opts.Desc.Size
+	}
+	if expected == "" {
+		expected = w.Opts.Desc.Digest
+	}
+
+	if w.Stat.Offset != size {
+		w.discard()
+		return fmt.Errorf("unexpected commit size %d for blob %v, expected %d: %w", w.Stat.Offset, expected, size, errdefs.ErrFailedPrecondition)
+	}
+
+	if dgst := w.digester.Digest(); dgst != expected {
+		w.discard()
+		return fmt.Errorf("unexpected commit digest %v, expected %v: %w", dgst, expected, errdefs.ErrFailedPrecondition)
+	}
+
+	if err := w.f.Sync(); err != nil {
+		return fmt.Errorf("error syncing file: %v, Err: %w", w.ingestPath, err)
+	}
+
+	blobDir := filepath.Dir(w.blobPath)
+	if err := os.MkdirAll(blobDir, ContentFileMode); err != nil {
+		return fmt.Errorf("error creating blobDir: %v, Err: %w", blobDir, err)
+	}
+
+	if err := os.Rename(w.ingestPath, w.blobPath); err != nil {
+		return fmt.Errorf("error moving blob into place: %v, Err: %w", w.blobPath, err)
+	}
+
+	w.Dig = expected
+
 	return nil
 }
 
+// discard removes the ingest file of content that failed verification.
+func (w *OciImageLayoutWriter) discard() {
+	os.Remove(w.ingestPath)
+}
+
 func (w *OciImageLayoutWriter) Status() (content.Status, error) {
 	return w.Stat, nil
 }
 
+// Truncate truncates the written content to size, re-reading the remaining
+// content to restore the digest.
 func (w *OciImageLayoutWriter) Truncate(size int64) error {
-	return errors.New("truncation is unsupported")
+	if w.f == nil {
+		return fmt.Errorf("truncate of closed writer for blob %v: %w", w.Opts.Desc.Digest, errdefs.ErrFailedPrecondition)
+	}
+
+	if err := w.f.Truncate(size); err != nil {
+		return fmt.Errorf("error truncating file: %v, Err: %w", w.ingestPath, err)
+	}
+
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	w.digester = w.Opts.Desc.Digest.Algorithm().Digester()
+	if _, err := io.CopyN(w.digester.Hash(), w.f, size); err != nil {
+		return fmt.Errorf("error reading file: %v, Err: %w", w.ingestPath, err)
+	}
+
+	w.Stat.Offset = size
+	w.Stat.UpdatedAt = time.Now()
+
+	return nil
 }
@@ -0,0 +1,298 @@
+package ociutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/DataDog/rules_oci/go/pkg/jsonutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestOciImageLayoutWriter(t *testing.T) {
+	ctx := context.Background()
+
+	data := []byte("hello world")
+	desc := ocispec.Descriptor{
+		Digest: digest.FromBytes(data),
+		Size:   int64(len(data)),
+	}
+
+	t.Run("write", func(t *testing.T) {
+		ing, err := NewOciImageLayoutIngester(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = content.WriteBlob(ctx, ing, "write", bytes.NewReader(data), desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := os.ReadFile(descToFilePath(ing.Path, desc.Digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("expected blob %q, got %q", data, got)
+		}
+
+		err = content.WriteBlob(ctx, ing, "write", bytes.NewReader(data), desc)
+		if err != nil {
+			t.Fatalf("expected rewrite of existing blob to succeed, got %v", err)
+		}
+	})
+
+	t.Run("reject mismatch", func(t *testing.T) {
+		ing, err := NewOciImageLayoutIngester(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bad := []byte("goodbye world")
+		err = content.WriteBlob(ctx, ing, "mismatch", bytes.NewReader(bad), ocispec.Descriptor{
+			Digest: desc.Digest,
+			Size:   int64(len(bad)),
+		})
+		if !errdefs.IsFailedPrecondition(err) {
+			t.Fatalf("expected digest mismatch, got %v", err)
+		}
+
+		if _, err := os.Stat(descToFilePath(ing.Path, desc.Digest)); !os.IsNotExist(err) {
+			t.Fatalf("expected no blob to be written, got %v", err)
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		ing, err := NewOciImageLayoutIngester(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, err := ing.Writer(ctx, content.WithRef("first"), content.WithDescriptor(desc))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The other writers wait for the first one, which is abandoned
+		// half-way.
+		var wg sync.WaitGroup
+		errs := make(chan error, 4)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- content.WriteBlob(ctx, ing, "concurrent", bytes.NewReader(data), desc)
+			}()
+		}
+
+		_, err = w.Write(data[:5])
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("expected concurrent writes to succeed, got %v", err)
+			}
+		}
+
+		got, err := os.ReadFile(descToFilePath(ing.Path, desc.Digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("expected blob %q, got %q", data, got)
+		}
+	})
+
+	t.Run("wait canceled", func(t *testing.T) {
+		ing, err := NewOciImageLayoutIngester(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, err := ing.Writer(ctx, content.WithRef("first"), content.WithDescriptor(desc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err = ing.Writer(canceled, content.WithRef("second"), content.WithDescriptor(desc))
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the wait to be canceled, got %v", err)
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		ing, err := NewOciImageLayoutIngester(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, err := ing.Writer(ctx, content.WithRef("resume"), content.WithDescriptor(desc))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = w.Write(data[:5])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, err = ing.Writer(ctx, content.WithRef("resume"), content.WithDescriptor(desc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		status, err := w.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Offset != 5 {
+			t.Fatalf("expected writer to resume at offset 5, got %d", status.Offset)
+		}
+
+		err = content.Copy(ctx, w, bytes.NewReader(data), desc.Size, desc.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := os.ReadFile(descToFilePath(ing.Path, desc.Digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("expected blob %q, got %q", data, got)
+		}
+	})
+}
+
+func TestOciImageLayoutAddReference(t *testing.T) {
+	manifest := func(s string) ocispec.Descriptor {
+		return ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    digest.FromString(s),
+			Size:      int64(len(s)),
+		}
+	}
+
+	// indexEntries returns the "<ref name>@<digest>" of the index.json entries.
+	indexEntries := func(t *testing.T, dir string) []string {
+		var index ocispec.Index
+		err := jsonutil.DecodeFromFile(filepath.Join(dir, OciIndexFileName), &index)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var entries []string
+		for _, desc := range index.Manifests {
+			entries = append(entries, desc.Annotations[ocispec.AnnotationRefName]+"@"+desc.Digest.String())
+		}
+
+		return entries
+	}
+
+	expectEntries := func(t *testing.T, dir string, expected ...string) {
+		t.Helper()
+
+		got := indexEntries(t, dir)
+		if len(got) != len(expected) {
+			t.Fatalf("expected index entries %v, got %v", expected, got)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("expected index entries %v, got %v", expected, got)
+			}
+		}
+	}
+
+	a, b, c := manifest("a"), manifest("b"), manifest("c")
+
+	t.Run("replace", func(t *testing.T) {
+		ing, err := NewOciImageLayoutIngester(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ing.AddReference(a, "app")
+		ing.AddReference(b, "base")
+		ing.AddReference(c, "")
+		ing.AddReference(c, "")
+		// Replaces a, and keeps the annotations of c unchanged.
+		ing.AddReference(c, "app")
+
+		err = ing.SaveIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectEntries(t, ing.Path, "base@"+b.Digest.String(), "@"+c.Digest.String(), "app@"+c.Digest.String())
+		if c.Annotations != nil {
+			t.Fatalf("expected the descriptor to be unchanged, got %v", c.Annotations)
+		}
+	})
+
+	t.Run("merge existing", func(t *testing.T) {
+		dir := t.TempDir()
+
+		ing, err := NewOciImageLayoutIngester(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ing.AddReference(a, "app")
+		ing.AddReference(b, "base")
+		err = ing.SaveIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ing, err = NewOciImageLayoutIngester(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ing.AddReference(c, "app")
+		err = ing.SaveIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectEntries(t, dir, "base@"+b.Digest.String(), "app@"+c.Digest.String())
+	})
+
+	t.Run("invalid existing index", func(t *testing.T) {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, OciIndexFileName), []byte("{"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = NewOciImageLayoutIngester(dir)
+		if err == nil {
+			t.Fatal("expected the invalid index.json to be rejected")
+		}
+	})
+}
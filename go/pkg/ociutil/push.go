@@ -93,6 +93,27 @@ func RefToRegistryName(ref string) (string, error) {
 	return dref.Domain(n), nil
 }
 
+// RefToRepository will return the repository of a reference string, without
+// its tag or digest.
+func RefToRepository(ref string) (string, error) {
+	n, err := NamedRef(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return dref.TrimNamed(n).String(), nil
+}
+
+// FetchBlob fetches the blob of desc from the repository repo.
+func (resolver Resolver) FetchBlob(ctx context.Context, repo string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	fetcher, err := resolver.Fetcher(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return fetcher.Fetch(ctx, desc)
+}
+
 // PushBlob pushes a singluar blob to a registry.
 func (resolver Resolver) PushBlob(ctx context.Context, path, ref, mediaType string) (ocispec.Descriptor, error) {
 	pusher, err := resolver.Pusher(ctx, ref)
@@ -240,6 +261,12 @@ func (resolver Resolver) MarshalAndPushContent(ctx context.Context, ref string,
 	return desc, nil
 }
 
+// BlobLinker is an ingester that can materialize blobs that are already files
+// without copying them, see OciImageLayoutIngester.LinkBlob.
+type BlobLinker interface {
+	LinkBlob(ctx context.Context, desc ocispec.Descriptor, path string) error
+}
+
 // CopyContent copies a descriptor from a provider to an ingestor interfaces
 // provider by "containerd/content". Useful when you want to copy between
 // layouts or when pulling an image via oras.ProviderWrapper
@@ -276,6 +303,23 @@ func CopyContent(ctx context.Context, from content.Provider, to content.Ingester
 	if err != nil {
 		return fmt.Errorf("failed to create reader from provider. Descriptor: %+v; Error: %w", desc, err)
 	}
+	defer reader.Close()
+
+	// Blobs read from files, e.g. from a blob index, can be linked rather than
+	// copied.
+	if linker, ok := to.(BlobLinker); ok {
+		if f, ok := reader.(interface{ Name() string }); ok {
+			err = linker.LinkBlob(ctx, desc, f.Name())
+			if err == nil {
+				logCtx.Debugf("skipped copy, linked blob from %q", f.Name())
+				return nil
+			}
+			if !errors.Is(err, errdefs.ErrNotImplemented) {
+				return fmt.Errorf("failed to link blob: %w", err)
+			}
+			logCtx.WithError(err).Debug("couldn't link blob")
+		}
+	}
 
 	ref := desc.Digest.String()
 	if refAnno, ok := desc.Annotations[ocispec.AnnotationRefName]; ok {
@@ -327,6 +371,7 @@ func copyContent(
 	if err != nil {
 		return wrapErr(err)
 	}
+	defer writer.Close()
 
 	err = content.Copy(
 		ctx,
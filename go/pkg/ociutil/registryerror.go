@@ -0,0 +1,37 @@
+package ociutil
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes of the OCI distribution spec.
+const (
+	RegistryErrBlobUnknown         = "BLOB_UNKNOWN"
+	RegistryErrBlobUploadUnknown   = "BLOB_UPLOAD_UNKNOWN"
+	RegistryErrBlobUploadInvalid   = "BLOB_UPLOAD_INVALID"
+	RegistryErrDigestInvalid       = "DIGEST_INVALID"
+	RegistryErrManifestBlobUnknown = "MANIFEST_BLOB_UNKNOWN"
+	RegistryErrManifestInvalid     = "MANIFEST_INVALID"
+	RegistryErrManifestUnknown     = "MANIFEST_UNKNOWN"
+	RegistryErrNameUnknown         = "NAME_UNKNOWN"
+	RegistryErrTooManyRequests     = "TOOMANYREQUESTS"
+	RegistryErrUnauthorized        = "UNAUTHORIZED"
+	RegistryErrUnknown             = "UNKNOWN"
+	RegistryErrUnsupported         = "UNSUPPORTED"
+)
+
+// WriteRegistryError writes an error response of the OCI distribution spec,
+// with a single error.
+func WriteRegistryError(w http.ResponseWriter, status int, code, message string) {
+	type registryError struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Errors []registryError `json:"errors"`
+	}{[]registryError{{Code: code, Message: message}}})
+}
@@ -0,0 +1,146 @@
+package registrytest
+
+import (
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+)
+
+// slowChunkSize is the size of the chunks of the response bodies slowed down
+// by a fault.
+const slowChunkSize = 1024
+
+// Fault is a failure injected in the responses of the registry to the
+// matching requests, see Registry.Inject. Exactly one of Status, Drop and
+// BodyDelay should be set.
+type Fault struct {
+	// Method matches the method of the requests, all methods if empty.
+	Method string
+	// Path is a regular expression matching the path of the requests, e.g.
+	// "/blobs/", all paths if empty.
+	Path string
+	// Count is the number of requests failed, all of them if 0.
+	Count int
+
+	// Status is the status of the error responses, e.g. 503 or 429.
+	Status int
+	// RetryAfter is sent with the error responses if set.
+	RetryAfter time.Duration
+	// Drop closes the connections without responding.
+	Drop bool
+	// BodyDelay is the time waited before each KiB of the response bodies, the
+	// requests being handled normally otherwise.
+	BodyDelay time.Duration
+
+	path *regexp.Regexp
+}
+
+// Inject adds a fault to the registry, the faults are matched in the order
+// they're added. It panics if the path isn't a valid regular expression.
+func (r *Registry) Inject(f Fault) {
+	if f.Path != "" {
+		f.path = regexp.MustCompile(f.Path)
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.faults = append(r.faults, &f)
+}
+
+// matchFault returns the first fault matching req, counting the request
+// against it. r.mx must be held.
+func (r *Registry) matchFault(req *http.Request) *Fault {
+	for i, f := range r.faults {
+		if f.Method != "" && f.Method != req.Method {
+			continue
+		}
+		if f.path != nil && !f.path.MatchString(req.URL.Path) {
+			continue
+		}
+
+		if f.Count > 0 {
+			f.Count--
+			if f.Count == 0 {
+				r.faults = append(r.faults[:i:i], r.faults[i+1:]...)
+			}
+		}
+
+		return f
+	}
+
+	return nil
+}
+
+// apply injects the fault in the response, and returns the writer of the
+// response if the request should still be handled.
+func (f *Fault) apply(w http.ResponseWriter, req *http.Request) (http.ResponseWriter, bool) {
+	switch {
+	case f.Drop:
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			panic("registrytest: the response writer can't be hijacked")
+		}
+
+		conn, _, err := hj.Hijack()
+		if err == nil {
+			conn.Close()
+		}
+
+		return nil, false
+	case f.Status != 0:
+		if f.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(f.RetryAfter.Seconds()))))
+		}
+
+		code := ociutil.RegistryErrUnknown
+		if f.Status == http.StatusTooManyRequests {
+			code = ociutil.RegistryErrTooManyRequests
+		}
+		ociutil.WriteRegistryError(w, f.Status, code, "injected fault")
+
+		return nil, false
+	case f.BodyDelay > 0:
+		return &slowWriter{ResponseWriter: w, req: req, delay: f.BodyDelay}, true
+	default:
+		return w, true
+	}
+}
+
+// slowWriter writes the response body by chunks, waiting before each one.
+type slowWriter struct {
+	http.ResponseWriter
+
+	req   *http.Request
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		select {
+		case <-w.req.Context().Done():
+			return n, w.req.Context().Err()
+		case <-time.After(w.delay):
+		}
+
+		chunk := p[:min(len(p), slowChunkSize)]
+		m, err := w.ResponseWriter.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		p = p[len(chunk):]
+	}
+
+	return n, nil
+}
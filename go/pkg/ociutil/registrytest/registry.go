@@ -0,0 +1,642 @@
+// Package registrytest provides an in-memory OCI distribution registry for
+// tests, with bearer token auth and fault injection.
+package registrytest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// tokenPath is the path of the token endpoint of registries with auth.
+const tokenPath = "/token"
+
+// Registry is an in-memory registry implementing the OCI distribution spec,
+// served over plain HTTP by an httptest server. Repositories are created by
+// the first blob or manifest pushed to them.
+type Registry struct {
+	// Server is the server of the registry, its address is the domain of the
+	// references to the registry, see Host.
+	Server *httptest.Server
+
+	username, password string
+
+	mx       sync.Mutex
+	repos    map[string]*repository
+	uploads  map[string]*upload
+	tokens   map[string]bool
+	faults   []*Fault
+	requests []string
+
+	inFlight, maxInFlight int
+}
+
+type repository struct {
+	blobs     map[digest.Digest][]byte
+	manifests map[digest.Digest]manifest
+	tags      map[string]digest.Digest
+}
+
+type manifest struct {
+	mediaType string
+	data      []byte
+}
+
+type upload struct {
+	repo string
+
+	mx   sync.Mutex
+	data bytes.Buffer
+}
+
+// Option configures a registry.
+type Option func(*Registry)
+
+// WithAuth requires a bearer token for every request to the registry. The
+// tokens are given by the token endpoint of the registry for the username and
+// password, with basic auth or the OAuth2 password grant. Tokens aren't
+// scoped, they give access to every repository.
+func WithAuth(username, password string) Option {
+	return func(r *Registry) {
+		r.username = username
+		r.password = password
+	}
+}
+
+// New starts a registry that is closed at the end of the test.
+func New(t testing.TB, opts ...Option) *Registry {
+	r := &Registry{
+		repos:   make(map[string]*repository),
+		uploads: make(map[string]*upload),
+		tokens:  make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.Server = httptest.NewServer(r)
+	t.Cleanup(r.Server.Close)
+
+	return r
+}
+
+// Host returns the domain of the references to the registry, e.g.
+// r.Host()+"/app:latest".
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.Server.URL, "http://")
+}
+
+// Resolver returns a resolver for the references to the registry, with the
+// credentials of the registry.
+func (r *Registry) Resolver() ociutil.Resolver {
+	client := r.Server.Client()
+
+	// The same host is returned for every call, so that the tokens are
+	// shared between the resolver and Mount.
+	host := docker.RegistryHost{
+		Client: client,
+		Authorizer: docker.NewDockerAuthorizer(
+			docker.WithAuthClient(client),
+			docker.WithAuthCreds(func(string) (string, string, error) {
+				return r.username, r.password, nil
+			}),
+		),
+		Host:         r.Host(),
+		Scheme:       "http",
+		Path:         "/v2",
+		Capabilities: docker.HostCapabilityPull | docker.HostCapabilityResolve | docker.HostCapabilityPush,
+	}
+
+	hosts := func(name string) ([]docker.RegistryHost, error) {
+		if name != host.Host {
+			return nil, fmt.Errorf("unknown registry %v, expected %v", name, host.Host)
+		}
+
+		return []docker.RegistryHost{host}, nil
+	}
+
+	return ociutil.Resolver{
+		Resolver: ociutil.ExtendedResolver(docker.NewResolver(docker.ResolverOptions{Hosts: hosts}), hosts),
+	}
+}
+
+// AddBlob adds a blob to the repository repo.
+func (r *Registry) AddBlob(repo string, data []byte) ocispec.Descriptor {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	dgst := digest.FromBytes(data)
+	r.repo(repo).blobs[dgst] = bytes.Clone(data)
+
+	return ocispec.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    dgst,
+		Size:      int64(len(data)),
+	}
+}
+
+// AddManifest adds a manifest to the repository repo, tagged with tag unless
+// it's empty. The blobs of the manifest don't have to be in the repository.
+func (r *Registry) AddManifest(repo, tag, mediaType string, data []byte) ocispec.Descriptor {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	dgst := digest.FromBytes(data)
+	rep := r.repo(repo)
+	rep.manifests[dgst] = manifest{mediaType: mediaType, data: bytes.Clone(data)}
+	if tag != "" {
+		rep.tags[tag] = dgst
+	}
+
+	return ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(data)),
+	}
+}
+
+// Blob returns the content of a blob of the repository repo, or false if
+// there is no such blob.
+func (r *Registry) Blob(repo string, dgst digest.Digest) ([]byte, bool) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	rep, ok := r.repos[repo]
+	if !ok {
+		return nil, false
+	}
+
+	data, ok := rep.blobs[dgst]
+	return data, ok
+}
+
+// Manifest returns the descriptor and content of the manifest of the
+// repository repo with the tag or digest ref, or false if there is no such
+// manifest.
+func (r *Registry) Manifest(repo, ref string) (ocispec.Descriptor, []byte, bool) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	m, dgst, ok := r.manifest(repo, ref)
+	if !ok {
+		return ocispec.Descriptor{}, nil, false
+	}
+
+	return ocispec.Descriptor{MediaType: m.mediaType, Digest: dgst, Size: int64(len(m.data))}, m.data, true
+}
+
+// Requests returns the requests received by the registry so far, in order,
+// as "<method> <path>". The requests to the token endpoint are included.
+func (r *Registry) Requests() []string {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	return append([]string(nil), r.requests...)
+}
+
+// MaxInFlight returns the maximum number of requests that were handled
+// concurrently so far.
+func (r *Registry) MaxInFlight() int {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	return r.maxInFlight
+}
+
+// repo returns the repository name, creating it if needed. r.mx must be
+// held.
+func (r *Registry) repo(name string) *repository {
+	rep, ok := r.repos[name]
+	if !ok {
+		rep = &repository{
+			blobs:     make(map[digest.Digest][]byte),
+			manifests: make(map[digest.Digest]manifest),
+			tags:      make(map[string]digest.Digest),
+		}
+		r.repos[name] = rep
+	}
+
+	return rep
+}
+
+// manifest returns the manifest of repo with the tag or digest ref. r.mx
+// must be held.
+func (r *Registry) manifest(repo, ref string) (manifest, digest.Digest, bool) {
+	rep, ok := r.repos[repo]
+	if !ok {
+		return manifest{}, "", false
+	}
+
+	dgst, ok := rep.tags[ref]
+	if !ok {
+		dgst = digest.Digest(ref)
+	}
+
+	m, ok := rep.manifests[dgst]
+	return m, dgst, ok
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mx.Lock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+	r.inFlight++
+	r.maxInFlight = max(r.maxInFlight, r.inFlight)
+	fault := r.matchFault(req)
+	r.mx.Unlock()
+
+	defer func() {
+		r.mx.Lock()
+		r.inFlight--
+		r.mx.Unlock()
+	}()
+
+	if fault != nil {
+		var ok bool
+		w, ok = fault.apply(w, req)
+		if !ok {
+			return
+		}
+	}
+
+	if req.URL.Path == tokenPath {
+		r.serveToken(w, req)
+		return
+	}
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	if !r.authorized(req) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%v%v",service="registrytest"`, r.Server.URL, tokenPath))
+		ociutil.WriteRegistryError(w, http.StatusUnauthorized, ociutil.RegistryErrUnauthorized, "authentication required")
+		return
+	}
+
+	p := req.URL.Path
+	if p == "/v2" || p == "/v2/" {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+		return
+	}
+
+	if !strings.HasPrefix(p, "/v2/") {
+		http.NotFound(w, req)
+		return
+	}
+	p = strings.TrimPrefix(p, "/v2/")
+
+	if repo, ok := strings.CutSuffix(p, "/tags/list"); ok {
+		r.serveTags(w, req, repo)
+		return
+	}
+
+	if i := strings.LastIndex(p, "/blobs/uploads/"); i > 0 {
+		r.serveUpload(w, req, p[:i], p[i+len("/blobs/uploads/"):])
+		return
+	}
+
+	if i := strings.LastIndex(p, "/blobs/"); i > 0 {
+		r.serveBlob(w, req, p[:i], p[i+len("/blobs/"):])
+		return
+	}
+
+	if i := strings.LastIndex(p, "/manifests/"); i > 0 {
+		r.serveManifest(w, req, p[:i], p[i+len("/manifests/"):])
+		return
+	}
+
+	http.NotFound(w, req)
+}
+
+func (r *Registry) authorized(req *http.Request) bool {
+	if r.username == "" {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	return r.tokens[token]
+}
+
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	var username, password string
+	switch req.Method {
+	case http.MethodGet:
+		username, password, _ = req.BasicAuth()
+	case http.MethodPost:
+		if req.PostFormValue("grant_type") != "password" {
+			ociutil.WriteRegistryError(w, http.StatusBadRequest, ociutil.RegistryErrUnsupported, "only the password grant is supported")
+			return
+		}
+		username, password = req.PostFormValue("username"), req.PostFormValue("password")
+	default:
+		ociutil.WriteRegistryError(w, http.StatusMethodNotAllowed, ociutil.RegistryErrUnsupported, "unsupported method")
+		return
+	}
+
+	if r.username == "" || username != r.username || password != r.password {
+		ociutil.WriteRegistryError(w, http.StatusUnauthorized, ociutil.RegistryErrUnauthorized, "invalid credentials")
+		return
+	}
+
+	token := randomID()
+
+	r.mx.Lock()
+	r.tokens[token] = true
+	r.mx.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"token":        token,
+		"access_token": token,
+		"expires_in":   3600,
+	})
+}
+
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, repo string) {
+	if req.Method != http.MethodGet {
+		ociutil.WriteRegistryError(w, http.StatusMethodNotAllowed, ociutil.RegistryErrUnsupported, "unsupported method")
+		return
+	}
+
+	r.mx.Lock()
+	rep, ok := r.repos[repo]
+	var tags []string
+	if ok {
+		for tag := range rep.tags {
+			tags = append(tags, tag)
+		}
+	}
+	r.mx.Unlock()
+
+	if !ok {
+		ociutil.WriteRegistryError(w, http.StatusNotFound, ociutil.RegistryErrNameUnknown, fmt.Sprintf("repository %v not found", repo))
+		return
+	}
+
+	sort.Strings(tags)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{repo, tags})
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		r.mx.Lock()
+		m, dgst, ok := r.manifest(repo, ref)
+		r.mx.Unlock()
+
+		if !ok {
+			ociutil.WriteRegistryError(w, http.StatusNotFound, ociutil.RegistryErrManifestUnknown, fmt.Sprintf("manifest %v not found", ref))
+			return
+		}
+
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(m.data))
+	case http.MethodPut:
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			ociutil.WriteRegistryError(w, http.StatusBadRequest, ociutil.RegistryErrManifestInvalid, err.Error())
+			return
+		}
+
+		dgst := digest.FromBytes(data)
+		if d, err := digest.Parse(ref); err == nil && d != dgst {
+			ociutil.WriteRegistryError(w, http.StatusBadRequest, ociutil.RegistryErrDigestInvalid, fmt.Sprintf("manifest digest is %v, not %v", dgst, d))
+			return
+		}
+
+		// Like registries, reject manifests pushed before their blobs.
+		var children struct {
+			Config    *ocispec.Descriptor  `json:"config"`
+			Layers    []ocispec.Descriptor `json:"layers"`
+			Manifests []ocispec.Descriptor `json:"manifests"`
+		}
+		err = json.Unmarshal(data, &children)
+		if err != nil {
+			ociutil.WriteRegistryError(w, http.StatusBadRequest, ociutil.RegistryErrManifestInvalid, err.Error())
+			return
+		}
+
+		r.mx.Lock()
+		defer r.mx.Unlock()
+
+		rep := r.repo(repo)
+		blobs := children.Layers
+		if children.Config != nil {
+			blobs = append(blobs, *children.Config)
+		}
+		for _, desc := range blobs {
+			if _, ok := rep.blobs[desc.Digest]; !ok {
+				ociutil.WriteRegistryError(w, http.StatusBadRequest, ociutil.RegistryErrManifestBlobUnknown, fmt.Sprintf("blob %v not found", desc.Digest))
+				return
+			}
+		}
+		for _, desc := range children.Manifests {
+			if _, ok := rep.manifests[desc.Digest]; !ok {
+				ociutil.WriteRegistryError(w, http.StatusBadRequest, ociutil.RegistryErrManifestUnknown, fmt.Sprintf("manifest %v not found", desc.Digest))
+				return
+			}
+		}
+
+		rep.manifests[dgst] = manifest{mediaType: req.Header.Get("Content-Type"), data: data}
+		if _, err := digest.Parse(ref); err != nil {
+			rep.tags[ref] = dgst
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/v2/%v/manifests/%v", repo, dgst))
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.WriteHeader(http.StatusCreated)
+	default:
+		ociutil.WriteRegistryError(w, http.StatusMethodNotAllowed, ociutil.RegistryErrUnsupported, "unsupported method")
+	}
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		ociutil.WriteRegistryError(w, http.StatusMethodNotAllowed, ociutil.RegistryErrUnsupported, "unsupported method")
+		return
+	}
+
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		ociutil.WriteRegistryError(w, http.StatusBadRequest, ociutil.RegistryErrDigestInvalid, err.Error())
+		return
+	}
+
+	data, ok := r.Blob(repo, dgst)
+	if !ok {
+		ociutil.WriteRegistryError(w, http.StatusNotFound, ociutil.RegistryErrBlobUnknown, fmt.Sprintf("blob %v not found", dgst))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+
+	// Handles the range requests and HEAD.
+	http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+}
+
+// serveUpload handles the blob uploads: monolithic with POST, chunked with
+// POST, PATCH and PUT, and cross-repository mounts.
+func (r *Registry) serveUpload(w http.ResponseWriter, req *http.Request, repo, id string) {
+	if id == "" {
+		if req.Method != http.MethodPost {
+			ociutil.WriteRegistryError(w, http.StatusMethodNotAllowed, ociutil.RegistryErrUnsupported, "unsupported method")
+			return
+		}
+
+		query := req.URL.Query()
+
+		if mount, from := query.Get("mount"), query.Get("from"); mount != "" && from != "" {
+			// Blobs that can't be mounted are uploaded instead.
+			if data, ok := r.Blob(from, digest.Digest(mount)); ok {
+				r.mx.Lock()
+				r.repo(repo).blobs[digest.Digest(mount)] = data
+				r.mx.Unlock()
+
+				writeBlobCreated(w, repo, digest.Digest(mount))
+				return
+			}
+		}
+
+		if dgst := query.Get("digest"); dgst != "" {
+			up := &upload{repo: repo}
+			r.finishUpload(w, req, up, dgst)
+			return
+		}
+
+		id = randomID()
+		r.mx.Lock()
+		r.uploads[id] = &upload{repo: repo}
+		r.mx.Unlock()
+
+		writeUploadStatus(w, repo, id, 0, http.StatusAccepted)
+		return
+	}
+
+	r.mx.Lock()
+	up, ok := r.uploads[id]
+	r.mx.Unlock()
+
+	if !ok || up.repo != repo {
+		ociutil.WriteRegistryError(w, http.StatusNotFound, ociutil.RegistryErrBlobUploadUnknown, fmt.Sprintf("upload %v not found", id))
+		return
+	}
+
+	up.mx.Lock()
+	defer up.mx.Unlock()
+
+	switch req.Method {
+	case http.MethodGet:
+		writeUploadStatus(w, repo, id, up.data.Len(), http.StatusNoContent)
+	case http.MethodPatch:
+		// Chunks must be sent in order.
+		if rng := req.Header.Get("Content-Range"); rng != "" {
+			var start, end int
+			_, err := fmt.Sscanf(rng, "%d-%d", &start, &end)
+			if err != nil || start != up.data.Len() {
+				writeUploadStatus(w, repo, id, up.data.Len(), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+		}
+
+		_, err := io.Copy(&up.data, req.Body)
+		if err != nil {
+			ociutil.WriteRegistryError(w, http.StatusBadRequest, ociutil.RegistryErrBlobUploadInvalid, err.Error())
+			return
+		}
+
+		writeUploadStatus(w, repo, id, up.data.Len(), http.StatusAccepted)
+	case http.MethodPut:
+		r.mx.Lock()
+		delete(r.uploads, id)
+		r.mx.Unlock()
+
+		r.finishUpload(w, req, up, req.URL.Query().Get("digest"))
+	case http.MethodDelete:
+		r.mx.Lock()
+		delete(r.uploads, id)
+		r.mx.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		ociutil.WriteRegistryError(w, http.StatusMethodNotAllowed, ociutil.RegistryErrUnsupported, "unsupported method")
+	}
+}
+
+// finishUpload adds the body of req to the upload, and adds the blob to the
+// repository if it matches dgst.
+func (r *Registry) finishUpload(w http.ResponseWriter, req *http.Request, up *upload, dgst string) {
+	expected, err := digest.Parse(dgst)
+	if err != nil {
+		ociutil.WriteRegistryError(w, http.StatusBadRequest, ociutil.RegistryErrDigestInvalid, err.Error())
+		return
+	}
+
+	_, err = io.Copy(&up.data, req.Body)
+	if err != nil {
+		ociutil.WriteRegistryError(w, http.StatusBadRequest, ociutil.RegistryErrBlobUploadInvalid, err.Error())
+		return
+	}
+
+	if actual := digest.FromBytes(up.data.Bytes()); actual != expected {
+		ociutil.WriteRegistryError(w, http.StatusBadRequest, ociutil.RegistryErrDigestInvalid, fmt.Sprintf("blob digest is %v, not %v", actual, expected))
+		return
+	}
+
+	r.mx.Lock()
+	r.repo(up.repo).blobs[expected] = up.data.Bytes()
+	r.mx.Unlock()
+
+	writeBlobCreated(w, up.repo, expected)
+}
+
+func writeBlobCreated(w http.ResponseWriter, repo string, dgst digest.Digest) {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%v/blobs/%v", repo, dgst))
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func writeUploadStatus(w http.ResponseWriter, repo, id string, size int, status int) {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%v/blobs/uploads/%v", repo, id))
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(status)
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
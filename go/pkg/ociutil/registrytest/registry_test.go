@@ -0,0 +1,312 @@
+package registrytest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/rules_oci/go/pkg/ociutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// memProvider provides blobs from memory.
+type memProvider map[digest.Digest][]byte
+
+type bytesReaderAt struct {
+	*bytes.Reader
+}
+
+func (bytesReaderAt) Close() error {
+	return nil
+}
+
+func (p memProvider) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	data, ok := p[desc.Digest]
+	if !ok {
+		return nil, errdefs.ErrNotFound
+	}
+
+	return bytesReaderAt{bytes.NewReader(data)}, nil
+}
+
+func (p memProvider) add(mediaType string, data []byte) ocispec.Descriptor {
+	dgst := digest.FromBytes(data)
+	p[dgst] = data
+
+	return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+}
+
+// testImage adds the blobs of an image to p and returns the descriptors of
+// its layer and manifest.
+func testImage(t *testing.T, p memProvider) (ocispec.Descriptor, ocispec.Descriptor) {
+	t.Helper()
+
+	layerDesc := p.add(ocispec.MediaTypeImageLayer, []byte("layer"))
+	configDesc := p.add(ocispec.MediaTypeImageConfig, []byte("{}"))
+
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	}
+	manifest.SchemaVersion = 2
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return layerDesc, p.add(ocispec.MediaTypeImageManifest, data)
+}
+
+// testPusher returns an ingester pushing to ref.
+func testPusher(t *testing.T, resolver ociutil.Resolver, ref string) content.Ingester {
+	t.Helper()
+
+	pusher, err := resolver.Pusher(context.Background(), ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ing, ok := pusher.(content.Ingester)
+	if !ok {
+		t.Fatalf("pusher not an ingester: %T", pusher)
+	}
+
+	return ing
+}
+
+func TestRegistryPushPull(t *testing.T) {
+	ctx := context.Background()
+	reg := New(t, WithAuth("user", "secret"))
+	resolver := reg.Resolver()
+
+	provider := memProvider{}
+	layerDesc, manifestDesc := testImage(t, provider)
+	ref := reg.Host() + "/team/app:v1"
+
+	pusher := testPusher(t, resolver, ref)
+
+	err := ociutil.CopyChildrenFromHandler(ctx, images.ChildrenHandler(provider), provider, pusher, manifestDesc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ociutil.CopyContent(ctx, provider, pusher, manifestDesc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != manifestDesc.Digest {
+		t.Errorf("expected %v, got %v", manifestDesc.Digest, desc.Digest)
+	}
+
+	rc, err := resolver.FetchBlob(ctx, reg.Host()+"/team/app", layerDesc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "layer" {
+		t.Errorf("expected %q, got %q", "layer", data)
+	}
+
+	// Clients without credentials are rejected.
+	resp, err := http.Get(reg.Server.URL + "/v2/team/app/tags/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status %v, got %v", http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func TestRegistryMount(t *testing.T) {
+	reg := New(t)
+	desc := reg.AddBlob("base", []byte("base layer"))
+
+	mount := func(from string, dgst digest.Digest) *http.Response {
+		t.Helper()
+
+		url := fmt.Sprintf("%v/v2/app/blobs/uploads/?mount=%v&from=%v", reg.Server.URL, dgst, from)
+		resp, err := reg.Server.Client().Post(url, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp
+	}
+
+	resp := mount("base", desc.Digest)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %v, got %v", http.StatusCreated, resp.StatusCode)
+	}
+	if _, ok := reg.Blob("app", desc.Digest); !ok {
+		t.Errorf("expected blob %v to be mounted", desc.Digest)
+	}
+
+	// Blobs that can't be mounted start an upload instead.
+	resp = mount("other", desc.Digest)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("expected status %v, got %v", http.StatusAccepted, resp.StatusCode)
+	}
+}
+
+func TestRegistryChunkedUpload(t *testing.T) {
+	reg := New(t)
+	data := []byte("0123456789")
+	dgst := digest.FromBytes(data)
+
+	do := func(method, location string, header http.Header, body []byte) *http.Response {
+		t.Helper()
+
+		req, err := http.NewRequest(method, reg.Server.URL+location, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		resp, err := reg.Server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp
+	}
+
+	resp := do(http.MethodPost, "/v2/app/blobs/uploads/", nil, nil)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected status %v, got %v", http.StatusAccepted, resp.StatusCode)
+	}
+	location := resp.Header.Get("Location")
+
+	for _, chunk := range []struct {
+		rng    string
+		status int
+	}{
+		{"0-3", http.StatusAccepted},
+		{"8-9", http.StatusRequestedRangeNotSatisfiable},
+		{"4-7", http.StatusAccepted},
+	} {
+		var start, end int
+		fmt.Sscanf(chunk.rng, "%d-%d", &start, &end)
+
+		resp = do(http.MethodPatch, location, http.Header{"Content-Range": {chunk.rng}}, data[start:end+1])
+		if resp.StatusCode != chunk.status {
+			t.Fatalf("chunk %v: expected status %v, got %v", chunk.rng, chunk.status, resp.StatusCode)
+		}
+		location = resp.Header.Get("Location")
+	}
+
+	if rng := resp.Header.Get("Range"); rng != "0-7" {
+		t.Errorf("expected range 0-7, got %v", rng)
+	}
+
+	resp = do(http.MethodPut, location+"?digest="+dgst.String(), nil, data[8:])
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %v, got %v", http.StatusCreated, resp.StatusCode)
+	}
+
+	blob, ok := reg.Blob("app", dgst)
+	if !ok || !bytes.Equal(blob, data) {
+		t.Errorf("expected blob %q, got %q", data, blob)
+	}
+}
+
+func TestRegistryFaults(t *testing.T) {
+	ctx := context.Background()
+	reg := New(t)
+	resolver := reg.Resolver()
+
+	data := bytes.Repeat([]byte("x"), 4*slowChunkSize)
+	desc := reg.AddBlob("app", data)
+	repo := reg.Host() + "/app"
+
+	fetch := func() error {
+		rc, err := resolver.FetchBlob(ctx, repo, desc)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		_, err = io.Copy(io.Discard, rc)
+		return err
+	}
+
+	reg.Inject(Fault{Path: "/blobs/", Count: 1, Status: http.StatusInternalServerError})
+
+	err := fetch()
+	if err == nil {
+		t.Error("expected the fault to fail the fetch")
+	}
+
+	// Only Count requests fail.
+	err = fetch()
+	if err != nil {
+		t.Errorf("expected the fault to be removed: %v", err)
+	}
+
+	// Without keep-alive, so that the client doesn't retry the requests
+	// dropped on reused connections.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	blobURL := fmt.Sprintf("%v/v2/app/blobs/%v", reg.Server.URL, desc.Digest)
+
+	reg.Inject(Fault{Path: "/blobs/", Count: 1, Status: http.StatusTooManyRequests, RetryAfter: 1500 * time.Millisecond})
+
+	resp, err := client.Get(blobURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("expected status 429 with Retry-After 2, got %v with %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	reg.Inject(Fault{Path: "/blobs/", Count: 1, Drop: true})
+
+	_, err = client.Get(blobURL)
+	if err == nil {
+		t.Error("expected the connection to be dropped")
+	}
+
+	reg.Inject(Fault{Method: http.MethodGet, Path: "/blobs/", BodyDelay: 10 * time.Millisecond})
+
+	start := time.Now()
+	err = fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected a slow body, fetched in %v", elapsed)
+	}
+
+	if !strings.HasPrefix(reg.Requests()[0], "GET /v2/app/blobs/") {
+		t.Errorf("unexpected first request %v", reg.Requests()[0])
+	}
+	if reg.MaxInFlight() != 1 {
+		t.Errorf("expected sequential requests, got %d in flight", reg.MaxInFlight())
+	}
+}
@@ -0,0 +1,289 @@
+package ociutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	dref "github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxManifestSize is the size of the largest manifest served, the limit
+// recommended by the OCI distribution spec.
+const maxManifestSize = 4 << 20
+
+// RegistryHandler serves the read API of the OCI distribution spec: manifests
+// by tag or digest, blobs with range requests, and tags lists. The blobs of
+// every repository come from the same provider, the repositories and their
+// tags are the ones added with AddTag.
+type RegistryHandler struct {
+	provider content.Provider
+
+	mx sync.RWMutex
+	// tags are the manifests of the tags of each repository.
+	tags map[string]map[string]ocispec.Descriptor
+}
+
+// NewRegistryHandler returns a handler serving the blobs of provider.
+func NewRegistryHandler(provider content.Provider) *RegistryHandler {
+	return &RegistryHandler{
+		provider: provider,
+		tags:     make(map[string]map[string]ocispec.Descriptor),
+	}
+}
+
+// AddTag tags desc with ref, a reference of the form [domain/]name:tag. The
+// domain is ignored, as clients address the handler with its own domain.
+func (h *RegistryHandler) AddTag(ref string, desc ocispec.Descriptor) error {
+	named, err := NamedRef(ref)
+	if err != nil {
+		return fmt.Errorf("invalid reference %q: %w", ref, err)
+	}
+
+	tagged, ok := named.(dref.Tagged)
+	if !ok {
+		return fmt.Errorf("reference %q has no tag", ref)
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	repo := dref.Path(named)
+	if h.tags[repo] == nil {
+		h.tags[repo] = make(map[string]ocispec.Descriptor)
+	}
+	h.tags[repo][tagged.Tag()] = desc
+
+	return nil
+}
+
+// Repositories returns the sorted names of the repositories with tags.
+func (h *RegistryHandler) Repositories() []string {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+
+	repos := make([]string, 0, len(h.tags))
+	for repo := range h.tags {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	return repos
+}
+
+func (h *RegistryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.WithField("method", r.Method).Debugf("registry request %v", r.URL)
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		WriteRegistryError(w, http.StatusMethodNotAllowed, RegistryErrUnsupported, "the registry is read-only")
+		return
+	}
+
+	p := r.URL.Path
+	if p == "/v2" || p == "/v2/" {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+		return
+	}
+
+	if !strings.HasPrefix(p, "/v2/") {
+		http.NotFound(w, r)
+		return
+	}
+	p = strings.TrimPrefix(p, "/v2/")
+
+	if repo, ok := strings.CutSuffix(p, "/tags/list"); ok {
+		h.serveTags(w, r, repo)
+		return
+	}
+
+	if i := strings.LastIndex(p, "/manifests/"); i > 0 {
+		h.serveManifest(w, r, p[:i], p[i+len("/manifests/"):])
+		return
+	}
+
+	if i := strings.LastIndex(p, "/blobs/"); i > 0 {
+		h.serveBlob(w, r, p[:i], p[i+len("/blobs/"):])
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+// repoTags returns the tags of repo, or false if there is no such
+// repository.
+func (h *RegistryHandler) repoTags(repo string) (map[string]ocispec.Descriptor, bool) {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+
+	tags, ok := h.tags[repo]
+	return tags, ok
+}
+
+func (h *RegistryHandler) serveTags(w http.ResponseWriter, r *http.Request, repo string) {
+	repoTags, ok := h.repoTags(repo)
+	if !ok {
+		WriteRegistryError(w, http.StatusNotFound, RegistryErrNameUnknown, fmt.Sprintf("repository %v not found", repo))
+		return
+	}
+
+	tags := make([]string, 0, len(repoTags))
+	for tag := range repoTags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	// Pagination, the tags after last, at most n.
+	if last := r.URL.Query().Get("last"); last != "" {
+		i := sort.SearchStrings(tags, last)
+		if i < len(tags) && tags[i] == last {
+			i++
+		}
+		tags = tags[i:]
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n >= 0 && n < len(tags) {
+		tags = tags[:n]
+		// An empty page has no last tag to continue from.
+		if n > 0 {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%v/tags/list?n=%d&last=%v>; rel="next"`, repo, n, tags[len(tags)-1]))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{repo, tags})
+}
+
+func (h *RegistryHandler) serveManifest(w http.ResponseWriter, r *http.Request, repo, ref string) {
+	repoTags, ok := h.repoTags(repo)
+	if !ok {
+		WriteRegistryError(w, http.StatusNotFound, RegistryErrNameUnknown, fmt.Sprintf("repository %v not found", repo))
+		return
+	}
+
+	desc, ok := repoTags[ref]
+	if !ok {
+		dgst, err := digest.Parse(ref)
+		if err != nil {
+			WriteRegistryError(w, http.StatusNotFound, RegistryErrManifestUnknown, fmt.Sprintf("manifest %v not found", ref))
+			return
+		}
+		desc = ocispec.Descriptor{Digest: dgst}
+	}
+
+	ra, err := h.provider.ReaderAt(r.Context(), desc)
+	if errdefs.IsNotFound(err) {
+		WriteRegistryError(w, http.StatusNotFound, RegistryErrManifestUnknown, fmt.Sprintf("manifest %v not found", ref))
+		return
+	} else if err != nil {
+		WriteRegistryError(w, http.StatusInternalServerError, RegistryErrManifestUnknown, err.Error())
+		return
+	}
+	defer ra.Close()
+
+	// Manifests are small, they're read to find their media type when they
+	// are requested by digest. Larger blobs, e.g. layers, aren't manifests.
+	if ra.Size() > maxManifestSize {
+		WriteRegistryError(w, http.StatusNotFound, RegistryErrManifestUnknown, fmt.Sprintf("%v isn't a manifest", ref))
+		return
+	}
+
+	data, err := io.ReadAll(content.NewReader(ra))
+	if err != nil {
+		WriteRegistryError(w, http.StatusInternalServerError, RegistryErrManifestUnknown, err.Error())
+		return
+	}
+
+	mediaType := desc.MediaType
+	if mediaType == "" {
+		mediaType = manifestMediaType(data)
+		if mediaType == "" {
+			WriteRegistryError(w, http.StatusNotFound, RegistryErrManifestUnknown, fmt.Sprintf("%v isn't a manifest", ref))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+	w.Header().Set("Etag", fmt.Sprintf("%q", desc.Digest))
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(data)
+}
+
+// manifestMediaType returns the media type of the manifest or index in data,
+// or "" if data isn't one. The mediaType field is optional in OCI manifests
+// and indexes, without it the type is the one of the fields present.
+func manifestMediaType(data []byte) string {
+	var manifest struct {
+		MediaType string               `json:"mediaType"`
+		Config    *ocispec.Descriptor  `json:"config"`
+		Manifests []ocispec.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return ""
+	}
+
+	switch {
+	case manifest.MediaType != "":
+		return manifest.MediaType
+	case manifest.Manifests != nil:
+		return ocispec.MediaTypeImageIndex
+	case manifest.Config != nil && manifest.Config.MediaType == images.MediaTypeDockerSchema2Config:
+		return images.MediaTypeDockerSchema2Manifest
+	case manifest.Config != nil:
+		return ocispec.MediaTypeImageManifest
+	}
+
+	return ""
+}
+
+func (h *RegistryHandler) serveBlob(w http.ResponseWriter, r *http.Request, repo, ref string) {
+	if _, ok := h.repoTags(repo); !ok {
+		WriteRegistryError(w, http.StatusNotFound, RegistryErrNameUnknown, fmt.Sprintf("repository %v not found", repo))
+		return
+	}
+
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		WriteRegistryError(w, http.StatusBadRequest, RegistryErrDigestInvalid, err.Error())
+		return
+	}
+
+	ra, err := h.provider.ReaderAt(r.Context(), ocispec.Descriptor{Digest: dgst})
+	if errdefs.IsNotFound(err) {
+		WriteRegistryError(w, http.StatusNotFound, RegistryErrBlobUnknown, fmt.Sprintf("blob %v not found", dgst))
+		return
+	} else if err != nil {
+		WriteRegistryError(w, http.StatusInternalServerError, RegistryErrBlobUnknown, err.Error())
+		return
+	}
+	defer ra.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.Header().Set("Etag", fmt.Sprintf("%q", dgst))
+
+	// Handles the range requests and HEAD.
+	http.ServeContent(w, r, "", time.Time{}, io.NewSectionReader(ra, 0, ra.Size()))
+}
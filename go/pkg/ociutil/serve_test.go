@@ -0,0 +1,156 @@
+package ociutil
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestRegistryHandler(t *testing.T) {
+	dir := t.TempDir()
+
+	layer := []byte("0123456789")
+	layerDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(layer),
+		Size:      int64(len(layer)),
+	}
+
+	manifest, err := json.Marshal(ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+	}
+
+	// A manifest and an index without mediaType, only known by digest.
+	bareManifest, err := json.Marshal(ocispec.Manifest{
+		Config: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: layerDesc.Digest, Size: layerDesc.Size},
+		Layers: []ocispec.Descriptor{layerDesc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bareIndex, err := json.Marshal(ocispec.Index{
+		Manifests: []ocispec.Descriptor{manifestDesc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A blob too large to be a manifest.
+	large := make([]byte, maxManifestSize+1)
+
+	provider := fileProvider{}
+	for dgst, data := range map[digest.Digest][]byte{
+		layerDesc.Digest:               layer,
+		manifestDesc.Digest:            manifest,
+		digest.FromBytes(bareManifest): bareManifest,
+		digest.FromBytes(bareIndex):    bareIndex,
+		digest.FromBytes(large):        large,
+	} {
+		path := filepath.Join(dir, dgst.Encoded())
+		err := os.WriteFile(path, data, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		provider[dgst] = path
+	}
+
+	handler := NewRegistryHandler(provider)
+	for _, ref := range []string{"example.com/team/app:v2", "example.com/team/app:v1", "app:latest"} {
+		err := handler.AddTag(ref, manifestDesc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = handler.AddTag("example.com/team/app", manifestDesc)
+	if err == nil {
+		t.Error("expected an error for a reference without tag")
+	}
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name        string
+		method      string
+		path        string
+		header      http.Header
+		status      int
+		contentType string
+		link        string
+		body        string
+	}{
+		{name: "base", path: "/v2/", status: http.StatusOK, body: "{}"},
+		{name: "manifest by tag", path: "/v2/team/app/manifests/v1", status: http.StatusOK, contentType: ocispec.MediaTypeImageManifest, body: string(manifest)},
+		{name: "manifest by digest", method: http.MethodHead, path: "/v2/app/manifests/" + manifestDesc.Digest.String(), status: http.StatusOK, contentType: ocispec.MediaTypeImageManifest},
+		{name: "manifest without media type", path: "/v2/app/manifests/" + digest.FromBytes(bareManifest).String(), status: http.StatusOK, contentType: ocispec.MediaTypeImageManifest, body: string(bareManifest)},
+		{name: "index without media type", path: "/v2/app/manifests/" + digest.FromBytes(bareIndex).String(), status: http.StatusOK, contentType: ocispec.MediaTypeImageIndex, body: string(bareIndex)},
+		{name: "large blob by digest", path: "/v2/app/manifests/" + digest.FromBytes(large).String(), status: http.StatusNotFound, body: "MANIFEST_UNKNOWN"},
+		{name: "unknown tag", path: "/v2/app/manifests/v1", status: http.StatusNotFound, body: "MANIFEST_UNKNOWN"},
+		{name: "blob", path: "/v2/app/blobs/" + layerDesc.Digest.String(), status: http.StatusOK, body: string(layer)},
+		{name: "blob range", path: "/v2/app/blobs/" + layerDesc.Digest.String(), header: http.Header{"Range": {"bytes=2-4"}}, status: http.StatusPartialContent, body: "234"},
+		{name: "unknown blob", path: "/v2/app/blobs/" + digest.FromString("nope").String(), status: http.StatusNotFound, body: "BLOB_UNKNOWN"},
+		{name: "tags", path: "/v2/team/app/tags/list", status: http.StatusOK, body: `{"name":"team/app","tags":["v1","v2"]}`},
+		{name: "tags first page", path: "/v2/team/app/tags/list?n=1", status: http.StatusOK, link: `</v2/team/app/tags/list?n=1&last=v1>; rel="next"`, body: `{"name":"team/app","tags":["v1"]}`},
+		{name: "tags page", path: "/v2/team/app/tags/list?n=1&last=v1", status: http.StatusOK, body: `{"name":"team/app","tags":["v2"]}`},
+		{name: "tags empty page", path: "/v2/team/app/tags/list?n=0", status: http.StatusOK, body: `{"name":"team/app","tags":[]}`},
+		{name: "unknown repository", path: "/v2/other/tags/list", status: http.StatusNotFound, body: "NAME_UNKNOWN"},
+		{name: "read-only", method: http.MethodPut, path: "/v2/app/manifests/v2", status: http.StatusMethodNotAllowed, body: "UNSUPPORTED"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			req, err := http.NewRequest(method, srv.URL+tc.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status %v, got %v: %s", tc.status, resp.StatusCode, body)
+			}
+			if tc.contentType != "" && resp.Header.Get("Content-Type") != tc.contentType {
+				t.Errorf("expected content type %v, got %v", tc.contentType, resp.Header.Get("Content-Type"))
+			}
+			if resp.Header.Get("Link") != tc.link {
+				t.Errorf("expected link %q, got %q", tc.link, resp.Header.Get("Link"))
+			}
+			if !strings.Contains(string(body), tc.body) {
+				t.Errorf("expected body to contain %q, got %q", tc.body, body)
+			}
+		})
+	}
+}
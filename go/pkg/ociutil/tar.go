@@ -2,92 +2,332 @@ package ociutil
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"sync"
+	"time"
 
 	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/opencontainers/go-digest"
+	ocispecv "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 var (
-	_ content.Ingester = &tarIngestor{}
+	_ content.Ingester = &TarIngestor{}
+	_ content.Writer   = &tarWriter{}
 )
 
-func NewTarIngestor(path string) content.Ingester {
-	return &tarIngestor{path: path}
-}
+// tarSpoolThreshold is the size above which blobs are spooled to a temporary
+// file rather than buffered in memory.
+const tarSpoolThreshold = 4 << 20
 
-type tarIngestor struct {
-	path string
-	tw   *tar.Writer
-	sync.Mutex
-	wo content.WriterOpts
+// NewTarIngestor creates an ingester that writes blobs into a single OCI
+// Image Layout tarball. Finish must be called to write index.json and the
+// end-of-archive trailer.
+//
+// Writers may be used concurrently; each blob is buffered (or spooled to a
+// temporary file when large) until it is committed and is then appended to
+// the archive. Blobs that were already committed are only written once.
+func NewTarIngestor(w io.Writer) *TarIngestor {
+	return &TarIngestor{
+		tw:      tar.NewWriter(w),
+		written: make(map[digest.Digest]bool),
+		dirs:    make(map[string]bool),
+	}
 }
 
-func (ing *tarIngestor) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
-	ing.Lock()
+// TarIngestor implements content.Ingester on top of an OCI Image Layout
+// tarball.
+type TarIngestor struct {
+	mx       sync.Mutex
+	tw       *tar.Writer
+	written  map[digest.Digest]bool
+	dirs     map[string]bool
+	finished bool
+	// err is the first failed write to the archive, after which it can't be
+	// completed.
+	err error
+}
 
+func (ing *TarIngestor) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	var wOpts content.WriterOpts
 	for _, o := range opts {
-		if err := o(&ing.wo); err != nil {
-			ing.Close()
+		if err := o(&wOpts); err != nil {
 			return nil, err
 		}
 	}
 
-	f, err := os.OpenFile(ing.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		ing.Close()
-		return nil, err
+	dgst := wOpts.Desc.Digest
+	if err := dgst.Validate(); err != nil {
+		return nil, fmt.Errorf("tar ingestor: must have digest: %w", err)
 	}
 
-	ing.tw = tar.NewWriter(f)
+	ing.mx.Lock()
+	defer ing.mx.Unlock()
 
-	dgst := ing.wo.Desc.Digest
-	if err := dgst.Validate(); err != nil {
-		ing.Close()
-		return nil, fmt.Errorf("tar ingestor: must have digest: %w", err)
+	if ing.finished {
+		return nil, fmt.Errorf("tar ingestor: %w: archive already finished", errdefs.ErrFailedPrecondition)
+	}
+
+	if ing.err != nil {
+		return nil, ing.err
+	}
+
+	if ing.written[dgst] {
+		return nil, fmt.Errorf("tar ingestor: %v: %w", dgst, errdefs.ErrAlreadyExists)
+	}
+
+	now := time.Now()
+	return &tarWriter{
+		ing:      ing,
+		desc:     wOpts.Desc,
+		digester: dgst.Algorithm().Digester(),
+		status: content.Status{
+			Ref:       wOpts.Ref,
+			Total:     wOpts.Desc.Size,
+			Expected:  dgst,
+			StartedAt: now,
+			UpdatedAt: now,
+		},
+	}, nil
+}
+
+// Finish writes the oci-layout and index.json entries and the end-of-archive
+// trailer. The index lists manifests, which must all have been committed to
+// the archive; if they aren't, nothing is written and Finish can be retried.
+// Finish fails if any write to the archive failed, as it is then incomplete.
+func (ing *TarIngestor) Finish(manifests ...ocispec.Descriptor) error {
+	ing.mx.Lock()
+	defer ing.mx.Unlock()
+
+	if ing.finished {
+		return fmt.Errorf("tar ingestor: %w: archive already finished", errdefs.ErrFailedPrecondition)
+	}
+
+	if ing.err != nil {
+		return ing.err
+	}
+
+	for _, desc := range manifests {
+		if !ing.written[desc.Digest] {
+			return fmt.Errorf("tar ingestor: index references %v which isn't in the archive: %w", desc.Digest, errdefs.ErrNotFound)
+		}
 	}
+	ing.finished = true
 
-	hdr := &tar.Header{
-		Name: fmt.Sprintf("blobs/%v/%v", dgst.Algorithm().String(), dgst.Encoded()),
-		Mode: 0600,
-		Size: ing.wo.Desc.Size,
+	if manifests == nil {
+		manifests = []ocispec.Descriptor{}
 	}
 
-	err = ing.tw.WriteHeader(hdr)
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: ocispecv.Versioned{
+			SchemaVersion: 2,
+		},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: manifests,
+	})
 	if err != nil {
-		ing.Close()
-		return nil, err
+		return err
 	}
 
-	return ing, nil
+	err = ing.writeFile(OciLayoutFileName, bytes.NewReader([]byte(OciLayoutFileContent)), int64(len(OciLayoutFileContent)))
+	if err != nil {
+		return err
+	}
+
+	err = ing.writeFile(OciIndexFileName, bytes.NewReader(index), int64(len(index)))
+	if err != nil {
+		return err
+	}
+
+	return ing.fail(ing.tw.Close())
 }
 
-func (ing *tarIngestor) Close() error {
-	ing.tw.Close()
-	ing.wo = content.WriterOpts{}
-	ing.Unlock()
+// commit appends a fully written blob to the archive, the caller must hold
+// the lock.
+func (ing *TarIngestor) commit(dgst digest.Digest, r io.Reader, size int64) error {
+	if ing.finished {
+		return fmt.Errorf("tar ingestor: %w: archive already finished", errdefs.ErrFailedPrecondition)
+	}
+
+	if ing.err != nil {
+		return ing.err
+	}
+
+	if ing.written[dgst] {
+		return fmt.Errorf("tar ingestor: %v: %w", dgst, errdefs.ErrAlreadyExists)
+	}
+
+	dir := path.Join(BlobsFolderName, dgst.Algorithm().String())
+	for _, d := range []string{BlobsFolderName, dir} {
+		if ing.dirs[d] {
+			continue
+		}
+
+		err := ing.tw.WriteHeader(&tar.Header{
+			Name:     d + "/",
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+			ModTime:  time.Unix(0, 0),
+		})
+		if err != nil {
+			return ing.fail(err)
+		}
+		ing.dirs[d] = true
+	}
+
+	err := ing.writeFile(path.Join(dir, dgst.Encoded()), r, size)
+	if err != nil {
+		return err
+	}
+
+	ing.written[dgst] = true
+
 	return nil
 }
 
-func (ing *tarIngestor) Write(p []byte) (int, error) {
-	return ing.tw.Write(p)
+// writeFile appends a file to the archive, the caller must hold the lock.
+func (ing *TarIngestor) writeFile(name string, r io.Reader, size int64) error {
+	err := ing.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return ing.fail(err)
+	}
+
+	_, err = io.CopyN(ing.tw, r, size)
+	return ing.fail(err)
 }
 
-func (ing *tarIngestor) Digest() digest.Digest {
-	return digest.Digest("")
+// fail records err, if any, as the first failed write to the archive. A
+// partially written entry can't be undone, so every later write fails with
+// it too. The caller must hold the lock.
+func (ing *TarIngestor) fail(err error) error {
+	if err != nil && ing.err == nil {
+		ing.err = fmt.Errorf("tar ingestor: archive is incomplete after a failed write: %w", err)
+	}
+
+	return err
 }
 
-func (ing *tarIngestor) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
-	return ing.Close()
+// tarWriter buffers a single blob until it's committed to the archive.
+type tarWriter struct {
+	ing      *TarIngestor
+	desc     ocispec.Descriptor
+	digester digest.Digester
+	status   content.Status
+
+	buf   bytes.Buffer
+	spool *os.File
+}
+
+func (w *tarWriter) Write(p []byte) (int, error) {
+	if w.spool == nil && int64(w.buf.Len()+len(p)) > tarSpoolThreshold {
+		f, err := os.CreateTemp("", "ocitool-tar-spool-*")
+		if err != nil {
+			return 0, err
+		}
+		w.spool = f
+
+		if _, err := w.buf.WriteTo(w.spool); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if w.spool != nil {
+		n, err = w.spool.Write(p)
+	} else {
+		n, err = w.buf.Write(p)
+	}
+
+	w.digester.Hash().Write(p[:n])
+	w.status.Offset += int64(n)
+	w.status.UpdatedAt = time.Now()
+
+	return n, err
 }
 
-func (ing *tarIngestor) Status() (content.Status, error) {
-	return content.Status{}, fmt.Errorf("tar writer: not implemented")
+func (w *tarWriter) Digest() digest.Digest {
+	return w.digester.Digest()
 }
 
-func (ing *tarIngestor) Truncate(size int64) error {
-	return fmt.Errorf("tar writer: not implemented")
+func (w *tarWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	defer w.Close()
+
+	if size > 0 && size != w.status.Offset {
+		return fmt.Errorf("tar ingestor: unexpected commit size %d, expected %d: %w", w.status.Offset, size, errdefs.ErrFailedPrecondition)
+	}
+
+	if expected == "" {
+		expected = w.desc.Digest
+	}
+
+	if dgst := w.digester.Digest(); dgst != expected {
+		return fmt.Errorf("tar ingestor: unexpected commit digest %v, expected %v: %w", dgst, expected, errdefs.ErrFailedPrecondition)
+	}
+
+	var r io.Reader = &w.buf
+	if w.spool != nil {
+		if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = w.spool
+	}
+
+	w.ing.mx.Lock()
+	defer w.ing.mx.Unlock()
+
+	return w.ing.commit(expected, r, w.status.Offset)
+}
+
+func (w *tarWriter) Status() (content.Status, error) {
+	return w.status, nil
+}
+
+// Truncate only supports resetting the writer, like the containerd local
+// store.
+func (w *tarWriter) Truncate(size int64) error {
+	if size != 0 {
+		return fmt.Errorf("tar ingestor: truncate to %d: %w", size, errdefs.ErrNotImplemented)
+	}
+
+	w.buf.Reset()
+	if w.spool != nil {
+		if err := w.spool.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	w.digester = w.desc.Digest.Algorithm().Digester()
+	w.status.Offset = 0
+
+	return nil
+}
+
+// Close discards any uncommitted data.
+func (w *tarWriter) Close() error {
+	w.buf.Reset()
+	if w.spool != nil {
+		w.spool.Close()
+		os.Remove(w.spool.Name())
+		w.spool = nil
+	}
+
+	return nil
 }
@@ -0,0 +1,202 @@
+package ociutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestTarIngestor(t *testing.T) {
+	ctx := context.Background()
+
+	var blobs [][]byte
+	for i := 0; i < 8; i++ {
+		blobs = append(blobs, []byte(fmt.Sprintf("blob %d", i)))
+	}
+	// Large enough to be spooled to disk.
+	blobs = append(blobs, bytes.Repeat([]byte("x"), tarSpoolThreshold+1))
+
+	var buf bytes.Buffer
+	ing := NewTarIngestor(&buf)
+
+	// Write every blob twice concurrently, each should only be in the archive
+	// once.
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(blobs))
+	for _, data := range append(blobs, blobs...) {
+		wg.Add(1)
+		go func(data []byte) {
+			defer wg.Done()
+
+			desc := ocispec.Descriptor{
+				Digest: digest.FromBytes(data),
+				Size:   int64(len(data)),
+			}
+
+			err := content.WriteBlob(ctx, ing, desc.Digest.String(), bytes.NewReader(data), desc)
+			if err != nil && !errdefs.IsAlreadyExists(err) {
+				errs <- err
+			}
+		}(data)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	root := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(blobs[0]),
+		Size:      int64(len(blobs[0])),
+	}
+	err := ing.Finish(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := make(map[string][]byte)
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := entries[hdr.Name]; ok {
+			t.Fatalf("duplicate entry %q", hdr.Name)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = data
+	}
+
+	// Nothing may follow the end-of-archive trailer.
+	if rest, _ := io.ReadAll(&buf); len(bytes.Trim(rest, "\x00")) > 0 {
+		t.Fatalf("found %d bytes of data after end of archive", len(rest))
+	}
+
+	for _, data := range blobs {
+		dgst := digest.FromBytes(data)
+		name := "blobs/sha256/" + dgst.Encoded()
+		if !bytes.Equal(entries[name], data) {
+			t.Fatalf("blob %v missing or corrupt in archive", dgst)
+		}
+	}
+
+	if _, ok := entries[OciLayoutFileName]; !ok {
+		t.Fatalf("missing %v in archive", OciLayoutFileName)
+	}
+
+	var index ocispec.Index
+	err = json.Unmarshal(entries[OciIndexFileName], &index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != root.Digest {
+		t.Fatalf("expected index to contain %v, got %v", root.Digest, index.Manifests)
+	}
+}
+
+func TestTarIngestorRejectsBadContent(t *testing.T) {
+	ctx := context.Background()
+
+	ing := NewTarIngestor(io.Discard)
+
+	data := []byte("hello")
+	desc := ocispec.Descriptor{
+		Digest: digest.FromBytes([]byte("goodbye")),
+		Size:   int64(len(data)),
+	}
+
+	err := content.WriteBlob(ctx, ing, "bad", bytes.NewReader(data), desc)
+	if !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+
+	err = ing.Finish(desc)
+	if !errdefs.IsNotFound(err) {
+		t.Fatalf("expected missing root to be rejected, got %v", err)
+	}
+
+	// The archive can still be finished once the root is written.
+	desc.Digest = digest.FromBytes(data)
+	err = content.WriteBlob(ctx, ing, "good", bytes.NewReader(data), desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ing.Finish(desc)
+	if err != nil {
+		t.Fatalf("expected finish to succeed after the root was written, got %v", err)
+	}
+}
+
+// failingWriter fails once more than n bytes are written.
+type failingWriter struct {
+	n int
+}
+
+var errFailingWriter = errors.New("disk full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errFailingWriter
+	}
+
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestTarIngestorFailedWrite(t *testing.T) {
+	ctx := context.Background()
+
+	// Room for the headers of the blobs directories and of the first blob,
+	// but not for its content.
+	ing := NewTarIngestor(&failingWriter{n: 3 * 512})
+
+	write := func(data []byte) (ocispec.Descriptor, error) {
+		desc := ocispec.Descriptor{
+			Digest: digest.FromBytes(data),
+			Size:   int64(len(data)),
+		}
+
+		return desc, content.WriteBlob(ctx, ing, desc.Digest.String(), bytes.NewReader(data), desc)
+	}
+
+	_, err := write(bytes.Repeat([]byte("x"), 1024))
+	if !errors.Is(err, errFailingWriter) {
+		t.Fatalf("expected the write to fail, got %v", err)
+	}
+
+	// The archive has a truncated entry, nothing else can be written to it.
+	desc, err := write([]byte("hello"))
+	if !errors.Is(err, errFailingWriter) {
+		t.Errorf("expected the first failure after a failed write, got %v", err)
+	}
+
+	err = ing.Finish(desc)
+	if !errors.Is(err, errFailingWriter) {
+		t.Errorf("expected finish to fail after a failed write, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package ociutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ErrBlobMismatch is returned when the content of a blob doesn't match its
+// descriptor.
+var ErrBlobMismatch = errors.New("blob doesn't match its descriptor")
+
+// BlobMismatchError is the error of a blob whose content doesn't have the size
+// or digest of its descriptor.
+type BlobMismatchError struct {
+	// Path is the file of the blob, if it's known.
+	Path           string
+	ExpectedDigest digest.Digest
+	ActualDigest   digest.Digest
+	ExpectedSize   int64
+	ActualSize     int64
+}
+
+func (e *BlobMismatchError) Error() string {
+	blob := "blob " + e.ExpectedDigest.String()
+	if e.Path != "" {
+		blob = fmt.Sprintf("blob file %v", e.Path)
+	}
+
+	msg := fmt.Sprintf("%s: expected digest %v, got %v", blob, e.ExpectedDigest, e.ActualDigest)
+	if e.ExpectedSize != e.ActualSize {
+		msg += fmt.Sprintf(" (expected size %d, got %d)", e.ExpectedSize, e.ActualSize)
+	}
+
+	return msg
+}
+
+func (e *BlobMismatchError) Is(target error) bool {
+	return target == ErrBlobMismatch || target == errdefs.ErrFailedPrecondition
+}
+
+// VerifyingProvider wraps provider to check the size and digest of the blobs
+// against their descriptor. The size is checked when a blob is opened and the
+// digest as it's read: the read reaching the end of the blob fails with a
+// *BlobMismatchError if the content doesn't match. If the blob isn't read in
+// order, it's fully verified before the first read out of order.
+//
+// Descriptors without a size, e.g. when looking up a blob by digest, only have
+// their digest checked.
+func VerifyingProvider(provider content.Provider) content.Provider {
+	return &verifyingProvider{
+		provider: provider,
+	}
+}
+
+type verifyingProvider struct {
+	provider content.Provider
+}
+
+func (p *verifyingProvider) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	err := desc.Digest.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("can't verify blob: %w", err)
+	}
+
+	ra, err := p.provider.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	vra := &verifyingReaderAt{
+		ReaderAt: ra,
+		desc:     desc,
+		digester: desc.Digest.Algorithm().Digester(),
+	}
+	if named, ok := ra.(interface{ Name() string }); ok {
+		vra.path = named.Name()
+	}
+
+	if desc.Size > 0 && ra.Size() != desc.Size {
+		// Hash the content anyway to report the actual digest.
+		err = vra.verifyAll()
+		ra.Close()
+		return nil, err
+	}
+
+	return vra, nil
+}
+
+type verifyingReaderAt struct {
+	content.ReaderAt
+
+	desc ocispec.Descriptor
+	path string
+
+	mx       sync.Mutex
+	digester digest.Digester
+	// offset is the end of the content hashed so far.
+	offset   int64
+	verified bool
+	err      error
+}
+
+func (r *verifyingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	if !r.verified && off != r.offset {
+		err := r.verifyAll()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.ReaderAt.ReadAt(p, off)
+	if r.verified || off != r.offset {
+		return n, err
+	}
+
+	r.digester.Hash().Write(p[:n])
+	r.offset += int64(n)
+
+	if r.offset >= r.Size() || errors.Is(err, io.EOF) {
+		verr := r.check()
+		if verr != nil {
+			return n, verr
+		}
+	}
+
+	return n, err
+}
+
+// verifyAll hashes the whole content of the blob.
+func (r *verifyingReaderAt) verifyAll() error {
+	r.digester = r.desc.Digest.Algorithm().Digester()
+
+	var err error
+	r.offset, err = io.Copy(r.digester.Hash(), io.NewSectionReader(r.ReaderAt, 0, r.Size()))
+	if err != nil {
+		return fmt.Errorf("failed to read blob %v to verify it: %w", r.desc.Digest, err)
+	}
+
+	return r.check()
+}
+
+func (r *verifyingReaderAt) check() error {
+	r.verified = true
+
+	actual := r.digester.Digest()
+	expectedSize := r.desc.Size
+	if expectedSize == 0 {
+		expectedSize = r.offset
+	}
+
+	if actual != r.desc.Digest || r.offset != expectedSize {
+		r.err = &BlobMismatchError{
+			Path:           r.path,
+			ExpectedDigest: r.desc.Digest,
+			ActualDigest:   actual,
+			ExpectedSize:   expectedSize,
+			ActualSize:     r.offset,
+		}
+	}
+
+	return r.err
+}
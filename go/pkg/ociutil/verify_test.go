@@ -0,0 +1,207 @@
+package ociutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// fileProvider provides blobs from files, like a blob index.
+type fileProvider map[digest.Digest]string
+
+type sizedFile struct {
+	*os.File
+	size int64
+}
+
+func (f *sizedFile) Size() int64 {
+	return f.size
+}
+
+func (p fileProvider) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	path, ok := p[desc.Digest]
+	if !ok {
+		return nil, errdefs.ErrNotFound
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &sizedFile{File: f, size: st.Size()}, nil
+}
+
+// errReadAt is the error of failingReaderAt.
+var errReadAt = errors.New("I/O error")
+
+// failingReaderAt is a blob of the given size that can't be read.
+type failingReaderAt int64
+
+func (f failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errReadAt
+}
+
+func (f failingReaderAt) Size() int64 {
+	return int64(f)
+}
+
+func (f failingReaderAt) Close() error {
+	return nil
+}
+
+type failingProvider struct{}
+
+func (failingProvider) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	return failingReaderAt(desc.Size), nil
+}
+
+func TestVerifyingProvider(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	expected := []byte("hello, world")
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(expected),
+		Size:      int64(len(expected)),
+	}
+
+	path := filepath.Join(dir, "blob")
+	provider := VerifyingProvider(fileProvider{desc.Digest: path})
+
+	readBlob := func(desc ocispec.Descriptor) ([]byte, error) {
+		ra, err := provider.ReaderAt(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		defer ra.Close()
+
+		return io.ReadAll(content.NewReader(ra))
+	}
+
+	checkMismatch := func(t *testing.T, err error, actual []byte) {
+		t.Helper()
+
+		if !errors.Is(err, ErrBlobMismatch) {
+			t.Fatalf("expected a blob mismatch, got %v", err)
+		}
+
+		msg := err.Error()
+		for _, s := range []string{path, desc.Digest.String(), digest.FromBytes(actual).String()} {
+			if !strings.Contains(msg, s) {
+				t.Errorf("error %q doesn't contain %q", msg, s)
+			}
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		err := os.WriteFile(path, expected, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := readBlob(desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(expected) {
+			t.Errorf("expected %q, got %q", expected, data)
+		}
+
+		// Reading out of order verifies the whole blob first.
+		ra, err := provider.ReaderAt(ctx, desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ra.Close()
+
+		p := make([]byte, 5)
+		_, err = ra.ReadAt(p, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != "world" {
+			t.Errorf("expected %q, got %q", "world", p)
+		}
+	})
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		actual := []byte("hello, WORLD")
+		err := os.WriteFile(path, actual, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = readBlob(desc)
+		checkMismatch(t, err, actual)
+
+		ra, err := provider.ReaderAt(ctx, desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ra.Close()
+
+		_, err = ra.ReadAt(make([]byte, 5), 7)
+		checkMismatch(t, err, actual)
+	})
+
+	t.Run("size mismatch", func(t *testing.T) {
+		actual := []byte("hello")
+		err := os.WriteFile(path, actual, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = readBlob(desc)
+		checkMismatch(t, err, actual)
+
+		var mismatch *BlobMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("expected a *BlobMismatchError, got %T", err)
+		}
+		if mismatch.ExpectedSize != desc.Size || mismatch.ActualSize != int64(len(actual)) {
+			t.Errorf("expected sizes %d and %d, got %d and %d", desc.Size, len(actual), mismatch.ExpectedSize, mismatch.ActualSize)
+		}
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		ra, err := VerifyingProvider(failingProvider{}).ReaderAt(ctx, desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ra.Close()
+
+		// The whole blob is read to verify it first, and fails.
+		_, err = ra.ReadAt(make([]byte, 5), 7)
+		if !errors.Is(err, errReadAt) || errors.Is(err, ErrBlobMismatch) {
+			t.Errorf("expected the read error, got %v", err)
+		}
+	})
+
+	t.Run("unknown size", func(t *testing.T) {
+		actual := []byte("hello, WORLD!")
+		err := os.WriteFile(path, actual, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = readBlob(ocispec.Descriptor{Digest: desc.Digest})
+		checkMismatch(t, err, actual)
+	})
+}
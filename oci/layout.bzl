@@ -7,12 +7,19 @@ def _oci_layout_index_impl(ctx):
     all_files = []
     for blob in ctx.attr.blobs:
         desc = blob[OCIDescriptor]
-        blobs_map[desc.digest] = desc.file.path
+        blobs_map[desc.digest] = {"path": desc.file.path}
         all_files.append(desc.file)
 
+    # Remote blobs are fetched by ocitool when they're first read.
+    for digest, repository in ctx.attr.remote_blobs.items():
+        if digest not in blobs_map:
+            blobs_map[digest] = {"remote": repository}
+
+    # See IndexVersion in go/pkg/blob/blobindex.go for the format.
     obj = {
         # TODO
         #"index": ctx.attr.index[OCIDescriptor].file.path,
+        "version": 2,
         "blobs": blobs_map,
     }
 
@@ -37,6 +44,13 @@ oci_layout_index = rule(
         "blobs": attr.label_list(
             providers = [OCIDescriptor],
         ),
+        "remote_blobs": attr.string_dict(
+            doc = """
+                Blobs that aren't in the layout, e.g. the layers of a shallow
+                pull, by digest, with the repository they are fetched from
+                when they're first read.
+            """,
+        ),
     },
     outputs = {
         "json": "%{name}.layout.json",
@@ -12,6 +12,10 @@ def _oci_image_layout_impl(ctx):
     layout_files = ",".join([p.path for p in layout.files.to_list()])
 
     descriptor = ctx.attr.manifest[OCIDescriptor]
+    desc = descriptor.descriptor_file.path
+    if ctx.attr.ref_name:
+        desc = "{ref_name}={desc}".format(ref_name = ctx.attr.ref_name, desc = desc)
+
     out_dir = ctx.actions.declare_directory(ctx.label.name)
 
     ctx.actions.run(
@@ -27,9 +31,10 @@ def _oci_image_layout_impl(ctx):
             # provides no direct way to access this directory, so here we traverse
             # up 3 levels from the bin directory.
             "--layout-relative={root}".format(root = ctx.bin_dir.path + "/../../../"),
-            "--desc={desc}".format(desc = descriptor.descriptor_file.path),
+            "--desc={desc}".format(desc = desc),
             "--layout-files={layout_files}".format(layout_files = layout_files),
             "--out-dir={out_dir}".format(out_dir = out_dir.path),
+            "--link={link}".format(link = ctx.attr.link),
         ],
         inputs =
             depset(
@@ -63,6 +68,23 @@ oci_image_layout = rule(
             """,
             providers = [OCILayout],
         ),
+        "link": attr.string(
+            doc = """
+                How the blobs are materialized in the directory: "copy",
+                "hardlink", "reflink" or "auto" to reflink or hard link them,
+                whichever works first. Blobs that can't be linked, e.g. when
+                they are on another filesystem, are copied. Linked blobs share
+                their inode with the outputs they come from.
+            """,
+            default = "copy",
+            values = ["auto", "copy", "hardlink", "reflink"],
+        ),
+        "ref_name": attr.string(
+            doc = """
+                The 'org.opencontainers.image.ref.name' annotation of the
+                manifest in the layout's index.json.
+            """,
+        ),
         "_debug": attr.label(
             default = "//oci:debug",
             providers = [DebugInfo],
@@ -95,6 +95,7 @@ done
         {tool}  \\
         --layout {layout} \\
         --debug={debug} \\
+        --fetch-remote-blobs \\
         push \\
         --layout-relative {root} \\
         --desc {desc} \\